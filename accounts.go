@@ -0,0 +1,422 @@
+package wg
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Accounts are optional, without one a player is whoever their cookie says. An account is a login that maps to a
+// player id, so logging in on another device makes it the same player with the same seats in the same games.
+
+// AccountStorage keeps accounts, nil turns accounts off
+var AccountStorage AccountStore
+
+// AccountStore keeps accounts by name, names are unique
+type AccountStore interface {
+	// Account finds an account, nil if there isn't one by that name
+	Account(name string) (*Account, error)
+	SaveAccount(a *Account) error
+}
+
+// Account is a login, either a password kept here or a subject at a Provider
+type Account struct {
+	Name     string
+	PlayerId string
+	Hash     []byte `json:",omitempty"` // bcrypt of the password
+	Provider string `json:",omitempty"`
+	Created  time.Time
+}
+
+var (
+	ErrAccountTaken = errors.New("That name is taken")
+	ErrBadLogin     = errors.New("Wrong name or password")
+)
+
+const (
+	minPassword    = 8
+	maxAccountLen  = 64
+	accountMaxBody = 4 * 1024
+	loginState     = "WG_LOGIN_STATE"
+)
+
+// accountLock makes looking for a name and taking it one step
+var accountLock sync.Mutex
+
+// Register makes a password account, for playerId if it's set so the player keeps their games
+func Register(name, password, playerId string) (*Account, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAccountLen || strings.Contains(name, ":") {
+		return nil, errors.New("Names need 1 to 64 characters and no colons")
+	}
+	if len(password) < minPassword {
+		return nil, errors.New("Passwords need at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	if playerId == "" {
+		playerId = uuid.New().String()
+	}
+	return createAccount(&Account{Name: name, PlayerId: playerId, Hash: hash, Created: time.Now()})
+}
+
+func createAccount(a *Account) (*Account, error) {
+	accountLock.Lock()
+	defer accountLock.Unlock()
+	existing, err := AccountStorage.Account(a.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrAccountTaken
+	}
+	if err = AccountStorage.SaveAccount(a); err != nil {
+		return nil, err
+	}
+	log.Println("Player", a.PlayerId, "made account", a.Name)
+	return a, nil
+}
+
+// Login checks a password account's password
+func Login(name, password string) (*Account, error) {
+	a, err := AccountStorage.Account(strings.TrimSpace(name))
+	if err != nil {
+		return nil, err
+	}
+	if a == nil || len(a.Hash) == 0 {
+		// compare anyway so missing accounts take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrBadLogin
+	}
+	if bcrypt.CompareHashAndPassword(a.Hash, []byte(password)) != nil {
+		return nil, ErrBadLogin
+	}
+	return a, nil
+}
+
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// providerLogin finds the account for a subject at a provider, making one the first time they log in
+func providerLogin(provider, subject, playerId string) (*Account, error) {
+	name := provider + ":" + subject
+	a, err := AccountStorage.Account(name)
+	if err != nil || a != nil {
+		return a, err
+	}
+	if playerId == "" {
+		playerId = uuid.New().String()
+	}
+	a, err = createAccount(&Account{Name: name, PlayerId: playerId, Provider: provider, Created: time.Now()})
+	if err == ErrAccountTaken {
+		// logged in twice at once, the other one made it
+		return AccountStorage.Account(name)
+	}
+	return a, err
+}
+
+// Provider is an outside service players log in with, like an OpenID Connect provider
+type Provider interface {
+	// AuthURL is where the player goes to log in, the provider sends them back to callback with state
+	AuthURL(state, callback string) string
+	// Subject is who the provider says the player is, from the request to callback
+	Subject(r *http.Request) (string, error)
+}
+
+var providers = map[string]Provider{}
+
+// RegisterProvider lets players log in with p at /account/login/{name}
+func RegisterProvider(name string, p Provider) {
+	providers[name] = p
+}
+
+// StubProvider logs everyone in as As without asking, it stands in for a real provider when developing
+type StubProvider struct {
+	As string
+}
+
+func (p *StubProvider) AuthURL(state, callback string) string {
+	return callback + "?" + url.Values{"state": {state}, "code": {p.As}}.Encode()
+}
+
+func (p *StubProvider) Subject(r *http.Request) (string, error) {
+	return r.URL.Query().Get("code"), nil
+}
+
+// OIDCProvider logs players in with an OpenID Connect provider's authorization code flow, the subject comes from
+// its userinfo endpoint
+type OIDCProvider struct {
+	AuthEndpoint     string
+	TokenEndpoint    string
+	UserinfoEndpoint string
+	ClientId         string
+	ClientSecret     string
+	Client           *http.Client // http.DefaultClient if nil
+}
+
+func (p *OIDCProvider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
+}
+
+func (p *OIDCProvider) AuthURL(state, callback string) string {
+	return p.AuthEndpoint + "?" + url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientId},
+		"redirect_uri":  {callback},
+		"scope":         {"openid"},
+		"state":         {state},
+	}.Encode()
+}
+
+func (p *OIDCProvider) Subject(r *http.Request) (string, error) {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		return "", errors.New("No code from the provider")
+	}
+	resp, err := p.client().PostForm(p.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {callbackURL(r)},
+		"client_id":     {p.ClientId},
+		"client_secret": {p.ClientSecret},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", errors.New("The provider didn't give a token")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, p.UserinfoEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err = p.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var info struct {
+		Sub string `json:"sub"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil || info.Sub == "" {
+		return "", errors.New("The provider didn't say who the player is")
+	}
+	return info.Sub, nil
+}
+
+// callbackURL is where a provider sends the player back to, the same as r's URL when r is the callback
+func callbackURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	provider := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/account/login/"), "/account/callback/")
+	return scheme + "://" + r.Host + "/account/callback/" + provider
+}
+
+// LoginRequest is the body of POST /account/register and /account/login
+type LoginRequest struct {
+	Name     string
+	Password string
+}
+
+// AccountMsg answers a login, clients that keep the cookie themselves set Cookie and reconnect
+type AccountMsg struct {
+	Type   string
+	Name   string
+	Cookie string
+}
+
+// AccountAPI serves logins:
+//
+//	POST /account/register             makes a password account for the current player
+//	POST /account/login                logs in with a password
+//	POST /account/logout               forgets the player on this device
+//	GET  /account/login/{provider}     sends the player to log in with a provider
+//	GET  /account/callback/{provider}  where the provider sends them back
+//
+// POSTs must be application/json so other sites can't make them.
+func AccountAPI(w http.ResponseWriter, r *http.Request) {
+	if AccountStorage == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ip := clientIp(r)
+	if isBanned(ip) {
+		http.Error(w, "Too many tries, come back later", http.StatusTooManyRequests)
+		return
+	}
+
+	// a form on another site can post text/plain with the player's cookie attached, a JSON post needs the browser to
+	// ask first and nothing here says yes
+	if r.Method == http.MethodPost {
+		if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+			http.Error(w, "Expected application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/account"), "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case (path == "register" || path == "login") && r.Method == http.MethodPost:
+		var login LoginRequest
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, accountMaxBody))
+		if err == nil {
+			err = json.Unmarshal(b, &login)
+		}
+		if err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		var a *Account
+		if path == "register" {
+			a, err = Register(login.Name, login.Password, requestPlayer(r))
+		} else {
+			a, err = Login(login.Name, login.Password)
+		}
+		if err == ErrBadLogin {
+			failedLogin(ip)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err == ErrAccountTaken {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c := PlayerCookie(a.PlayerId)
+		http.SetCookie(w, c)
+		writeJSON(w, &AccountMsg{Type: "account", Name: a.Name, Cookie: c.String()})
+	case path == "logout" && r.Method == http.MethodPost:
+		http.SetCookie(w, &http.Cookie{Name: COOKIE_NAME, Path: "/", MaxAge: -1, SameSite: http.SameSiteLaxMode})
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[0] == "login" && r.Method == http.MethodGet:
+		provider, ok := providers[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		b := make([]byte, 16)
+		rand.Read(b)
+		state := base64.RawURLEncoding.EncodeToString(b)
+		http.SetCookie(w, &http.Cookie{Name: loginState, Value: state, Path: "/account/", MaxAge: 600, HttpOnly: true})
+		http.Redirect(w, r, provider.AuthURL(state, callbackURL(r)), http.StatusFound)
+	case len(parts) == 2 && parts[0] == "callback" && r.Method == http.MethodGet:
+		provider, ok := providers[parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		state, err := r.Cookie(loginState)
+		if err != nil || state.Value == "" || state.Value != r.URL.Query().Get("state") {
+			failedLogin(ip)
+			http.Error(w, "Login expired, try again", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: loginState, Path: "/account/", MaxAge: -1})
+		subject, err := provider.Subject(r)
+		if err != nil || subject == "" {
+			log.Println("Login with", parts[1], "failed", err)
+			http.Error(w, "Login failed", http.StatusUnauthorized)
+			return
+		}
+		a, err := providerLogin(parts[1], subject, requestPlayer(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, PlayerCookie(a.PlayerId))
+		http.Redirect(w, r, "/", http.StatusFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// MemoryAccounts keeps accounts until the server stops, for tests and trying things out
+type MemoryAccounts struct {
+	sync.Mutex
+	accounts map[string]*Account
+}
+
+func NewMemoryAccounts() *MemoryAccounts {
+	return &MemoryAccounts{accounts: map[string]*Account{}}
+}
+
+func (m *MemoryAccounts) Account(name string) (*Account, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.accounts[name], nil
+}
+
+func (m *MemoryAccounts) SaveAccount(a *Account) error {
+	m.Lock()
+	defer m.Unlock()
+	m.accounts[a.Name] = a
+	return nil
+}
+
+// accountPath names the file after the account in hex, account names can have any characters in them
+func (s *FileStore) accountPath(name string) string {
+	return filepath.Join(s.dir, "accounts", hex.EncodeToString([]byte(name))+".json")
+}
+
+func (s *FileStore) Account(name string) (*Account, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, err := ioutil.ReadFile(s.accountPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	a := &Account{}
+	return a, json.Unmarshal(b, a)
+}
+
+func (s *FileStore) SaveAccount(a *Account) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	path := s.accountPath(a.Name)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,122 @@
+package wg
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAccounts(t *testing.T) {
+	defer func() { AccountStorage = nil }()
+	AccountStorage = NewMemoryAccounts()
+
+	a, err := Register("alice", "correct horse", "player-1")
+	if err != nil || a.PlayerId != "player-1" {
+		t.Fatal("Expected the account to keep the player", a, err)
+	}
+	if _, err = Register("alice", "battery staple", ""); err != ErrAccountTaken {
+		t.Error("Expected names to be unique", err)
+	}
+	if _, err = Register("bob", "short", ""); err == nil {
+		t.Error("Expected short passwords to be refused")
+	}
+	if a, err = Login("alice", "correct horse"); err != nil || a.PlayerId != "player-1" {
+		t.Error("Expected to log in", a, err)
+	}
+	if _, err = Login("alice", "wrong horse"); err != ErrBadLogin {
+		t.Error("Expected a wrong password to fail", err)
+	}
+	if _, err = Login("nobody", "correct horse"); err != ErrBadLogin {
+		t.Error("Expected a missing account to fail the same way", err)
+	}
+}
+
+func TestAccountAPI(t *testing.T) {
+	defer func() { AccountStorage = nil }()
+	AccountStorage = NewMemoryAccounts()
+	RegisterProvider("stub", &StubProvider{As: "someone"})
+	defer delete(providers, "stub")
+
+	server := httptest.NewServer(http.HandlerFunc(AccountAPI))
+	defer server.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	// what a form on another site can send, with the victim's cookie
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/account/register", strings.NewReader(`{"Name":"mallory","Password":"hunter22"}`))
+	req.Header.Set("Content-Type", "text/plain")
+	req.AddCookie(PlayerCookie("victim"))
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Error("Expected a post that isn't JSON to be refused", err, resp.StatusCode)
+	}
+	if a, _ := AccountStorage.Account("mallory"); a != nil {
+		t.Error("Expected no account to be made", a)
+	}
+	if PlayerCookie("victim").SameSite != http.SameSiteLaxMode {
+		t.Error("Expected the player cookie to stay on this site")
+	}
+
+	resp, err := client.Post(server.URL+"/account/register", "application/json", strings.NewReader(`{"Name":"carol","Password":"hunter22"}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("Expected to register", err, resp.StatusCode)
+	}
+	carol, _ := AccountStorage.Account("carol")
+	if id := playerIn(jar, server.URL); id != carol.PlayerId {
+		t.Error("Expected the signed cookie for the account", id, carol.PlayerId)
+	}
+
+	resp, _ = client.Post(server.URL+"/account/login", "application/json", strings.NewReader(`{"Name":"carol","Password":"nope nope"}`))
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Error("Expected a wrong password to be refused", resp.StatusCode)
+	}
+
+	// logging in with a provider the first time makes an account for the player they already are
+	resp, err = client.Get(server.URL + "/account/login/stub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	someone, _ := AccountStorage.Account("stub:someone")
+	if someone == nil || someone.PlayerId != carol.PlayerId {
+		t.Error("Expected the provider account to be made for the player", someone)
+	}
+
+	// a callback without the state from the login is refused
+	resp, _ = http.Get(server.URL + "/account/callback/stub?state=guess&code=admin")
+	if b, _ := ioutil.ReadAll(resp.Body); resp.StatusCode != http.StatusBadRequest {
+		t.Error("Expected a forged callback to fail", resp.StatusCode, string(b))
+	}
+}
+
+func playerIn(jar http.CookieJar, u string) string {
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	for _, c := range jar.Cookies(req.URL) {
+		req.AddCookie(c)
+	}
+	return requestPlayer(req)
+}
+
+func TestFileStoreAccounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, _ := NewFileStore(dir)
+
+	if a, err := store.Account("dave/../x"); a != nil || err != nil {
+		t.Error("Expected no account yet", a, err)
+	}
+	if err = store.SaveAccount(&Account{Name: "dave/../x", PlayerId: "p"}); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := store.Account("dave/../x"); err != nil || a.PlayerId != "p" {
+		t.Error("Expected the account back", a, err)
+	}
+	if snaps, err := store.Load(); err != nil || len(snaps) != 0 {
+		t.Error("Accounts shouldn't show up as games", snaps, err)
+	}
+}
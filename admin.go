@@ -0,0 +1,175 @@
+package wg
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminToken guards the admin API, which is turned off while this is empty.
+// Requests need an "Authorization: Bearer <token>" header.
+var AdminToken string
+
+const (
+	// adminWait is how long the admin API waits on a game before giving up on it
+	adminWait = 5 * time.Second
+	// adminMaxBody is the biggest request body the admin API reads
+	adminMaxBody = 64 * 1024
+)
+
+// AnnounceRequest is the body of POST /admin/broadcast
+type AnnounceRequest struct {
+	Msg string
+}
+
+// Admin serves the admin API:
+//
+//	GET  /admin/games            every game, public or not
+//	GET  /admin/games/{id}       a snapshot of the game, including the private parts
+//	POST /admin/games/{id}/stop  stops the game and forgets it
+//	POST /admin/broadcast        shows a message in every game
+func Admin(w http.ResponseWriter, r *http.Request) {
+	if AdminToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin"), "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "games" && r.Method == http.MethodGet:
+		infos := AllGames.infos()
+		if infos == nil {
+			infos = []RoomInfo{}
+		}
+		writeJSON(w, infos)
+	case len(parts) == 2 && parts[0] == "games" && r.Method == http.MethodGet:
+		game := AllGames.Get(parts[1])
+		if game == nil {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		snap, err := game.inspect()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, snap)
+	case len(parts) == 3 && parts[0] == "games" && parts[2] == "stop" && r.Method == http.MethodPost:
+		game := AllGames.Get(parts[1])
+		if game == nil {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		AllGames.remove(game)
+		log.Println("Admin stopped game", game.Id)
+		w.WriteHeader(http.StatusNoContent)
+	case path == "broadcast" && r.Method == http.MethodPost:
+		var announce AnnounceRequest
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, adminMaxBody))
+		if err == nil {
+			err = json.Unmarshal(b, &announce)
+		}
+		if err != nil || announce.Msg == "" {
+			http.Error(w, "Expected a Msg", http.StatusBadRequest)
+			return
+		}
+		data, _ := json.Marshal(announce.Msg)
+		for _, id := range AllGames.Ids() {
+			if game := AllGames.Get(id); game != nil {
+				game.Inject(&Command{Type: cmdAnnounce, Data: data})
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// send gives the game a command, unless it has stopped or is stuck
+func (g *Game) send(cmd *Command) bool {
+	select {
+	case g.Cmd <- cmd:
+		return true
+	case <-g.done:
+	case <-time.After(adminWait):
+	}
+	return false
+}
+
+// inspect asks the game's goroutine for a snapshot, so the state isn't read while it changes
+func (g *Game) inspect() (*Snapshot, error) {
+	conn := &replyConn{reply: make(chan interface{}, 1)}
+	if !g.send(&Command{Type: cmdInspect, Ws: conn}) {
+		return nil, errors.New("game isn't running")
+	}
+	select {
+	case v := <-conn.reply:
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		return v.(*Snapshot), nil
+	case <-time.After(adminWait):
+		return nil, errors.New("game didn't answer")
+	}
+}
+
+// serverCommand handles the commands the server sends games, players can't send these
+func (g *Game) serverCommand(cmd *Command) bool {
+	if cmd.PlayerId != "" {
+		return false
+	}
+	switch cmd.Type {
+	case cmdTimeout:
+		return g.timeout(cmd)
+	case cmdMatch:
+		return g.startMatch(cmd)
+	case cmdInspect:
+		snap, err := g.snapshot()
+		if err != nil {
+			cmd.Ws.Send(err)
+		} else {
+			cmd.Ws.Send(snap)
+		}
+	case cmdAnnounce:
+		var msg string
+		if err := json.Unmarshal(cmd.Data, &msg); err == nil {
+			g.SendAll(&MsgMsg{Type: "msg", Msg: msg})
+		}
+	}
+	return false
+}
+
+// isAdmin is true when the request has the AdminToken
+func isAdmin(r *http.Request) bool {
+	return AdminToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+AdminToken)) == 1
+}
+
+// replyConn takes the one message a game sends back to the admin API
+type replyConn struct {
+	replayConn
+	reply chan interface{}
+}
+
+func (c *replyConn) Send(v interface{}) {
+	select {
+	case c.reply <- v:
+	default:
+	}
+}
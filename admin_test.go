@@ -0,0 +1,82 @@
+package wg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	admin := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		Admin(w, r)
+		return w
+	}
+
+	AdminToken = ""
+	if w := admin("GET", "/admin/games", "", ""); w.Code != http.StatusNotFound {
+		t.Error("Admin should be off without a token", w.Code)
+	}
+	AdminToken = "secret"
+	defer func() { AdminToken = "" }()
+	if w := admin("GET", "/admin/games", "wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Error("Expected the wrong token to be refused", w.Code)
+	}
+
+	game := NewGame(&testRules{}, "admin1")
+	game.Type = "test"
+	game.Start()
+	AllGames.Set(game)
+	conn := NewFakeConn("a")
+	game.Cmd <- &Command{PlayerId: "a", Ws: conn, Type: cmdJoin}
+
+	var infos []RoomInfo
+	w := admin("GET", "/admin/games", "secret", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil || len(infos) != 1 || infos[0].Id != "admin1" {
+		t.Fatal("Expected the private game to be listed", w.Body.String(), err)
+	}
+
+	var snap Snapshot
+	w = admin("GET", "/admin/games/admin1", "secret", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &snap); err != nil || snap.Id != "admin1" || snap.Host != "a" {
+		t.Error("Expected a snapshot of the game", w.Body.String(), err)
+	}
+	if w = admin("GET", "/admin/games/nope", "secret", ""); w.Code != http.StatusNotFound {
+		t.Error("Expected missing games to 404", w.Code)
+	}
+
+	if w = admin("POST", "/admin/broadcast", "secret", `{}`); w.Code != http.StatusBadRequest {
+		t.Error("Expected an empty announcement to be refused", w.Code)
+	}
+	if w = admin("POST", "/admin/broadcast", "secret", `{"Msg":"Restarting soon"}`); w.Code != http.StatusNoContent {
+		t.Error("Expected the announcement to be sent", w.Code)
+	}
+	for {
+		if msg, ok := (<-conn.Msgs).(*MsgMsg); ok && msg.Msg == "Restarting soon" {
+			break
+		}
+	}
+
+	if w = admin("POST", "/admin/games/admin1/stop", "secret", ""); w.Code != http.StatusNoContent {
+		t.Error("Expected the game to stop", w.Code)
+	}
+	<-game.done
+	if AllGames.Get("admin1") != nil {
+		t.Error("Stopped games should be forgotten")
+	}
+	var reaped *ReapedMsg
+	for len(conn.Msgs) > 0 {
+		if msg, ok := (<-conn.Msgs).(*ReapedMsg); ok {
+			reaped = msg
+		}
+	}
+	if reaped == nil || reaped.Reason != ReapStopped || !conn.Closed {
+		t.Error("Expected the players to be told the game was stopped and hung up on", reaped, conn.Closed)
+	}
+}
@@ -0,0 +1,171 @@
+package wg
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	chatScrollback = 50  // messages kept to replay to people joining
+	chatMaxLength  = 200 // characters, longer messages are cut off
+	chatRateLimit  = 5   // messages per chatRateWindow
+	chatRateWindow = 10 * time.Second
+)
+
+// ChatFilter is implemented by games that need to restrict chat, like silencing a player during a phase
+type ChatFilter interface {
+	// CanChat reports whether from is allowed to send a message to; to is nil for a message to the room
+	CanChat(from, to *Player) bool
+}
+
+type chatIn struct {
+	Text string
+	To   int // player id to whisper to, 0 is everyone
+}
+
+// ChatMsg is a message said by a player, it is sent to clients and kept for scrollback
+type ChatMsg struct {
+	Type string
+	From int
+	Name string
+	Text string
+	To   int `json:",omitempty"`
+	Time time.Time
+	// Hidden is who the game filtered out, so the message isn't replayed to them either
+	Hidden []int `json:",omitempty"`
+}
+
+// ChatHistoryMsg is sent when joining so the player can catch up on the conversation
+type ChatHistoryMsg struct {
+	Type string
+	Msgs []*ChatMsg
+}
+
+func (g *Game) chat(cmd *Command) bool {
+	from := g.Player(cmd.PlayerId)
+	if from == nil {
+		return false
+	}
+
+	var in chatIn
+	if err := json.Unmarshal(cmd.Data, &in); err != nil {
+		from.SendError(ErrBadData, "Got invalid data for chat")
+		return false
+	}
+	in.Text = strings.TrimSpace(in.Text)
+	if in.Text == "" {
+		return false
+	}
+	in.Text = truncate(in.Text, chatMaxLength)
+	if !g.allowChat(cmd.PlayerId) {
+		from.SendMsg("Slow down, you're chatting too fast")
+		return false
+	}
+
+	filter, _ := g.Rules.(ChatFilter)
+	msg := &ChatMsg{Type: "chat", From: from.Id, Name: from.Name, Text: in.Text, Time: g.clock.Now()}
+
+	if in.To != 0 {
+		var to *Player
+		for _, p := range g.Rules.Roster() {
+			if p.Id == in.To {
+				to = p
+			}
+		}
+		if to == nil {
+			from.SendMsg("No one to whisper to")
+			return false
+		}
+		if filter != nil && !filter.CanChat(from, to) {
+			from.SendMsg("You can't talk to them right now")
+			return false
+		}
+		msg.To = to.Id
+		to.Send(msg)
+		if to != from {
+			from.Send(msg)
+		}
+		g.keepChat(msg)
+		return false
+	}
+
+	if filter != nil && !filter.CanChat(from, nil) {
+		from.SendMsg("You can't chat right now")
+		return false
+	}
+	var to []*Player
+	for _, p := range g.Rules.Roster() {
+		if filter == nil || p == from || filter.CanChat(from, p) {
+			to = append(to, p)
+		} else {
+			msg.Hidden = append(msg.Hidden, p.Id)
+		}
+	}
+	for _, p := range to {
+		p.Send(msg)
+	}
+	g.sendSpectators(msg)
+	g.keepChat(msg)
+	return false
+}
+
+// allowChat is a sliding window rate limit on how much each player can say
+func (g *Game) allowChat(uuid string) bool {
+	if g.chatTimes == nil {
+		g.chatTimes = map[string][]time.Time{}
+	}
+	now := g.clock.Now()
+	var recent []time.Time
+	for _, t := range g.chatTimes[uuid] {
+		if now.Sub(t) < chatRateWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= chatRateLimit {
+		g.chatTimes[uuid] = recent
+		return false
+	}
+	g.chatTimes[uuid] = append(recent, now)
+	return true
+}
+
+func (g *Game) keepChat(msg *ChatMsg) {
+	g.scrollback = append(g.scrollback, msg)
+	if len(g.scrollback) > chatScrollback {
+		g.scrollback = g.scrollback[len(g.scrollback)-chatScrollback:]
+	}
+}
+
+// sendChatHistory replays the scrollback a player is allowed to see
+func (g *Game) sendChatHistory(p *Player) {
+	history := &ChatHistoryMsg{Type: "chathistory", Msgs: []*ChatMsg{}}
+	for _, msg := range g.scrollback {
+		if msg.From == p.Id || msg.To == p.Id || (msg.To == 0 && !hidden(msg, p)) {
+			history.Msgs = append(history.Msgs, msg)
+		}
+	}
+	if len(history.Msgs) > 0 {
+		p.Send(history)
+	}
+}
+
+// truncate cuts s to at most n characters, never in the middle of one
+func truncate(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+func hidden(msg *ChatMsg, p *Player) bool {
+	for _, id := range msg.Hidden {
+		if id == p.Id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package wg
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+type quietRules struct {
+	testRules
+	quiet *Player
+}
+
+func (r *quietRules) CanChat(from, to *Player) bool {
+	return r.quiet == nil || (from != r.quiet && to != r.quiet)
+}
+
+func drain(conn *FakeConn) []interface{} {
+	var msgs []interface{}
+	for len(conn.Msgs) > 0 {
+		msgs = append(msgs, <-conn.Msgs)
+	}
+	return msgs
+}
+
+func TestChat(t *testing.T) {
+	rules := &quietRules{}
+	game := NewGame(rules, "1")
+	conns := map[string]*FakeConn{}
+	for _, id := range []string{"a", "b", "c"} {
+		conns[id] = NewFakeConn(id)
+		game.handle(&Command{PlayerId: id, Ws: conns[id], Type: cmdJoin})
+	}
+
+	game.handle(&Command{PlayerId: "a", Type: cmdChat, Data: []byte(`{"Text":"hello"}`)})
+	for id, conn := range conns {
+		msgs := drain(conn)
+		if len(msgs) != 1 || msgs[0].(*ChatMsg).Text != "hello" {
+			t.Error("Expected", id, "to get hello", msgs)
+		}
+	}
+
+	game.handle(&Command{PlayerId: "a", Type: cmdChat, Data: []byte(`{"Text":"psst","To":2}`)})
+	if len(drain(conns["b"])) != 1 || len(drain(conns["a"])) != 1 || len(drain(conns["c"])) != 0 {
+		t.Error("Whisper should only go to the sender and receiver")
+	}
+
+	long := strings.Repeat("x", 500)
+	game.handle(&Command{PlayerId: "b", Type: cmdChat, Data: []byte(`{"Text":"` + long + `"}`)})
+	if msgs := drain(conns["c"]); len(msgs[0].(*ChatMsg).Text) != chatMaxLength {
+		t.Error("Long messages should be cut off")
+	}
+	drain(conns["a"])
+	drain(conns["b"])
+	accents := strings.Repeat("é", 500)
+	game.handle(&Command{PlayerId: "c", Type: cmdChat, Data: []byte(`{"Text":"` + accents + `"}`)})
+	if text := drain(conns["a"])[0].(*ChatMsg).Text; !utf8.ValidString(text) || utf8.RuneCountInString(text) != chatMaxLength {
+		t.Error("Long messages should be cut off between characters", len(text))
+	}
+	drain(conns["b"])
+	drain(conns["c"])
+
+	rules.quiet = rules.players[2]
+	game.handle(&Command{PlayerId: "a", Type: cmdChat, Data: []byte(`{"Text":"not for c"}`)})
+	if len(drain(conns["c"])) != 0 || len(drain(conns["b"])) != 1 {
+		t.Error("Filter should keep c out of the conversation")
+	}
+	drain(conns["a"])
+	game.handle(&Command{PlayerId: "c", Type: cmdChat, Data: []byte(`{"Text":"hi"}`)})
+	if msgs := drain(conns["c"]); len(msgs) != 1 || msgs[0].(*MsgMsg) == nil || len(drain(conns["a"])) != 0 {
+		t.Error("Filtered player should be told they can't chat")
+	}
+	rules.quiet = nil
+
+	// a has now said 3 things, the rest are limited
+	for i := 0; i < chatRateLimit; i++ {
+		game.handle(&Command{PlayerId: "a", Type: cmdChat, Data: []byte(`{"Text":"spam"}`)})
+	}
+	if msgs := drain(conns["b"]); len(msgs) != chatRateLimit-3 {
+		t.Error("Expected chat to be rate limited", len(msgs))
+	}
+
+	rejoin := NewFakeConn("c")
+	game.handle(&Command{PlayerId: "c", Ws: rejoin, Type: cmdJoin})
+	history := (<-rejoin.Msgs).(*ChatHistoryMsg)
+	for _, msg := range history.Msgs {
+		if msg.Text == "psst" || msg.Text == "not for c" {
+			t.Error("Whispers and filtered chat shouldn't be replayed to other players")
+		}
+	}
+	if history.Msgs[0].Text != "hello" {
+		t.Error("Expected scrollback to be replayed", history.Msgs)
+	}
+}
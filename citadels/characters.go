@@ -14,11 +14,11 @@ var Assassin = &Character{
 	func(c *Citadels, player *Player, data json.RawMessage) bool {
 		var choice int
 		if err := json.Unmarshal(data, &choice); err != nil {
-			sendMsg(player.ws, "Couldn't unmarshal choice")
+			player.SendMsg("Couldn't unmarshal choice")
 			return false
 		}
 		if choice < 0 || choice > 8 {
-			sendMsg(player.ws, "Invalid assassination")
+			player.SendMsg("Invalid assassination")
 			return false
 		}
 		c.Kill = choice
@@ -32,39 +32,42 @@ var Thief = &Character{
 	func(c *Citadels, player *Player, data json.RawMessage) bool {
 		var choice int
 		if err := json.Unmarshal(data, &choice); err != nil {
-			sendMsg(player.ws, "Couldn't unmarshal choice")
+			player.SendMsg("Couldn't unmarshal choice")
 			return false
 		}
 		if choice < 2 || choice >= 8 || choice == c.Kill {
-			sendMsg(player.ws, "Cannot steal from assassin or assassin's target")
+			player.SendMsg("Cannot steal from assassin or assassin's target")
 			return false
 		}
 		target := c.characters[choice].player
 		if target != nil {
 			player.Gold += target.Gold
 			target.Gold = 0
-			sendMsg(target.ws, "Thief stole all of your gold")
+			target.SendMsg("Thief stole all of your gold")
 		}
 		return true
 	},
 }
 
+// magicianChoice either swaps hands with a player or redraws some cards
+type magicianChoice struct {
+	Swap   *int
+	Redraw []int
+}
+
 var Magician = &Character{
 	"Magician",
 	None,
 	func(c *Citadels, player *Player, data json.RawMessage) bool {
-		var choice struct {
-			Swap   *int
-			Redraw []int
-		}
+		var choice magicianChoice
 		if err := json.Unmarshal(data, &choice); err != nil {
-			sendMsg(player.ws, "Couldn't unmarshal choice")
+			player.SendMsg("Couldn't unmarshal choice")
 			return false
 		}
 		if choice.Swap != nil {
 			value := *choice.Swap
 			if value < 0 || value == 2 || value >= 8 {
-				sendMsg(player.ws, "Invalid card swap target")
+				player.SendMsg("Invalid card swap target")
 				return false
 			}
 			c.Players[value].hand, c.Players[2].hand = c.Players[2].hand, c.Players[value].hand
@@ -76,7 +79,7 @@ var Magician = &Character{
 				if choice.Redraw[i] > 0 && choice.Redraw[i] < len(c.Players[2].hand) {
 					validIndices = append(validIndices, player.hand[choice.Redraw[i]])
 				} else {
-					sendMsg(player.ws, "Invalid redraw target")
+					player.SendMsg("Invalid redraw target")
 					return false
 				}
 			}
@@ -86,7 +89,7 @@ var Magician = &Character{
 				c.districtDeck = c.districtDeck[1:]
 			}
 		}
-		sendMsg(player.ws, "Magician didn't do special power?")
+		player.SendMsg("Magician didn't do special power?")
 		return false
 	},
 }
@@ -123,7 +126,7 @@ var Architect = &Character{
 	None,
 	func(c *Citadels, player *Player, data json.RawMessage) bool {
 		if c.State != build {
-			sendMsg(player.ws, "Must use power after action phase")
+			player.SendMsg("Must use power after action phase")
 			return false
 		}
 		player.hand = append(player.hand, c.districtDeck[:2]...)
@@ -143,32 +146,32 @@ var Warlord = &Character{
 	func(c *Citadels, player *Player, data json.RawMessage) bool {
 		var choice warlordAction
 		if err := json.Unmarshal(data, &choice); err != nil {
-			sendMsg(player.ws, "Couldn't unmarshal choice")
+			player.SendMsg("Couldn't unmarshal choice")
 			return false
 		}
 		if choice.Player < 0 || choice.Player > len(c.Players){
-			sendMsg(player.ws, "Invalid player")
+			player.SendMsg("Invalid player")
 			return false
 		}
 		p := c.Players[choice.Player]
 		if c.characters[4].Character == Bishop && c.characters[4].player == p {
-			sendMsg(player.ws, "Bishop is immune to Warlord")
+			player.SendMsg("Bishop is immune to Warlord")
 			return false
 		}
 		if choice.District < 0 || choice.District > len(p.Districts) {
-			sendMsg(player.ws, "Invalid district")
+			player.SendMsg("Invalid district")
 			return false
 		}
 		d := p.Districts[choice.District]
 		if d.Value - 1 > player.Gold {
-			sendMsg(player.ws, "You need more gold to destroy that")
+			player.SendMsg("You need more gold to destroy that")
 			return false
 		}
 		if p != player {
-			sendMsg(p.ws, "Warlord has destroyed your "+d.Name)
-			sendMsg(player.ws, "You destroyed that player's "+d.Name)
+			p.SendMsg("Warlord has destroyed your "+d.Name)
+			player.SendMsg("You destroyed that player's "+d.Name)
 		} else {
-			sendMsg(player.ws, "You destroyed your "+d.Name)
+			player.SendMsg("You destroyed your "+d.Name)
 		}
 		return true
 	},
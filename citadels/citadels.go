@@ -3,19 +3,43 @@ package citadels
 import (
 	"github.com/jakecoffman/wg"
 	"log"
-	"time"
-	"runtime/debug"
-	"math/rand"
 	"encoding/json"
+	"errors"
 	"sort"
 	"fmt"
 )
 
+// Name is the game type, used to restore saved games
+const Name = "citadels"
+
+func init() {
+	wg.RegisterRestorer(Name, Restore)
+	wg.RegisterBuilder(Name, create)
+	wg.RegisterResults(Name)
+	wg.RegisterMatchmaking(Name, wg.Matchmaking{Min: 2, Max: 7})
+	wg.RegisterCatalog(Name, wg.Catalog{
+		Commands: map[string]interface{}{
+			cmdName:    "",
+			cmdReady:   nil,
+			cmdStart:   nil,
+			cmdChoose:  0,
+			cmdAction:  wg.OneOf{0, []int{}}, // gold or draw, then which cards to put back
+			cmdBuild:   []int{},
+			cmdSpecial: wg.OneOf{0, magicianChoice{}, warlordAction{}},
+			cmdTax:     nil,
+			cmdEnd:     nil,
+		},
+		Messages: map[string]interface{}{
+			"all":  UpdateMsg{},
+			"info": GameMsg{},
+		},
+	})
+}
+
 type Citadels struct {
 	*wg.Game
 
-	Players      []*Player
-	playerCursor int
+	Players []*Player
 
 	Turn         Circular // used to tell whose turn it is
 	CharCur      int
@@ -51,6 +75,11 @@ func (c *Circular) MarshalJSON() ([]byte, error) {
 	return json.Marshal(c.Value)
 }
 
+// UnmarshalJSON reads back what MarshalJSON wrote, so clients can decode views, Max isn't sent
+func (c *Circular) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, &c.Value)
+}
+
 // Inc acts like ++ in other languages: returns the current value and then increments, however if the
 // value is more than the max, the value resets to 0.
 func (c *Circular) Inc() int {
@@ -63,12 +92,7 @@ func (c *Circular) Inc() int {
 }
 
 type Player struct {
-	ws        wg.Connector
-	Uuid      string `json:"-"`
-	Id        int
-	Name      string
-	Connected bool
-	Ip        string `json:"-"`
+	*wg.Player
 
 	IsBot     bool // TODO implement bots for this game
 	HasCrown  bool
@@ -80,17 +104,128 @@ type Player struct {
 	IsReady bool `json:",omitempty"`
 }
 
-func NewGame(id string) *wg.Game {
+func NewGame(id string, opts ...wg.Option) *wg.Game {
+	game := create(id, opts...)
+	game.Start()
+	return game
+}
+
+func create(id string, opts ...wg.Option) *wg.Game {
 	c := &Citadels{
-		Players:      []*Player{},
-		playerCursor: 1,
+		Players: []*Player{},
 	}
-	c.Game = wg.NewGame(c, id)
-	go c.run()
+	c.Game = wg.NewGame(c, id, opts...)
+	c.Type = Name
+	c.StrictVersion = true
 	c.reset()
 	return c.Game
 }
 
+// state is everything in Citadels that needs saving, pointers are flattened into names and indexes
+type state struct {
+	Players      []*playerState
+	Turn         [2]int // value, max
+	CharCur      int
+	State        State
+	Characters   []*characterState
+	DistrictDeck []*District
+	Crown        [2]int // value, max
+	FirstToEight int
+	Kill         int
+}
+
+type playerState struct {
+	Player
+	Uuid string
+	Hand []*District
+}
+
+type characterState struct {
+	Name                   string
+	Chosen                 bool
+	Player                 int // -1 when no one has this character
+	HasTaxed, HasSpecialed bool
+}
+
+// Characters is every character that can be in a game, by name
+var Characters = map[string]*Character{}
+
+func init() {
+	for _, char := range []*Character{Assassin, Thief, Magician, King, Bishop, Merchant, Architect, Warlord} {
+		Characters[char.Name] = char
+	}
+}
+
+func (c *Citadels) MarshalState() (json.RawMessage, error) {
+	s := &state{
+		Turn:         [2]int{c.Turn.Value, c.Turn.Max},
+		CharCur:      c.CharCur,
+		State:        c.State,
+		DistrictDeck: c.districtDeck,
+		Crown:        [2]int{c.crown.Value, c.crown.Max},
+		FirstToEight: c.FirstToEight,
+		Kill:         c.Kill,
+	}
+	for _, p := range c.Players {
+		s.Players = append(s.Players, &playerState{Player: *p, Uuid: p.Uuid, Hand: p.hand})
+	}
+	for _, char := range c.characters {
+		cs := &characterState{Name: char.Name, Chosen: char.Chosen, Player: -1, HasTaxed: char.HasTaxed, HasSpecialed: char.HasSpecialed}
+		if char.player != nil {
+			_, cs.Player = Find(c.Players, char.player.Uuid)
+		}
+		s.Characters = append(s.Characters, cs)
+	}
+	return json.Marshal(s)
+}
+
+// Restore brings a saved game back, everyone starts out disconnected
+func Restore(snap *wg.Snapshot) (*wg.Game, error) {
+	var s state
+	if err := json.Unmarshal(snap.State, &s); err != nil {
+		return nil, err
+	}
+	c := &Citadels{
+		Players:      []*Player{},
+		Turn:         Circular{Value: s.Turn[0], Max: s.Turn[1]},
+		CharCur:      s.CharCur,
+		State:        s.State,
+		districtDeck: s.DistrictDeck,
+		crown:        Circular{Value: s.Crown[0], Max: s.Crown[1]},
+		FirstToEight: s.FirstToEight,
+		Kill:         s.Kill,
+	}
+	for _, ps := range s.Players {
+		p := ps.Player
+		if p.Player == nil {
+			p.Player = &wg.Player{}
+		}
+		p.Uuid = ps.Uuid
+		p.hand = ps.Hand
+		p.Connected = false
+		c.Players = append(c.Players, &p)
+	}
+	for _, cs := range s.Characters {
+		char, ok := Characters[cs.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown character %v", cs.Name)
+		}
+		chosen := &ChoosableCharacter{Character: char, Chosen: cs.Chosen, HasTaxed: cs.HasTaxed, HasSpecialed: cs.HasSpecialed}
+		if cs.Player >= 0 && cs.Player < len(c.Players) {
+			chosen.player = c.Players[cs.Player]
+		}
+		c.characters = append(c.characters, chosen)
+	}
+	if c.districtDeck == nil {
+		c.districtDeck = make([]*District, 0, len(Districts))
+	}
+	c.Game = wg.RestoreGame(c, snap)
+	c.StrictVersion = true
+	c.timers()
+	c.Start()
+	return c.Game, nil
+}
+
 func (c *Citadels) reset() {
 	c.districtDeck = make([]*District, 0, len(Districts))
 	c.crown = Circular{Value: 0, Max: len(c.Players)}
@@ -148,9 +283,7 @@ const (
 	cmdReady      = "ready"
 
 	// anyone can do these things
-	cmdAddBot    = "addbot"
-	cmdRemoveBot = "removebot"
-	cmdStart     = "start"
+	cmdStart = "start"
 
 	cmdChoose = "choose"
 
@@ -162,48 +295,17 @@ const (
 	cmdEnd     = "end"
 )
 
-func (c *Citadels) run() {
-	var cmd *wg.Command
-
-	defer func() {
-		if r := recover(); r != nil {
-			log.Println("Game crashed", r)
-			log.Printf("State: %#v\n", c)
-			log.Println("Last command received:", cmd)
-			debug.PrintStack()
-		}
-	}()
-
-	var update bool
-	for {
-		cmd = <-c.Cmd
-
-		if c.Version != cmd.Version {
-			continue
-		}
-
-		if cmd.Type == cmdStop {
-			return
-		} else {
-			update = c.handler(cmd)
-		}
-
-		if update {
-			c.sendEveryoneEverything()
-			c.Updated = time.Now()
-		}
+func (c *Citadels) Roster() []*wg.Player {
+	var players []*wg.Player
+	for _, p := range c.Players {
+		players = append(players, p.Player)
 	}
-
+	return players
 }
 
-func (c *Citadels) handler(cmd *wg.Command) bool {
+func (c *Citadels) HandleCommand(cmd *wg.Command) bool {
+	defer c.timers()
 	switch cmd.Type {
-	case cmdJoin:
-		return c.handleJoin(cmd)
-	case cmdLeave:
-		return c.handleLeave(cmd)
-	case cmdDisconnect:
-		return c.handleDisconnect(cmd)
 	case cmdName:
 		return c.handleName(cmd)
 	case cmdStart:
@@ -228,6 +330,38 @@ func (c *Citadels) handler(cmd *wg.Command) bool {
 	}
 }
 
+// Timers keep a player from holding up everyone else on their turn
+func (c *Citadels) Timers() map[string]int {
+	return map[string]int{goldOrDraw.String(): 60, build.String(): 120}
+}
+
+// Timeout takes gold for a player that didn't pick, or ends the turn of one that didn't finish building
+func (c *Citadels) Timeout(phase string) bool {
+	defer c.timers()
+	p := c.Players[c.Turn.Value]
+	switch {
+	case phase == goldOrDraw.String() && c.State == goldOrDraw:
+		p.SendMsg("Time ran out so you took gold")
+		return c.handleAction(&wg.Command{PlayerId: p.Uuid, Data: []byte("0")})
+	case phase == build.String() && (c.State == build || c.State == endTurn):
+		p.SendMsg("Time ran out so your turn is over")
+		return c.handleEndTurn(&wg.Command{PlayerId: p.Uuid})
+	}
+	return false
+}
+
+// timers arms the timer for the phase the game is in
+func (c *Citadels) timers() {
+	switch c.State {
+	case goldOrDraw:
+		c.Arm(goldOrDraw.String())
+	case build, endTurn:
+		c.Arm(build.String())
+	default:
+		c.Disarm()
+	}
+}
+
 // Find returns the player object and the position they are in
 func Find(players []*Player, uuid string) (*Player, int) {
 	for i, player := range players {
@@ -238,79 +372,47 @@ func Find(players []*Player, uuid string) (*Player, int) {
 	return nil, -1
 }
 
-type MsgMsg struct {
-	Type string
-	Msg  string
-}
-
-func sendMsg(c wg.Connector, msg string) {
-	c.Send(&MsgMsg{Type: "msg", Msg: msg})
-}
-
-func (c *Citadels) handleJoin(cmd *wg.Command) bool {
-	player, i := Find(c.Players, cmd.PlayerId)
+func (c *Citadels) OnJoin(p *wg.Player) error {
+	_, i := Find(c.Players, p.Uuid)
 	if i == -1 {
 		// player was not here before
 		if c.State != lobby {
-			sendMsg(cmd.Ws, "Can't join game in progress")
-			return false
+			return errors.New("Can't join game in progress")
 		}
 		if len(c.Players) >= 10 {
 			// can't have more than 10 players
-			sendMsg(cmd.Ws, "Can't have more than 10 players")
-			return false
+			return errors.New("Can't have more than 10 players")
 		}
-		player = &Player{Uuid: cmd.PlayerId, Id: c.playerCursor}
-		c.Players = append(c.Players, player)
-		c.playerCursor += 1
+		c.Players = append(c.Players, &Player{Player: p})
 	}
-	player.ws = cmd.Ws
-	player.Connected = true
-	player.Ip = player.ws.Ip()
 
 	if c.State != lobby {
-		c.sendGameInfo(cmd.Ws)
+		c.sendGameInfo(p)
 	}
 
-	log.Println("Player", player.Id, "joined")
-	return true
+	log.Println("Player", p.Id, "joined")
+	return nil
 }
 
-func (c *Citadels) handleLeave(cmd *wg.Command) bool {
-	for i, player := range c.Players {
-		if player.Uuid == cmd.PlayerId {
-			c.Players = append(c.Players[0:i], c.Players[i+1:]...)
-			log.Println("Player", i, "left")
-			return true
-		}
-	}
-	return false
-}
-
-func (c *Citadels) handleDisconnect(cmd *wg.Command) bool {
-	player, i := Find(c.Players, cmd.PlayerId)
-	if i == -1 {
-		log.Println("Couldn't find player", cmd.PlayerId)
-		return false
-	}
-	player.ws = nil
-	player.Connected = false
-	return true
+func (c *Citadels) OnLeave(p *wg.Player) {
+	_, i := Find(c.Players, p.Uuid)
+	c.Players = append(c.Players[0:i], c.Players[i+1:]...)
+	log.Println("Player", i, "left")
 }
 
 func (c *Citadels) handleStart(cmd *wg.Command) bool {
 	if c.Version != cmd.Version {
-		sendMsg(cmd.Ws, "Someone else started the game first")
+		cmd.SendMsg("Someone else started the game first")
 		return false
 	}
 
 	if c.State != lobby {
-		sendMsg(cmd.Ws, "Illegal state")
+		cmd.SendMsg("Illegal state")
 		return false
 	}
 
 	if len(c.Players) < 2 || len(c.Players) > 7 {
-		sendMsg(cmd.Ws, "Need 2-7 players to start the game")
+		cmd.SendMsg("Need 2-7 players to start the game")
 		return false
 	}
 
@@ -323,7 +425,7 @@ func (c *Citadels) handleStart(cmd *wg.Command) bool {
 	// remove unconnected players and shuffle them, leader always starts in position 1
 	{
 		var newPlayers []*Player
-		walk := rand.Perm(len(c.Players))
+		walk := c.Rand.Perm(len(c.Players))
 		for _, i := range walk {
 			if !c.Players[i].IsBot && !c.Players[i].Connected {
 				continue
@@ -349,14 +451,14 @@ func (c *Citadels) handleStart(cmd *wg.Command) bool {
 		{Character: Warlord},
 	}
 	// shuffle the deck
-	for _, i := range rand.Perm(len(Districts)) {
+	for _, i := range c.Rand.Perm(len(Districts)) {
 		c.districtDeck = append(c.districtDeck, Districts[i])
 	}
 
 	// 2 player variant only: discard 1 without anyone seeing
 	// TODO other player variants
 	if len(c.Players) == 2 {
-		c.characters[rand.Intn(8)].Chosen = true
+		c.characters[c.Rand.Intn(8)].Chosen = true
 	}
 
 	// deal 4 districts to each player, and give starting gold
@@ -369,9 +471,7 @@ func (c *Citadels) handleStart(cmd *wg.Command) bool {
 
 	// tell all players the characters in this game
 	for _, p := range c.Players {
-		if p.ws != nil {
-			c.sendGameInfo(p.ws)
-		}
+		c.sendGameInfo(p.Player)
 	}
 
 	log.Println("Game started")
@@ -382,24 +482,16 @@ func (c *Citadels) handleStart(cmd *wg.Command) bool {
 func (c *Citadels) handleName(cmd *wg.Command) bool {
 	p, _ := Find(c.Players, cmd.PlayerId)
 	if c.State != lobby && p.Name != "" {
-		sendMsg(p.ws, "Wait for the lobby to change your name again")
+		p.SendMsg("Wait for the lobby to change your name again")
 		return false
 	}
 
-	var name string
-	err := json.Unmarshal(cmd.Data, &name)
-	if err != nil {
+	if err := p.Rename(cmd.Data); err != nil {
 		log.Println(err)
-		sendMsg(p.ws, "Got invalid data for name")
+		p.SendMsg("Got invalid data for name")
 		return false
 	}
 
-	if len(name) > 8 {
-		p.Name = name[0:8]
-	} else {
-		p.Name = name
-	}
-
 	return true
 }
 
@@ -418,17 +510,17 @@ func (c *Citadels) handleChoose(cmd *wg.Command) bool {
 	var choice int
 	if err := json.Unmarshal(cmd.Data, &choice); err != nil {
 		log.Println(err)
-		sendMsg(p.ws, "Couldn't decode choice")
+		p.SendMsg("Couldn't decode choice")
 		return false
 	}
 
 	if choice > 8 || choice < 0 {
-		sendMsg(p.ws, "Invalid choice")
+		p.SendMsg("Invalid choice")
 		return false
 	}
 
 	if c.characters[choice].Chosen {
-		sendMsg(p.ws, "Character already chosen")
+		p.SendMsg("Character already chosen")
 		return false
 	}
 
@@ -494,11 +586,11 @@ func (c *Citadels) handleChoose(cmd *wg.Command) bool {
 func (c *Citadels) handleAction(cmd *wg.Command) bool {
 	p, _ := Find(c.Players, cmd.PlayerId)
 	if p != c.Players[c.Turn.Value] {
-		sendMsg(p.ws, "Not your turn yet")
+		p.SendMsg("Not your turn yet")
 		return false
 	}
 	if c.State < goldOrDraw || c.State > putCardBack {
-		sendMsg(p.ws, "It's not time for actions")
+		p.SendMsg("It's not time for actions")
 		return false
 	}
 
@@ -507,7 +599,7 @@ func (c *Citadels) handleAction(cmd *wg.Command) bool {
 		var choice int
 		if err := json.Unmarshal(cmd.Data, &choice); err != nil {
 			log.Println(err)
-			sendMsg(p.ws, "couldn't unmarshal choice")
+			p.SendMsg("couldn't unmarshal choice")
 			return false
 		}
 		// merchant draws an additional gold
@@ -534,25 +626,24 @@ func (c *Citadels) handleAction(cmd *wg.Command) bool {
 
 	if c.State != putCardBack {
 		panic(fmt.Sprintln("Wrong state:", c.State))
-		return false
 	}
 
 	var choices []int
 	if err := json.Unmarshal(cmd.Data, &choices); err != nil {
 		log.Println(err)
-		sendMsg(p.ws, "couldn't unmarshal choice")
+		p.SendMsg("couldn't unmarshal choice")
 		return false
 	}
 
 	if len(choices) != 1 {
-		sendMsg(p.ws, "select one card to put back")
+		p.SendMsg("select one card to put back")
 		return false
 	}
 
 	choice := choices[0]
 
 	if choice < len(p.hand)-2 || choice > len(p.hand)-1 {
-		sendMsg(p.ws, "discard one of the cards you drew (last 2)")
+		p.SendMsg("discard one of the cards you drew (last 2)")
 		return false
 	}
 
@@ -570,18 +661,18 @@ func (c *Citadels) handleBuild(cmd *wg.Command) bool {
 	p, _ := Find(c.Players, cmd.PlayerId)
 	if p != c.Players[c.Turn.Value] {
 		log.Println("Not your turn yet")
-		sendMsg(p.ws, "Not your turn yet")
+		p.SendMsg("Not your turn yet")
 		return false
 	}
 	if c.State != build {
-		sendMsg(p.ws, "It's not time to build")
+		p.SendMsg("It's not time to build")
 		return false
 	}
 
 	var choices []int
 	if err := json.Unmarshal(cmd.Data, &choices); err != nil {
 		log.Println(err)
-		sendMsg(p.ws, "Couldn't unmarshal choice")
+		p.SendMsg("Couldn't unmarshal choice")
 		return false
 	}
 
@@ -591,12 +682,12 @@ func (c *Citadels) handleBuild(cmd *wg.Command) bool {
 	}
 
 	if c.CharCur == 6 && c.characters[6].Character == Architect && len(choices) > 3 {
-		sendMsg(p.ws, "Architect can only build up to three times per round")
+		p.SendMsg("Architect can only build up to three times per round")
 		return false
 	} else {
 		if len(choices) > 1 {
 			log.Println("Player tried to build too many times")
-			sendMsg(p.ws, "Only architect can build more than once per round")
+			p.SendMsg("Only architect can build more than once per round")
 			return false
 		}
 	}
@@ -610,12 +701,12 @@ func (c *Citadels) handleBuild(cmd *wg.Command) bool {
 	for _, choice := range choices {
 		chosenDistrict := p.hand[choice]
 		if p.Gold < chosenDistrict.Value {
-			sendMsg(p.ws, "You can't afford a district")
+			p.SendMsg("You can't afford a district")
 			return false
 		}
 		for _, district := range p.Districts {
 			if district.Name == chosenDistrict.Name {
-				sendMsg(p.ws, "Can't have duplicate districts")
+				p.SendMsg("Can't have duplicate districts")
 				return false
 			}
 		}
@@ -623,7 +714,7 @@ func (c *Citadels) handleBuild(cmd *wg.Command) bool {
 	}
 
 	if sum > p.Gold {
-		sendMsg(p.ws, "You can't afford all of these")
+		p.SendMsg("You can't afford all of these")
 		return false
 	}
 
@@ -647,7 +738,7 @@ func (c *Citadels) handleBuild(cmd *wg.Command) bool {
 func (c *Citadels) handleEndTurn(cmd *wg.Command) bool {
 	p, _ := Find(c.Players, cmd.PlayerId)
 	if p != c.Players[c.Turn.Value] {
-		sendMsg(p.ws, "Not your turn yet")
+		p.SendMsg("Not your turn yet")
 		return false
 	}
 
@@ -659,7 +750,7 @@ func (c *Citadels) handleEndTurn(cmd *wg.Command) bool {
 				c.crown.Value = c.Turn.Value
 			}
 			if c.Kill == c.CharCur {
-				sendMsg(c.Players[c.Turn.Value].ws, "You were killed by the Assassin")
+				c.Players[c.Turn.Value].SendMsg("You were killed by the Assassin")
 				continue
 			}
 			c.State = goldOrDraw
@@ -694,6 +785,14 @@ func (c *Citadels) handleEndTurn(cmd *wg.Command) bool {
 		}
 	}
 
+	if c.State == gameOver {
+		var results []wg.Result
+		for _, p := range c.Players {
+			results = append(results, wg.Result{PlayerId: p.Uuid, Score: p.Score})
+		}
+		c.Finish(wg.MarkWinners(results))
+	}
+
 	// no one won yet
 	if c.State != gameOver {
 		c.State = choose
@@ -709,7 +808,7 @@ func (c *Citadels) handleEndTurn(cmd *wg.Command) bool {
 		}
 		// TODO: this is two player variant only
 		if len(c.Players) == 2 {
-			c.characters[rand.Intn(8)].Chosen = true
+			c.characters[c.Rand.Intn(8)].Chosen = true
 		}
 	}
 
@@ -719,14 +818,14 @@ func (c *Citadels) handleEndTurn(cmd *wg.Command) bool {
 func (c *Citadels) handleTax(cmd *wg.Command) bool {
 	p, _ := Find(c.Players, cmd.PlayerId)
 	if p != c.Players[c.Turn.Value] {
-		sendMsg(p.ws, "Not your turn yet")
+		p.SendMsg("Not your turn yet")
 		return false
 	}
 
 	character := c.characters[c.CharCur]
 
 	if character.HasTaxed {
-		sendMsg(p.ws, "You already taxed")
+		p.SendMsg("You already taxed")
 		return false
 	}
 
@@ -746,14 +845,14 @@ func (c *Citadels) handleTax(cmd *wg.Command) bool {
 func (c *Citadels) handleSpecial(cmd *wg.Command) bool {
 	p, _ := Find(c.Players, cmd.PlayerId)
 	if p != c.Players[c.Turn.Value] {
-		sendMsg(p.ws, "Not your turn yet")
+		p.SendMsg("Not your turn yet")
 		return false
 	}
 
 	character := c.characters[c.CharCur]
 
 	if character.HasSpecialed {
-		sendMsg(p.ws, "You already special'd")
+		p.SendMsg("You already special'd")
 		return false
 	}
 
@@ -790,7 +889,7 @@ type GameMsg struct {
 	Characters []*Character
 }
 
-func (c *Citadels) sendGameInfo(ws wg.Connector) {
+func (c *Citadels) sendGameInfo(p *wg.Player) {
 	var characters []*Character
 
 	for _, char := range c.characters {
@@ -802,7 +901,7 @@ func (c *Citadels) sendGameInfo(ws wg.Connector) {
 		Characters: characters,
 	}
 
-	ws.Send(msg)
+	p.Send(msg)
 }
 
 type UpdateMsg struct {
@@ -820,24 +919,50 @@ type secret struct {
 	Hand      []*District
 }
 
-func (c *Citadels) sendEveryoneEverything() {
-	for i, p := range c.Players {
-		if p.ws != nil {
-			msg := &UpdateMsg{Type: "all", Update: c}
-			msg.You = &secret{
-				Id:       p.Id,
-				HasCrown: p.HasCrown,
-				Hand:     p.hand,
-			}
-			if c.Turn.Value == i {
-				msg.You.Turn = true
-				if c.State > choose && c.State < gameOver {
-					msg.You.Character = c.characters[c.CharCur]
-				} else if c.State == choose {
-					msg.You.Roles = c.characters
-				}
-			}
-			p.ws.Send(msg)
+// Capacity is the most players that can start a game, even though more can wait in the lobby
+func (c *Citadels) Capacity() int {
+	return 7
+}
+
+func (c *Citadels) InLobby() bool {
+	return c.State == lobby
+}
+
+// StartMatch starts the game for the players matchmaking found, there are no bots to fill seats with yet
+func (c *Citadels) StartMatch(bots int) error {
+	if bots > 0 {
+		return errors.New("Citadels doesn't have bots yet")
+	}
+	if !c.HandleCommand(&wg.Command{Type: cmdStart, Version: c.Version}) {
+		return errors.New("Need 2-7 players to start the game")
+	}
+	return nil
+}
+
+func (c *Citadels) HostCommands() []string {
+	return []string{cmdStart}
+}
+
+// SpectatorView is the table without anyone's hand or character
+func (c *Citadels) SpectatorView() interface{} {
+	return &UpdateMsg{Type: "all", Update: c, You: &secret{}}
+}
+
+func (c *Citadels) ViewFor(player *wg.Player) interface{} {
+	p, i := Find(c.Players, player.Uuid)
+	msg := &UpdateMsg{Type: "all", Update: c}
+	msg.You = &secret{
+		Id:       p.Id,
+		HasCrown: p.HasCrown,
+		Hand:     p.hand,
+	}
+	if c.Turn.Value == i {
+		msg.You.Turn = true
+		if c.State > choose && c.State < gameOver {
+			msg.You.Character = c.characters[c.CharCur]
+		} else if c.State == choose {
+			msg.You.Roles = c.characters
 		}
 	}
+	return msg
 }
@@ -1,141 +1,91 @@
 package citadels
 
 import (
-	"testing"
-	"github.com/jakecoffman/wg"
-			"log"
-	"time"
 	"math/rand"
-	"encoding/json"
+	"testing"
+
+	"github.com/jakecoffman/wg/wgtest"
 )
 
 func TestCitadels(t *testing.T) {
-	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
-	rand.Seed(time.Now().UnixNano())
-
-	const gameId = "0"
-	const player1 = "1"
-	const player2 = "2"
-	p1Conn := wg.NewFakeConn(player1)
-	p2Conn := wg.NewFakeConn(player2)
-
-	game := NewGame(gameId)
-	citadels := game.Class.(*Citadels)
-
-	game.Cmd <- &wg.Command{player1, p1Conn, cmdJoin, citadels.Version, nil}
-	game.Cmd <- &wg.Command{player2, p2Conn, cmdJoin, citadels.Version, nil}
-	game.Cmd <- &wg.Command{player1, p1Conn, cmdStart, citadels.Version, nil}
+	// pinned so a failure deals the same way every run, wgtest seeds the game
+	r := rand.New(rand.NewSource(1))
 
-	start := time.Now()
+	game := wgtest.New(t, NewGame)
+	citadels := game.Rules.(*Citadels)
 
-	var you, p1You, p2You secret
+	p1 := game.Join("1")
+	p2 := game.Join("2")
+	p1.Do(cmdStart, nil)
 
-	var games int
+	var games, steps int
 	for games < 10 {
-		if time.Now().Sub(start) > 30 * time.Second {
+		if steps++; steps > 100000 {
 			t.Fatal("Stuck", citadels.State)
 		}
 
-		time.Sleep(10 * time.Millisecond)
+		switch citadels.State {
+		case gameOver:
+			games++
+			p1.Do(cmdReady, nil)
+			p2.Do(cmdReady, nil)
+			continue
+		case lobby:
+			p1.Do(cmdStart, nil)
+			continue
+		}
 
-	drain:
-		for {
-			select {
-			case m := <-p1Conn.Msgs:
-				switch m.(type) {
-				case *UpdateMsg:
-					u := m.(*UpdateMsg)
-					p1You = *u.You
-				}
-			case m := <-p2Conn.Msgs:
-				switch m.(type) {
-				case *UpdateMsg:
-					u := m.(*UpdateMsg)
-					p2You = *u.You
-				}
-			default:
-				break drain
+		var player *wgtest.Player
+		var you secret
+		for _, p := range []*wgtest.Player{p1, p2} {
+			var update UpdateMsg
+			if p.Last(&update) && update.You.Turn {
+				player, you = p, *update.You
 			}
 		}
-
-		var player string
-		var conn *wg.FakeConn
-
-		if p1You.Turn {
-			conn = p1Conn
-			player = player1
-			you = p1You
-		} else if p2You.Turn {
-			conn = p2Conn
-			player = player2
-			you = p2You
-		} else {
-			continue
+		if player == nil {
+			t.Fatal("Nobody's turn", citadels.State)
 		}
 
 		switch citadels.State {
 		case choose:
-			b, _ := json.Marshal(rand.Intn(8))
-			game.Cmd <- &wg.Command{player, conn, cmdChoose, game.Version, b}
+			player.Do(cmdChoose, r.Intn(8))
 		case goldOrDraw:
-			p := citadels.Players[citadels.Turn.Value]
-			var b json.RawMessage
-			if len(p.hand) < 4 {
-				b, _ = json.Marshal(1)
+			if len(citadels.Players[citadels.Turn.Value].hand) < 4 {
+				player.Do(cmdAction, 1)
 			} else {
-				b, _ = json.Marshal(0)
+				player.Do(cmdAction, 0)
 			}
-			game.Cmd <- &wg.Command{player, conn, cmdAction, game.Version, b}
 		case putCardBack:
 			length := len(citadels.Players[citadels.Turn.Value].hand)
-			b, _ := json.Marshal([]int{length - (1+rand.Intn(2))})
-			game.Cmd <- &wg.Command{player, conn, cmdAction, game.Version, b}
+			player.Do(cmdAction, []int{length - (1 + r.Intn(2))})
 		case build:
 			switch you.Character.Character {
-			case King:
-				fallthrough
-			case Bishop:
-				fallthrough
-			case Merchant:
-				fallthrough
-			case Warlord:
-				game.Cmd <- &wg.Command{player, conn, cmdTax, game.Version, nil}
+			case King, Bishop, Merchant, Warlord:
+				player.Do(cmdTax, nil)
 			}
-			p := citadels.Players[citadels.Turn.Value]
-			for i := range p.hand {
-				b, _ := json.Marshal([]int{i})
-				game.Cmd <- &wg.Command{player, conn, cmdBuild, game.Version, b}
+			for i := range citadels.Players[citadels.Turn.Value].hand {
+				player.Do(cmdBuild, []int{i})
 			}
-			b, _ := json.Marshal([]int{})
-			game.Cmd <- &wg.Command{player, conn, cmdBuild, game.Version, b}
+			player.Do(cmdBuild, []int{})
 		case endTurn:
 			switch you.Character.Character {
 			case Assassin:
-				b, _ := json.Marshal(rand.Intn(7)+1)
-				game.Cmd <- &wg.Command{player, conn, cmdSpecial, game.Version, b}
+				player.Do(cmdSpecial, r.Intn(7)+1)
 			case Thief:
-				b, _ := json.Marshal(rand.Intn(6)+2)
-				game.Cmd <- &wg.Command{player, conn, cmdSpecial, game.Version, b}
-			case Magician:
+				player.Do(cmdSpecial, r.Intn(6)+2)
 			case Warlord:
 				for i, p := range citadels.Players {
 					for j, d := range p.Districts {
-						if d.Value - 1 < p.Gold {
-							b, _ := json.Marshal(warlordAction{Player: i, District: j})
-							game.Cmd <- &wg.Command{player, conn, cmdSpecial, game.Version, b}
+						if d.Value-1 < p.Gold {
+							player.Do(cmdSpecial, warlordAction{Player: i, District: j})
 						}
 					}
 				}
 			}
-			game.Cmd <- &wg.Command{player, conn, cmdEnd, game.Version, nil}
-		case gameOver:
-			games++
-			game.Cmd <- &wg.Command{player1, p1Conn, cmdReady, game.Version, nil}
-			game.Cmd <- &wg.Command{player2, p2Conn, cmdReady, game.Version, nil}
-		case lobby:
-			game.Cmd <- &wg.Command{player, conn, cmdStart, game.Version, nil}
+			player.Do(cmdEnd, nil)
 		default:
-			log.Fatal("ERROR:", citadels.State)
+			t.Fatal("Unknown state", citadels.State)
 		}
 	}
 }
@@ -0,0 +1,273 @@
+// Package client speaks the wg protocol from the player's side, for bots, tests and load testing
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jakecoffman/wg"
+	"golang.org/x/net/websocket"
+)
+
+// Client is one player connected to a server. Recv is meant to be called from one goroutine, Send from any.
+type Client struct {
+	// Game is the type of game joined, messages are decoded with its catalog
+	Game string
+	// Patches asks for views as patches after joining, they're applied so Recv still returns whole views
+	Patches bool
+
+	url, origin string
+	ws          *websocket.Conn
+	sendLock    sync.Mutex
+
+	cookie   string // PLAYER_COOKIE value, kept for redialing
+	resume   string
+	received int // messages since the cookie, to resume from
+	protocol int
+
+	doc interface{} // the last view, decoded, that patches apply to
+	seq int
+}
+
+// Unknown is a message the game's catalog doesn't declare
+type Unknown struct {
+	Type string
+	Raw  json.RawMessage
+}
+
+// cookieMsg is the first message a server sends
+type cookieMsg struct {
+	Type, Cookie          string
+	Protocol, MinProtocol int
+	Resume                string
+}
+
+// Dial connects as a new player, origin has to be one the server accepts
+func Dial(url, origin string) (*Client, error) {
+	c := &Client{url: url, origin: origin}
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+	return c, c.hello()
+}
+
+// PlayerId is who the server says this client is
+func (c *Client) PlayerId() string {
+	if i := strings.LastIndex(c.cookie, "."); i > 0 {
+		return c.cookie[:i]
+	}
+	return c.cookie
+}
+
+// Protocol is the protocol agreed on with the server
+func (c *Client) Protocol() int {
+	return c.protocol
+}
+
+func (c *Client) dial() error {
+	config, err := websocket.NewConfig(c.url, c.origin)
+	if err != nil {
+		return err
+	}
+	if c.cookie != "" {
+		config.Header = http.Header{"Cookie": {(&http.Cookie{Name: wg.COOKIE_NAME, Value: c.cookie}).String()}}
+	}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return err
+	}
+	var cookie cookieMsg
+	if err = websocket.JSON.Receive(ws, &cookie); err != nil {
+		ws.Close()
+		return err
+	}
+	if cookie.Type != "cookie" {
+		ws.Close()
+		return errors.New("expected a cookie first, got " + cookie.Type)
+	}
+	header := http.Header{"Cookie": {strings.Split(cookie.Cookie, ";")[0]}}
+	if got, err := (&http.Request{Header: header}).Cookie(wg.COOKIE_NAME); err == nil {
+		c.cookie = got.Value
+	}
+	c.ws = ws
+	c.resume = cookie.Resume
+	c.received = 0
+	c.protocol = cookie.Protocol
+	return nil
+}
+
+// hello tells servers that know about protocols which one the client speaks
+func (c *Client) hello() error {
+	if c.protocol < 2 {
+		return nil
+	}
+	return c.Send("hello", &wg.HelloRequest{Protocol: wg.ProtocolVersion})
+}
+
+// Redial connects again as the same player. The session is resumed where it left off if the server still has it,
+// otherwise it's a new connection and the client should rejoin.
+func (c *Client) Redial() (resumed bool, err error) {
+	if c.ws != nil {
+		c.ws.Close()
+	}
+	token, received, protocol := c.resume, c.received, c.protocol
+	if err = c.dial(); err != nil {
+		return false, err
+	}
+	// resume has to be the first command on the new connection
+	if err = c.Send("resume", &wg.ResumeRequest{Token: token, Received: received}); err != nil {
+		return false, err
+	}
+	var r wg.ResumeMsg
+	if err = websocket.JSON.Receive(c.ws, &r); err != nil {
+		return false, err
+	}
+	if !r.Resumed {
+		return false, c.hello()
+	}
+	// back on the old session, so carry on counting from where it was
+	c.resume, c.received, c.protocol = token, received, protocol
+	return true, nil
+}
+
+// Send sends a command, data is encoded as its Data
+func (c *Client) Send(cmdType string, data interface{}) error {
+	return c.SendVersion(cmdType, 0, data)
+}
+
+// SendVersion sends a command for a version of the game, for games that drop commands sent for old versions
+func (c *Client) SendVersion(cmdType string, version int, data interface{}) error {
+	cmd := struct {
+		Type    string
+		Version int             `json:",omitempty"`
+		Data    json.RawMessage `json:",omitempty"`
+	}{Type: cmdType, Version: version}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		cmd.Data = b
+	}
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	return websocket.JSON.Send(c.ws, &cmd)
+}
+
+// Join joins the game, or makes one if req.Id is empty
+func (c *Client) Join(req wg.JoinRequest) error {
+	if req.Type != "" {
+		c.Game = req.Type
+	}
+	c.doc, c.seq = nil, 0
+	if err := c.Send("join", &req); err != nil {
+		return err
+	}
+	if c.Patches {
+		return c.Send("ack", 0)
+	}
+	return nil
+}
+
+// Rejoin goes back to the game the player was last in, or makes a new one
+func (c *Client) Rejoin() error {
+	c.doc, c.seq = nil, 0
+	if err := c.Send("rejoin", nil); err != nil {
+		return err
+	}
+	if c.Patches {
+		return c.Send("ack", 0)
+	}
+	return nil
+}
+
+// Recv waits for the next message and decodes it into the type the game declared for it, like *wg.MsgMsg or
+// the game's view. Pings are answered and patches applied along the way.
+func (c *Client) Recv() (interface{}, error) {
+	for {
+		var raw json.RawMessage
+		if err := websocket.JSON.Receive(c.ws, &raw); err != nil {
+			return nil, err
+		}
+		var head struct{ Type string }
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return nil, err
+		}
+		if head.Type != "ping" && head.Type != "resume" {
+			c.received++
+		}
+
+		switch head.Type {
+		case "ping":
+			if err := c.Send("pong", nil); err != nil {
+				return nil, err
+			}
+			continue
+		case "snapshot":
+			var snap wg.SnapshotMsg
+			if err := json.Unmarshal(raw, &snap); err != nil {
+				return nil, err
+			}
+			c.doc = nil
+			if err := json.Unmarshal(snap.View, &c.doc); err != nil {
+				return nil, err
+			}
+			c.seq = snap.Seq
+			if err := c.Send("ack", c.seq); err != nil {
+				return nil, err
+			}
+			return c.decode(snap.View)
+		case "patch":
+			var patch wg.PatchMsg
+			if err := json.Unmarshal(raw, &patch); err != nil {
+				return nil, err
+			}
+			doc, err := applyPatch(c.doc, patch.Ops)
+			if c.doc == nil || patch.Base != c.seq || err != nil {
+				// lost track, acking past the end gets a snapshot
+				c.doc = nil
+				if err := c.Send("ack", math.MaxInt32); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			c.doc, c.seq = doc, patch.Seq
+			if err := c.Send("ack", c.seq); err != nil {
+				return nil, err
+			}
+			b, err := json.Marshal(c.doc)
+			if err != nil {
+				return nil, err
+			}
+			return c.decode(b)
+		}
+		return c.decode(raw)
+	}
+}
+
+func (c *Client) decode(raw json.RawMessage) (interface{}, error) {
+	var head struct{ Type string }
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return nil, err
+	}
+	msg := wg.NewMessage(c.Game, head.Type)
+	if msg == nil {
+		return &Unknown{Type: head.Type, Raw: raw}, nil
+	}
+	if err := json.Unmarshal(raw, msg); err != nil {
+		return nil, err
+	}
+	if head.Type == "hello" {
+		c.protocol = msg.(*wg.HelloMsg).Protocol
+	}
+	return msg, nil
+}
+
+// Close hangs up, the server treats it like a dropped connection
+func (c *Client) Close() error {
+	return c.ws.Close()
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jakecoffman/wg"
+	"github.com/jakecoffman/wg/setlib"
+	"golang.org/x/net/websocket"
+)
+
+func serve(t *testing.T) (url, origin string, stop func()) {
+	server := httptest.NewServer(websocket.Handler(wg.WsHandler(wg.ProcessGameTypes(setlib.Name))))
+	return "ws" + strings.TrimPrefix(server.URL, "http"), server.URL, server.Close
+}
+
+// next skips messages until one of the type of want comes
+func next(t *testing.T, c *Client, want func(interface{}) bool) interface{} {
+	deadline := time.Now().Add(2 * time.Second)
+	c.ws.SetReadDeadline(deadline)
+	for time.Now().Before(deadline) {
+		msg, err := c.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want(msg) {
+			return msg
+		}
+	}
+	t.Fatal("Didn't get the message")
+	return nil
+}
+
+func isMeta(msg interface{}) bool {
+	_, ok := msg.(*setlib.MetaMsg)
+	return ok
+}
+
+func TestClient(t *testing.T) {
+	url, origin, stop := serve(t)
+	defer stop()
+
+	c, err := Dial(url, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.PlayerId() == "" || c.Protocol() != wg.ProtocolVersion {
+		t.Error("Expected a player id and the newest protocol", c.PlayerId(), c.Protocol())
+	}
+
+	c.Patches = true
+	if err = c.Join(wg.JoinRequest{Type: setlib.Name}); err != nil {
+		t.Fatal(err)
+	}
+	meta := next(t, c, isMeta).(*setlib.MetaMsg)
+	if meta.GameId == "" {
+		t.Fatal("Expected the game's view", meta)
+	}
+
+	// a second player joining changes the view, which comes as a patch
+	other, err := Dial(url, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.Join(wg.JoinRequest{Id: meta.GameId})
+	meta = next(t, c, func(msg interface{}) bool {
+		meta, ok := msg.(*setlib.MetaMsg)
+		return ok && len(meta.Players) == 2
+	}).(*setlib.MetaMsg)
+	if c.seq < 2 {
+		t.Error("Expected views to come as patches", c.seq)
+	}
+
+	// after dropping the connection the client picks up the same session
+	id := c.PlayerId()
+	if resumed, err := c.Redial(); err != nil || !resumed {
+		t.Fatal("Expected to resume", resumed, err)
+	}
+	if c.PlayerId() != id {
+		t.Error("Expected to be the same player", c.PlayerId(), id)
+	}
+	other.Send("ready", true)
+	next(t, c, func(msg interface{}) bool {
+		meta, ok := msg.(*setlib.MetaMsg)
+		return ok && len(meta.Players) == 2 && meta.Players[other.PlayerId()] != nil && meta.Players[other.PlayerId()].Ready
+	})
+}
+
+func TestApplyPatch(t *testing.T) {
+	var doc interface{} = map[string]interface{}{"A": []interface{}{1.0, map[string]interface{}{"B": "x"}}, "C": 1.0}
+	doc, err := applyPatch(doc, []wg.PatchOp{
+		{Op: "replace", Path: "/A/1/B", Value: "y"},
+		{Op: "add", Path: "/A/-", Value: 3.0},
+		{Op: "remove", Path: "/C"},
+		{Op: "add", Path: "/D~1E", Value: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := doc.(map[string]interface{})
+	a := m["A"].([]interface{})
+	if len(a) != 3 || a[1].(map[string]interface{})["B"] != "y" || m["C"] != nil || m["D/E"] != true {
+		t.Error("Patch wasn't applied right", doc)
+	}
+	if _, err = applyPatch(doc, []wg.PatchOp{{Op: "replace", Path: "/A/9", Value: 1}}); err == nil {
+		t.Error("Expected a bad path to fail")
+	}
+}
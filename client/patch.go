@@ -0,0 +1,64 @@
+package client
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jakecoffman/wg"
+)
+
+// applyPatch changes a decoded JSON document by the operations of a wg.PatchMsg
+func applyPatch(doc interface{}, ops []wg.PatchOp) (interface{}, error) {
+	for _, op := range ops {
+		var path []string
+		if op.Path != "" {
+			path = strings.Split(op.Path[1:], "/")
+		}
+		var err error
+		if doc, err = apply(doc, path, op); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// apply does one operation on the part of the document at path, returning the new part
+func apply(doc interface{}, path []string, op wg.PatchOp) (interface{}, error) {
+	if len(path) == 0 {
+		if op.Op == "remove" {
+			return nil, nil
+		}
+		return op.Value, nil
+	}
+	key := strings.Replace(strings.Replace(path[0], "~1", "/", -1), "~0", "~", -1)
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 && op.Op == "remove" {
+			delete(d, key)
+			return d, nil
+		}
+		child, err := apply(d[key], path[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		d[key] = child
+		return d, nil
+	case []interface{}:
+		if key == "-" && len(path) == 1 {
+			return append(d, op.Value), nil
+		}
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(d) {
+			return nil, errors.New("bad array index in patch path " + op.Path)
+		}
+		if len(path) == 1 && op.Op == "remove" {
+			return append(d[:i], d[i+1:]...), nil
+		}
+		if d[i], err = apply(d[i], path[1:], op); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	return nil, errors.New("patch path goes through a value " + op.Path)
+}
@@ -5,19 +5,43 @@ import (
 	"golang.org/x/net/websocket"
 	"log"
 	"net/http"
-	"time"
-	"math/rand"
+	"os"
 	"github.com/jakecoffman/wg/citadels"
 )
 
-func init() {
-	rand.Seed(time.Now().Unix())
-}
-
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	http.Handle("/ws", websocket.Handler(wg.WsHandler(wg.ProcessPlayerCommands(citadels.NewGame))))
+
+	store, err := wg.NewFileStore("data/citadels")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wg.Storage = store
+	wg.Events = store
+	if err = wg.AllGames.Restore(store); err != nil {
+		log.Fatal(err)
+	}
+
+	players := wg.ProcessPlayerCommands(citadels.NewGame)
+	http.Handle("/ws", websocket.Handler(wg.WsHandler(players)))
+	// fallbacks for when websockets are blocked
+	http.Handle("/sse", wg.SSEHandler(players))
+	http.Handle("/poll", wg.PollHandler(players))
+	http.HandleFunc("/games", wg.ListGames)
+	http.HandleFunc("/replay/", wg.ReplayGame)
+	http.HandleFunc("/schema", wg.SchemaHandler(citadels.Name))
+	http.HandleFunc("/metrics", wg.Metrics)
+	wg.AdminToken = os.Getenv("WG_ADMIN_TOKEN")
+	wg.UseCookieSecret(os.Getenv("WG_COOKIE_SECRET"))
+	if err := wg.UseTrustedProxies(os.Getenv("WG_TRUSTED_PROXIES")); err != nil {
+		log.Fatal("Bad trusted proxy: ", err)
+	}
+	http.HandleFunc("/admin/", wg.Admin)
 	port := "8113"
+	server := wg.NewServer("0.0.0.0:"+port, nil)
+	server.Persist = true
 	log.Println("Serving http://localhost:" + port)
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+port, nil))
+	if err := server.ListenAndServe(wg.SignalContext()); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/jakecoffman/wg/citadels"
+	"github.com/jakecoffman/wg/client"
+	"github.com/jakecoffman/wg/justone"
+	"github.com/jakecoffman/wg/resistance"
+	"github.com/jakecoffman/wg/setlib"
+)
+
+// bot plays one player's side of a game
+type bot interface {
+	// act looks at a message and sends whatever the player would, returning true if it sent something
+	act(c *client.Client, msg interface{}) (bool, error)
+	// room is the game's id, once a message has said it
+	room() string
+}
+
+// bots makes a bot for each game, size is how many simulated players the room will have
+var bots = map[string]func(name string, size int) bot{
+	setlib.Name:     func(name string, size int) bot { return &setBot{} },
+	justone.Name:    func(name string, size int) bot { return &justOneBot{name: name} },
+	resistance.Name: func(name string, size int) bot { return &resistanceBot{name: name, size: size} },
+	citadels.Name:   func(name string, size int) bot { return &citadelsBot{name: name, size: size} },
+}
+
+// maxRoom is the most simulated players a room of the game can have, 0 for no limit
+var maxRoom = map[string]int{
+	justone.Name:    10,
+	resistance.Name: 5, // the rest of the seats are the game's own bots
+	citadels.Name:   2, // only the 2 player game is done
+}
+
+// stages keeps a bot from doing the same thing twice while the game hasn't moved on
+type stages struct {
+	stage string
+	done  map[string]bool
+}
+
+// once is true the first time it's asked about key in a stage
+func (s *stages) once(stage, key string) bool {
+	if stage != s.stage || s.done == nil {
+		s.stage = stage
+		s.done = map[string]bool{}
+	}
+	if s.done[key] {
+		return false
+	}
+	s.done[key] = true
+	return true
+}
+
+// setBot plays the first set it sees, or calls no sets
+type setBot struct {
+	stages
+	id      string
+	board   []setlib.Card
+	version int
+	playing bool
+}
+
+func (b *setBot) room() string {
+	return b.id
+}
+
+func (b *setBot) act(c *client.Client, msg interface{}) (bool, error) {
+	switch m := msg.(type) {
+	case *setlib.MetaMsg:
+		b.id, b.version, b.playing = m.GameId, m.Version, m.Playing
+		if me := m.Players[c.PlayerId()]; me != nil && !me.Ready && !m.Playing && b.once("lobby", "ready") {
+			return true, c.Send("ready", true)
+		}
+	case *setlib.UpdateMsg:
+		if m.Type == "all" {
+			b.board = nil
+		}
+		for _, u := range m.Updates {
+			for len(b.board) <= u.Location {
+				b.board = append(b.board, setlib.Card{})
+			}
+			b.board[u.Location] = u.Card
+		}
+		b.version = m.Version
+	default:
+		return false, nil
+	}
+	if !b.playing || len(b.board) == 0 || !b.once(fmt.Sprint(b.version), "play") {
+		return false, nil
+	}
+	if sets := setlib.FindSets(b.board); len(sets) > 0 {
+		return true, c.SendVersion("play", b.version, sets[0])
+	}
+	return true, c.SendVersion("nosets", b.version, nil)
+}
+
+// justOneBot writes the same clue every round and always guesses wrong
+type justOneBot struct {
+	stages
+	name, id string
+}
+
+func (b *justOneBot) room() string {
+	return b.id
+}
+
+func (b *justOneBot) act(c *client.Client, msg interface{}) (bool, error) {
+	m, ok := msg.(*justone.UpdateMsg)
+	if !ok || m.Update == nil || m.Update.Game == nil {
+		return false, nil
+	}
+	b.id = m.Update.Id
+	var me *justone.Player
+	for _, p := range m.Update.Players {
+		if p.Player != nil && p.Name == b.name {
+			me = p
+		}
+	}
+	if me == nil {
+		if b.once("", "name") {
+			return true, c.Send("name", b.name)
+		}
+		return false, nil
+	}
+	switch state := m.Update.State; {
+	case (state == "lobby" || state == "end") && !me.Ready && b.once(state, "ready"):
+		return true, c.Send("ready", nil)
+	case state == "writing" && !me.IsGuesser && b.once(state, "write"):
+		return true, c.Send("write", "CLUE")
+	case state == "reconciling" && b.once(state, "reconcile"):
+		return true, c.Send("reconcile", "ok")
+	case state == "guessing" && me.IsGuesser && b.once(state, "guess"):
+		return true, c.Send("guess", "GUESS")
+	}
+	return false, nil
+}
+
+// resistanceBot fills the room with the game's own bots, then picks random teams and votes yes
+type resistanceBot struct {
+	stages
+	name, id string
+	size     int
+}
+
+func (b *resistanceBot) room() string {
+	return b.id
+}
+
+func (b *resistanceBot) act(c *client.Client, msg interface{}) (bool, error) {
+	m, ok := msg.(*resistance.UpdateMsg)
+	if !ok || m.Update == nil || m.Update.Game == nil || m.You == nil {
+		return false, nil
+	}
+	g := m.Update
+	b.id = g.Id
+	me, bots := -1, 0
+	for i, p := range g.Players {
+		if p.Player == nil {
+			continue
+		}
+		if p.Id == m.You.Id {
+			me = i
+		}
+		if p.IsBot {
+			bots++
+		}
+	}
+	if me == -1 {
+		return false, nil
+	}
+	stage := fmt.Sprint(g.State, g.Version, g.CurrentMission, len(g.Players))
+	switch g.State {
+	case "lobby":
+		if g.Players[me].Name == "" && b.once(stage, "name") {
+			return true, c.Send("name", b.name)
+		}
+		if g.Host != m.You.Id {
+			return false, nil
+		}
+		if bots < 5-b.size && b.once(stage, "addbot") {
+			return true, c.Send("addbot", nil)
+		}
+		if len(g.Players)-bots >= b.size && len(g.Players) >= 5 && b.once(stage, "start") {
+			return true, c.Send("start", nil)
+		}
+	case "building":
+		mission := g.Missions[g.CurrentMission]
+		if m.You.IsLeader && b.once(stage, "assign") {
+			return true, c.SendVersion("assign", g.Version, rand.Perm(len(g.Players))[:mission.Slots])
+		}
+	case "voting":
+		if _, voted := g.Missions[g.CurrentMission].Votes[me]; !voted && b.once(stage, "vote") {
+			return true, c.SendVersion("voteteam", g.Version, true)
+		}
+	case "mission":
+		if m.You.OnMission && b.once(stage, "mission") {
+			spy := false
+			for _, i := range m.You.Spies {
+				spy = spy || i == me
+			}
+			return true, c.SendVersion("votemission", g.Version, !spy)
+		}
+	case "spywin", "resistancewin":
+		if !m.You.IsReady && b.once(stage, "ready") {
+			return true, c.Send("ready", nil)
+		}
+	}
+	return false, nil
+}
+
+// citadelsBot takes the first character it can, takes gold and never builds
+type citadelsBot struct {
+	stages
+	name, id string
+	size     int
+	named    bool
+}
+
+func (b *citadelsBot) room() string {
+	return b.id
+}
+
+func (b *citadelsBot) act(c *client.Client, msg interface{}) (bool, error) {
+	m, ok := msg.(*citadels.UpdateMsg)
+	if !ok || m.Update == nil || m.Update.Game == nil || m.You == nil {
+		return false, nil
+	}
+	g := m.Update
+	b.id = g.Id
+	stage := fmt.Sprint(g.State, g.Version, g.CharCur, g.Turn.Value, len(m.You.Roles), chosen(m.You.Roles))
+	switch g.State.String() {
+	case "Lobby":
+		if !b.named {
+			b.named = true
+			return true, c.Send("name", b.name)
+		}
+		if g.Host == m.You.Id && len(g.Players) == b.size && b.once(stage, "start") {
+			return true, c.SendVersion("start", g.Version, nil)
+		}
+	case "Choose":
+		if !m.You.Turn || !b.once(stage, "choose") {
+			return false, nil
+		}
+		for i, role := range m.You.Roles {
+			if !role.Chosen {
+				return true, c.Send("choose", i)
+			}
+		}
+	case "GoldOrDraw":
+		if m.You.Turn && b.once(stage, "action") {
+			return true, c.Send("action", 0)
+		}
+	case "Build":
+		if m.You.Turn && b.once(stage, "build") {
+			return true, c.Send("build", []int{})
+		}
+	case "EndTurn":
+		if m.You.Turn && b.once(stage, "end") {
+			return true, c.Send("end", nil)
+		}
+	case "GameOver":
+		if b.once(stage, "ready") {
+			return true, c.Send("ready", nil)
+		}
+	}
+	return false, nil
+}
+
+func chosen(roles []*citadels.ChoosableCharacter) int {
+	n := 0
+	for _, role := range roles {
+		if role.Chosen {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,236 @@
+// Command loadtest plays games with simulated players against a server and reports how it held up.
+// Without -url it starts a server of its own.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakecoffman/wg"
+	"github.com/jakecoffman/wg/client"
+	"golang.org/x/net/websocket"
+)
+
+// stats is what all the players saw
+type stats struct {
+	sync.Mutex
+	latencies []time.Duration // from a command to the next message back
+	received  int
+	sent      int
+	errors    map[string]int
+}
+
+func (s *stats) error(kind string) {
+	s.Lock()
+	s.errors[kind]++
+	s.Unlock()
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	url := flag.String("url", "", "websocket URL of the server, a local one is started if empty")
+	game := flag.String("game", "set", "type of game to play")
+	players := flag.Int("players", 20, "number of simulated players")
+	rooms := flag.Int("rooms", 5, "number of rooms to spread them across")
+	duration := flag.Duration("duration", 30*time.Second, "how long to play")
+	think := flag.Duration("think", 200*time.Millisecond, "how long players wait after each command")
+	patches := flag.Bool("patches", false, "ask for views as patches")
+	verbose := flag.Bool("v", false, "show the local server's logs")
+	flag.Parse()
+
+	newBot, ok := bots[*game]
+	if !ok {
+		log.Fatal("No bot for game ", *game)
+	}
+	if *rooms < 1 || *players < *rooms {
+		log.Fatal("Need at least one player in each room")
+	}
+	if max := maxRoom[*game]; max > 0 && *players > *rooms*max {
+		*rooms = (*players + max - 1) / max
+		log.Println(*game, "rooms have at most", max, "players, using", *rooms, "rooms")
+	}
+
+	if *url == "" {
+		*url = serve(*players, *verbose)
+	}
+	origin := "http" + strings.TrimPrefix(*url, "ws")
+
+	s := &stats{errors: map[string]int{}}
+	done := make(chan struct{})
+	var running sync.WaitGroup
+	for r := 0; r < *rooms; r++ {
+		size := *players / *rooms
+		if r < *players%*rooms {
+			size++
+		}
+		room := make(chan string, size)
+		for i := 0; i < size; i++ {
+			running.Add(1)
+			p := &player{
+				bot:   newBot(fmt.Sprintf("bot%v-%v", r, i), size),
+				host:  i == 0,
+				room:  room,
+				think: *think,
+				stats: s,
+			}
+			go func() {
+				defer running.Done()
+				p.play(*url, origin, *game, *patches, done)
+			}()
+		}
+	}
+
+	start := time.Now()
+	time.Sleep(*duration)
+	close(done)
+	running.Wait()
+	report(s, *players, *rooms, time.Since(start))
+}
+
+// player is one simulated player
+type player struct {
+	bot   bot
+	host  bool
+	room  chan string // the host puts the room's id on it for everyone else
+	think time.Duration
+	stats *stats
+}
+
+func (p *player) play(url, origin, game string, patches bool, done chan struct{}) {
+	c, err := client.Dial(url, origin)
+	if err != nil {
+		p.stats.error("dial: " + err.Error())
+		return
+	}
+	c.Patches = patches
+	go func() {
+		<-done
+		c.Close()
+	}()
+
+	join := wg.JoinRequest{Type: game}
+	if !p.host {
+		select {
+		case join.Id = <-p.room:
+		case <-done:
+			return
+		}
+		c.Game = game
+	}
+	if err = c.Join(join); err != nil {
+		p.stats.error("join: " + err.Error())
+		return
+	}
+
+	// messages are timed as they arrive, not when the bot gets to them after thinking
+	type arrival struct {
+		msg interface{}
+		at  time.Time
+	}
+	arrivals := make(chan arrival, 100)
+	go func() {
+		defer close(arrivals)
+		for {
+			msg, err := c.Recv()
+			if err != nil {
+				select {
+				case <-done:
+				default:
+					p.stats.error("recv: " + err.Error())
+				}
+				return
+			}
+			arrivals <- arrival{msg, time.Now()}
+		}
+	}()
+
+	var sentAt time.Time
+	told := false
+	for a := range arrivals {
+		p.stats.Lock()
+		p.stats.received++
+		if !sentAt.IsZero() && a.at.After(sentAt) {
+			p.stats.latencies = append(p.stats.latencies, a.at.Sub(sentAt))
+			sentAt = time.Time{}
+		}
+		p.stats.Unlock()
+		if m, ok := a.msg.(*wg.MsgMsg); ok && m.Code != "" {
+			p.stats.error("server: " + m.Code)
+		}
+
+		sent, err := p.bot.act(c, a.msg)
+		if err != nil {
+			select {
+			case <-done:
+			default:
+				p.stats.error("send: " + err.Error())
+			}
+			return
+		}
+		if p.host && !told && p.bot.room() != "" {
+			// everyone else can join now
+			told = true
+			for i := 0; i < cap(p.room); i++ {
+				p.room <- p.bot.room()
+			}
+		}
+		if sent {
+			p.stats.Lock()
+			p.stats.sent++
+			p.stats.Unlock()
+			sentAt = time.Now()
+			time.Sleep(p.think)
+		}
+	}
+}
+
+// serve starts a server with every game on a free local port, with the limits turned up for one IP
+func serve(players int, verbose bool) string {
+	if !verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	wg.MaxConnsPerIp = players * 2
+	wg.IpCommandRate, wg.IpCommandBurst = 1e9, 1e9
+	wg.CommandRate, wg.CommandBurst = 1e9, 1e9
+
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Handler(wg.WsHandler(wg.ProcessGameTypes(wg.GameTypes()...))))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	go http.Serve(listener, mux)
+	return "ws://" + listener.Addr().String() + "/ws"
+}
+
+func report(s *stats, players, rooms int, took time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	secs := took.Seconds()
+	fmt.Printf("%v players in %v rooms for %v\n", players, rooms, took.Round(time.Millisecond))
+	fmt.Printf("received %v messages (%.1f/s), sent %v commands (%.1f/s)\n", s.received, float64(s.received)/secs, s.sent, float64(s.sent)/secs)
+	if n := len(s.latencies); n > 0 {
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		at := func(p float64) time.Duration { return s.latencies[int(float64(n-1)*p)] }
+		fmt.Printf("latency p50 %v p90 %v p99 %v max %v\n", at(.5), at(.9), at(.99), s.latencies[n-1])
+	}
+	var kinds []string
+	for kind := range s.errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Printf("error %v: %v\n", kind, s.errors[kind])
+	}
+}
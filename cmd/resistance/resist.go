@@ -6,17 +6,37 @@ import (
 	"golang.org/x/net/websocket"
 	"log"
 	"net/http"
-	"time"
-	"math/rand"
+	"os"
 )
 
-func init() {
-	rand.Seed(time.Now().Unix())
-}
-
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	http.Handle("/ws", websocket.Handler(wg.WsHandler(wg.ProcessPlayerCommands(resistance.NewGame))))
+
+	store, err := wg.NewFileStore("data/resistance")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wg.Storage = store
+	wg.Events = store
+	if err = wg.AllGames.Restore(store); err != nil {
+		log.Fatal(err)
+	}
+
+	players := wg.ProcessPlayerCommands(resistance.NewGame)
+	http.Handle("/ws", websocket.Handler(wg.WsHandler(players)))
+	// fallbacks for when websockets are blocked
+	http.Handle("/sse", wg.SSEHandler(players))
+	http.Handle("/poll", wg.PollHandler(players))
+	http.HandleFunc("/games", wg.ListGames)
+	http.HandleFunc("/replay/", wg.ReplayGame)
+	http.HandleFunc("/schema", wg.SchemaHandler(resistance.Name))
+	http.HandleFunc("/metrics", wg.Metrics)
+	wg.AdminToken = os.Getenv("WG_ADMIN_TOKEN")
+	wg.UseCookieSecret(os.Getenv("WG_COOKIE_SECRET"))
+	if err := wg.UseTrustedProxies(os.Getenv("WG_TRUSTED_PROXIES")); err != nil {
+		log.Fatal("Bad trusted proxy: ", err)
+	}
+	http.HandleFunc("/admin/", wg.Admin)
 	http.HandleFunc("/.well-known/assetlinks.json", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
 		_, _ = w.Write([]byte(`[{
@@ -26,6 +46,10 @@ func main() {
 }]`))
 	})
 	port := "8112"
+	server := wg.NewServer("0.0.0.0:"+port, nil)
+	server.Persist = true
 	log.Println("Serving http://localhost:" + port)
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+port, nil))
+	if err := server.ListenAndServe(wg.SignalContext()); err != nil {
+		log.Fatal(err)
+	}
 }
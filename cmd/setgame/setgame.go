@@ -3,22 +3,45 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 	"github.com/jakecoffman/wg"
 	"github.com/jakecoffman/wg/setlib"
 	"golang.org/x/net/websocket"
-	"time"
-	"math/rand"
 )
 
-func init() {
-	rand.Seed(time.Now().Unix())
-}
-
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	http.Handle("/ws", websocket.Handler(wg.WsHandler(wg.ProcessPlayerCommands(setlib.NewGame))))
+	store, err := wg.NewFileStore("data/set")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wg.Storage = store
+	wg.Events = store
+	if err = wg.AllGames.Restore(store); err != nil {
+		log.Fatal(err)
+	}
+
+	players := wg.ProcessPlayerCommands(setlib.NewGame)
+	http.Handle("/ws", websocket.Handler(wg.WsHandler(players)))
+	// fallbacks for when websockets are blocked
+	http.Handle("/sse", wg.SSEHandler(players))
+	http.Handle("/poll", wg.PollHandler(players))
+	http.HandleFunc("/games", wg.ListGames)
+	http.HandleFunc("/replay/", wg.ReplayGame)
+	http.HandleFunc("/schema", wg.SchemaHandler(setlib.Name))
+	http.HandleFunc("/metrics", wg.Metrics)
+	wg.AdminToken = os.Getenv("WG_ADMIN_TOKEN")
+	wg.UseCookieSecret(os.Getenv("WG_COOKIE_SECRET"))
+	if err := wg.UseTrustedProxies(os.Getenv("WG_TRUSTED_PROXIES")); err != nil {
+		log.Fatal("Bad trusted proxy: ", err)
+	}
+	http.HandleFunc("/admin/", wg.Admin)
 	port := "8222"
+	server := wg.NewServer("0.0.0.0:"+port, nil)
+	server.Persist = true
 	log.Println("Serving http://localhost:" + port)
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+port, nil))
+	if err := server.ListenAndServe(wg.SignalContext()); err != nil {
+		log.Fatal(err)
+	}
 }
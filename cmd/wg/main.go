@@ -0,0 +1,153 @@
+// Command wg serves every game from one binary, flags other than -schema can also be set with WG_ environment variables
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jakecoffman/wg"
+	_ "github.com/jakecoffman/wg/citadels"
+	_ "github.com/jakecoffman/wg/justone"
+	_ "github.com/jakecoffman/wg/resistance"
+	_ "github.com/jakecoffman/wg/setlib"
+	"golang.org/x/net/websocket"
+)
+
+func env(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// envDuration is env for durations like 30s, a bad one stops the server
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Bad %v: %v", key, err)
+	}
+	return d
+}
+
+func split(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	addr := flag.String("addr", env("WG_ADDR", "0.0.0.0:8110"), "address to listen on")
+	data := flag.String("data", env("WG_DATA", "data/wg"), "directory games are saved in")
+	cert := flag.String("tls-cert", env("WG_TLS_CERT", ""), "TLS certificate file, serves plain HTTP without one")
+	key := flag.String("tls-key", env("WG_TLS_KEY", ""), "TLS key file")
+	origins := flag.String("origins", env("WG_ORIGINS", ""), "comma separated sites other than this one allowed to connect")
+	games := flag.String("games", env("WG_GAMES", strings.Join(wg.GameTypes(), ",")), "comma separated game types to serve, the first is the default")
+	grace := flag.Duration("resume-grace", envDuration("WG_RESUME_GRACE", wg.ResumeGrace), "how long a dropped player has to reconnect before the game hears they left")
+	proxies := flag.String("trusted-proxies", env("WG_TRUSTED_PROXIES", ""), "comma separated IPs or CIDRs of proxies whose X-Forwarded-For is believed")
+	botWait := flag.Duration("bot-wait", envDuration("WG_BOT_WAIT", wg.BotWait), "how long queued players wait for others before bots fill the empty seats")
+	accounts := flag.Bool("accounts", os.Getenv("WG_ACCOUNTS") != "", "let players make accounts to be the same player on every device")
+	stubLogin := flag.String("stub-login", env("WG_STUB_LOGIN", ""), "for development, /account/login/stub logs anyone in as this subject")
+	schema := flag.Bool("schema", false, "print the protocol schema of the enabled games and exit")
+	flag.Parse()
+	wg.ResumeGrace = *grace
+	wg.BotWait = *botWait
+	if err := wg.TrustProxies(split(*proxies)...); err != nil {
+		log.Fatal("Bad trusted proxy: ", err)
+	}
+
+	types := split(*games)
+	for _, t := range types {
+		found := false
+		for _, known := range wg.GameTypes() {
+			found = found || t == known
+		}
+		if !found {
+			log.Fatalf("Unknown game type %q, expected some of %v", t, wg.GameTypes())
+		}
+	}
+	if len(types) == 0 {
+		log.Fatal("No games enabled")
+	}
+	if *schema {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(wg.ProtocolSchema(types...)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	store, err := wg.NewFileStore(*data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	wg.Storage = store
+	wg.Events = store
+	if err = wg.AllGames.Restore(store); err != nil {
+		log.Fatal(err)
+	}
+	wg.TournamentStorage = store
+	if err = wg.RestoreTournaments(store); err != nil {
+		log.Fatal(err)
+	}
+	wg.StatsStorage = store
+	if err = wg.RestoreStats(store); err != nil {
+		log.Fatal(err)
+	}
+
+	players := wg.ProcessGameTypes(types...)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Handler(wg.WsHandler(players)))
+	// fallbacks for when websockets are blocked
+	mux.Handle("/sse", wg.SSEHandler(players))
+	mux.Handle("/poll", wg.PollHandler(players))
+	mux.HandleFunc("/games", wg.ListGames)
+	mux.HandleFunc("/replay/", wg.ReplayGame)
+	mux.HandleFunc("/tournaments", wg.TournamentAPI)
+	mux.HandleFunc("/tournaments/", wg.TournamentAPI)
+	mux.HandleFunc("/stats/", wg.StatsAPI)
+	mux.HandleFunc("/schema", wg.SchemaHandler(types...))
+	mux.HandleFunc("/metrics", wg.Metrics)
+	wg.AdminToken = os.Getenv("WG_ADMIN_TOKEN")
+	wg.UseCookieSecret(os.Getenv("WG_COOKIE_SECRET"))
+	mux.HandleFunc("/admin/", wg.Admin)
+	if *accounts {
+		wg.AccountStorage = store
+		if auth := os.Getenv("WG_OIDC_AUTH_URL"); auth != "" {
+			wg.RegisterProvider("oidc", &wg.OIDCProvider{
+				AuthEndpoint:     auth,
+				TokenEndpoint:    os.Getenv("WG_OIDC_TOKEN_URL"),
+				UserinfoEndpoint: os.Getenv("WG_OIDC_USERINFO_URL"),
+				ClientId:         os.Getenv("WG_OIDC_CLIENT_ID"),
+				ClientSecret:     os.Getenv("WG_OIDC_CLIENT_SECRET"),
+			})
+		}
+		if *stubLogin != "" {
+			wg.RegisterProvider("stub", &wg.StubProvider{As: *stubLogin})
+		}
+		mux.HandleFunc("/account/", wg.AccountAPI)
+	}
+
+	server := wg.NewServer(*addr, wg.CheckOrigin(split(*origins), mux))
+	server.Persist = true
+	server.CertFile, server.KeyFile = *cert, *key
+	log.Println("Serving", types, "on", *addr)
+	if err := server.ListenAndServe(wg.SignalContext()); err != nil {
+		log.Fatal(err)
+	}
+}
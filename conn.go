@@ -27,6 +27,7 @@ type wsConn struct {
 }
 
 func NewWsConn(ws *websocket.Conn) *wsConn {
+	ws.MaxPayloadBytes = MaxPayloadBytes
 	conn := &wsConn{
 		conn: ws,
 	}
@@ -74,7 +75,7 @@ func (c *wsConn) Close() error {
 }
 
 func (c *wsConn) Ip() string {
-	return c.conn.Request().Header.Get("X-Forwarded-For")
+	return clientIp(c.conn.Request())
 }
 
 func (c *wsConn) Cookie(name string) (*http.Cookie, error) {
@@ -0,0 +1,194 @@
+package wg
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Clients that send an ack get their views as JSON patches (RFC 6902) against the last view they were sent, instead
+// of the whole game every time. Clients that never ack keep getting full views.
+
+// patchMaxBehind is how many views a client can fail to ack before it gets a full snapshot again
+const patchMaxBehind = 10
+
+// PatchOp is one RFC 6902 operation, only add, remove and replace are used
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// PatchMsg changes the client's last view into the current one, Base is the Seq it applies to
+type PatchMsg struct {
+	Type string
+	Seq  int
+	Base int
+	Ops  []PatchOp
+}
+
+// SnapshotMsg is a whole view for a client that takes patches, sent when it starts or falls too far behind
+type SnapshotMsg struct {
+	Type string
+	Seq  int
+	View json.RawMessage
+}
+
+// viewState is what was last sent to one connection
+type viewState struct {
+	patches bool
+	seq     int         // of the last view sent
+	acked   int         // last seq the client said it has
+	last    interface{} // the last view sent, decoded
+}
+
+// viewState finds what a connection was sent
+func (g *Game) viewState(c Connector) *viewState {
+	if g.views == nil {
+		g.views = map[Connector]*viewState{}
+	}
+	st := g.views[c]
+	if st == nil {
+		st = &viewState{}
+		g.views[c] = st
+	}
+	return st
+}
+
+// encodedView is a view that's only encoded if some connection takes patches, and only once
+type encodedView struct {
+	view interface{}
+	b    []byte
+	doc  interface{}
+	err  error
+}
+
+func (v *encodedView) encode() error {
+	if v.b != nil || v.err != nil {
+		return v.err
+	}
+	if v.b, v.err = json.Marshal(v.view); v.err != nil {
+		return v.err
+	}
+	v.err = json.Unmarshal(v.b, &v.doc)
+	return v.err
+}
+
+// sendView sends the player their view on every connection they have open
+func (g *Game) sendView(p *Player) {
+	view := &encodedView{view: g.Rules.ViewFor(p)}
+	for _, c := range p.conns {
+		g.sendViewTo(c, view)
+	}
+}
+
+// sendViewTo sends one connection the view, as a patch if its client takes them
+func (g *Game) sendViewTo(c Connector, view *encodedView) {
+	st := g.viewState(c)
+	if !st.patches {
+		c.Send(view.view)
+		return
+	}
+	if err := view.encode(); err != nil {
+		log.Println("Failed to encode view", err)
+		return
+	}
+
+	if st.last != nil && st.seq-st.acked <= patchMaxBehind {
+		ops := diff(nil, "", st.last, view.doc)
+		if len(ops) == 0 {
+			return
+		}
+		patch := &PatchMsg{Type: "patch", Seq: st.seq + 1, Base: st.seq, Ops: ops}
+		if pb, err := json.Marshal(patch); err == nil && len(pb) < len(view.b) {
+			st.seq++
+			st.last = view.doc
+			c.Send(json.RawMessage(pb))
+			return
+		}
+	}
+	st.seq++
+	st.last = view.doc
+	c.Send(&SnapshotMsg{Type: "snapshot", Seq: st.seq, View: view.b})
+}
+
+// ack is a client saying which view it has, the first one turns patches on
+func (g *Game) ack(cmd *Command) bool {
+	p := g.Player(cmd.PlayerId)
+	if p == nil || !p.hasConn(cmd.Ws) {
+		return false
+	}
+	var seq int
+	if err := json.Unmarshal(cmd.Data, &seq); err != nil {
+		cmd.SendError(ErrBadData, "Got invalid data for ack")
+		return false
+	}
+	st := g.viewState(cmd.Ws)
+	if !st.patches || seq > st.seq {
+		// new to patches, or confused: either way start from a snapshot
+		st.patches = true
+		st.last = nil
+		g.sendViewTo(cmd.Ws, &encodedView{view: g.Rules.ViewFor(p)})
+		return false
+	}
+	if seq > st.acked {
+		st.acked = seq
+	}
+	return false
+}
+
+func (g *Game) forgetView(c Connector) {
+	delete(g.views, c)
+}
+
+func (g *Game) forgetViews(p *Player) {
+	for _, c := range p.conns {
+		delete(g.views, c)
+	}
+}
+
+// diff appends the operations that turn a into b, both decoded from JSON
+func diff(ops []PatchOp, path string, a, b interface{}) []PatchOp {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for k := range av {
+			if _, ok := bv[k]; !ok {
+				ops = append(ops, PatchOp{Op: "remove", Path: path + "/" + escapePath(k)})
+			}
+		}
+		for k, v := range bv {
+			if old, ok := av[k]; ok {
+				ops = diff(ops, path+"/"+escapePath(k), old, v)
+			} else {
+				ops = append(ops, PatchOp{Op: "add", Path: path + "/" + escapePath(k), Value: v})
+			}
+		}
+		return ops
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) > len(bv) {
+			break
+		}
+		for i := range av {
+			ops = diff(ops, path+"/"+strconv.Itoa(i), av[i], bv[i])
+		}
+		for _, v := range bv[len(av):] {
+			ops = append(ops, PatchOp{Op: "add", Path: path + "/-", Value: v})
+		}
+		return ops
+	}
+	if !reflect.DeepEqual(a, b) {
+		ops = append(ops, PatchOp{Op: "replace", Path: path, Value: b})
+	}
+	return ops
+}
+
+func escapePath(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,142 @@
+package wg
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// apply is just enough of RFC 6902 to check the patches diff makes
+func apply(doc interface{}, ops []PatchOp) interface{} {
+	for _, op := range ops {
+		if op.Path == "" {
+			doc = op.Value
+			continue
+		}
+		parts := strings.Split(op.Path[1:], "/")
+		var parent interface{} = doc
+		for _, part := range parts[:len(parts)-1] {
+			part = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+			switch p := parent.(type) {
+			case map[string]interface{}:
+				parent = p[part]
+			case []interface{}:
+				i, _ := strconv.Atoi(part)
+				parent = p[i]
+			}
+		}
+		last := strings.Replace(strings.Replace(parts[len(parts)-1], "~1", "/", -1), "~0", "~", -1)
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if op.Op == "remove" {
+				delete(p, last)
+			} else {
+				p[last] = op.Value
+			}
+		case []interface{}:
+			if last == "-" {
+				// appending needs the parent's parent, only used at the top level here
+				doc = append(p, op.Value)
+				continue
+			}
+			i, _ := strconv.Atoi(last)
+			p[i] = op.Value
+		}
+	}
+	return doc
+}
+
+func decode(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func TestDiff(t *testing.T) {
+	for _, c := range []struct{ a, b string }{
+		{`{"A":1,"B":"x"}`, `{"A":2,"B":"x"}`},
+		{`{"A":1,"B":"x"}`, `{"A":1}`},
+		{`{"A":{"C":[1,2]}}`, `{"A":{"C":[1,3]},"D":null}`},
+		{`{"a/b~":1}`, `{"a/b~":2}`},
+		{`[1,2]`, `[1,2,3,4]`},
+		{`[1,2,3]`, `[1]`},
+		{`{"A":1}`, `"different"`},
+	} {
+		a, b := decode(c.a), decode(c.b)
+		ops := diff(nil, "", a, b)
+		if got := apply(decode(c.a), ops); !reflect.DeepEqual(got, b) {
+			t.Error("Patch from", c.a, "made", got, "not", c.b, ops)
+		}
+	}
+	if ops := diff(nil, "", decode(`{"A":[1]}`), decode(`{"A":[1]}`)); len(ops) != 0 {
+		t.Error("Nothing changed so there should be no ops", ops)
+	}
+}
+
+type boardRules struct {
+	testRules
+	board map[string]int
+}
+
+func (r *boardRules) ViewFor(p *Player) interface{} {
+	return r.board
+}
+
+func TestPatches(t *testing.T) {
+	rules := &boardRules{board: map[string]int{}}
+	for i := 0; i < 20; i++ {
+		rules.board[strconv.Itoa(i)] = i
+	}
+	game := NewGame(rules, "1")
+	conn := NewFakeConn("a")
+	game.handle(&Command{PlayerId: "a", Ws: conn, Type: cmdJoin})
+	game.Broadcast()
+	if _, ok := (<-conn.Msgs).(map[string]int); !ok {
+		t.Fatal("Clients that haven't acked get the whole view")
+	}
+
+	game.handle(&Command{PlayerId: "a", Ws: conn, Type: cmdAck, Data: []byte(`0`)})
+	snap, ok := (<-conn.Msgs).(*SnapshotMsg)
+	if !ok || snap.Seq != 1 {
+		t.Fatal("Expected a snapshot when patches are turned on")
+	}
+	view := decode(string(snap.View))
+
+	rules.board["3"] = 33
+	game.Broadcast()
+	var patch PatchMsg
+	if err := json.Unmarshal((<-conn.Msgs).(json.RawMessage), &patch); err != nil || patch.Base != 1 || patch.Seq != 2 {
+		t.Fatal("Expected a patch", patch, err)
+	}
+	view = apply(view, patch.Ops)
+	if !reflect.DeepEqual(view, decode(`{"0":0,"1":1,"2":2,"3":33,"4":4,"5":5,"6":6,"7":7,"8":8,"9":9,"10":10,"11":11,"12":12,"13":13,"14":14,"15":15,"16":16,"17":17,"18":18,"19":19}`)) {
+		t.Error("Patch didn't produce the view", view)
+	}
+
+	game.Broadcast()
+	if len(conn.Msgs) != 0 {
+		t.Error("Nothing changed so nothing should be sent")
+	}
+
+	for i := 0; i < patchMaxBehind; i++ {
+		rules.board["3"] = i
+		game.Broadcast()
+	}
+	drain(conn)
+	rules.board["3"] = 100
+	game.Broadcast()
+	if _, ok := (<-conn.Msgs).(*SnapshotMsg); !ok {
+		t.Error("Clients too far behind should get a snapshot")
+	}
+
+	reconnect := NewFakeConn("a")
+	game.handle(&Command{PlayerId: "a", Ws: reconnect, Type: cmdJoin})
+	game.Broadcast()
+	if _, ok := (<-reconnect.Msgs).(map[string]int); !ok {
+		t.Error("Reconnecting starts over with the whole view")
+	}
+}
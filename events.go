@@ -0,0 +1,165 @@
+package wg
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Events is where every command a game accepts is logged so the game can be replayed, nil means nothing is logged
+var Events EventLog
+
+// EventLog keeps the commands of each game in the order they were applied
+type EventLog interface {
+	Append(gameId string, e *Event) error
+	Events(gameId string) ([]*Event, error)
+	DeleteEvents(gameId string) error
+}
+
+// unlogged commands don't change the game so they aren't needed to replay it
+var unlogged = map[string]bool{cmdSpectate: true, cmdChat: true, cmdAck: true, cmdInspect: true, cmdAnnounce: true, cmdReap: true}
+
+// eventNew starts every log, it has the game type and the seed the game was made with
+const eventNew = "new"
+
+// Event is one command the game applied, with everything needed to apply it again the same way
+type Event struct {
+	Time        time.Time
+	PlayerId    string `json:",omitempty"`
+	Ip          string `json:",omitempty"`
+	Conn        int64  `json:",omitempty"` // which of the player's connections sent it
+	Type        string
+	Version     int // what the player sent
+	GameVersion int // what the game was at
+	Seed        int64
+	Data        json.RawMessage `json:",omitempty"`
+	Public      bool            `json:",omitempty"` // the game was listed without a password, so anyone can replay it
+}
+
+// logCreated starts the event log of a new game
+func (g *Game) logCreated() {
+	if g.restored || g.replaying {
+		return
+	}
+	data, _ := json.Marshal(g.Type)
+	g.logEvent(&Event{Time: g.Created, Type: eventNew, Seed: g.seed, Data: data})
+}
+
+// record reseeds the game's randomness for the command and logs it, so a replay gets the same random numbers
+func (g *Game) record(cmd *Command) {
+	seed := g.seeds.Int63()
+	g.Rand.Seed(seed)
+	g.cmdSeed = seed
+	if unlogged[cmd.Type] {
+		return
+	}
+	e := &Event{
+		Time:        g.clock.Now(),
+		PlayerId:    cmd.PlayerId,
+		Type:        cmd.Type,
+		Version:     cmd.Version,
+		GameVersion: g.Version,
+		Seed:        seed,
+		Data:        cmd.Data,
+		Public:      g.Public && g.password == "",
+	}
+	if cmd.Ws != nil {
+		e.Ip = cmd.Ws.Ip()
+		e.Conn = g.connId(cmd)
+	}
+	g.logEvent(e)
+}
+
+// connSeq numbers connections, it starts from when the server started so numbers aren't used again after a restart
+var connSeq = time.Now().UnixNano()
+
+// connId is the number logged for the command's connection, forgotten once the connection is done with the game
+func (g *Game) connId(cmd *Command) int64 {
+	id, ok := g.connIds[cmd.Ws]
+	if !ok {
+		id = atomic.AddInt64(&connSeq, 1)
+		if g.connIds == nil {
+			g.connIds = map[Connector]int64{}
+		}
+		g.connIds[cmd.Ws] = id
+	}
+	if cmd.Type == cmdDisconnect || cmd.Type == cmdLeave {
+		delete(g.connIds, cmd.Ws)
+	}
+	return id
+}
+
+// publicLog reports whether the game was public when it last logged anything, so its log can be replayed by anyone
+func publicLog(id string) bool {
+	events, err := Events.Events(id)
+	return err == nil && len(events) > 0 && events[len(events)-1].Public
+}
+
+func (g *Game) logEvent(e *Event) {
+	if Events == nil {
+		return
+	}
+	if err := Events.Append(g.Id, e); err != nil {
+		log.Println("Failed to log event for game", g.Id, err)
+	}
+}
+
+func (s *FileStore) eventsPath(id string) string {
+	return filepath.Join(s.dir, id+".events")
+}
+
+// Append adds the event as a line of JSON to the game's log file
+func (s *FileStore) Append(gameId string, e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	f, err := os.OpenFile(s.eventsPath(gameId), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FileStore) Events(gameId string) ([]*Event, error) {
+	s.Lock()
+	defer s.Unlock()
+	f, err := os.Open(s.eventsPath(gameId))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []*Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		e := &Event{}
+		if err = json.Unmarshal(scanner.Bytes(), e); err != nil {
+			// a crash mid-write can leave half a line at the end
+			log.Println("Skipping corrupt event in game", gameId, err)
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+func (s *FileStore) DeleteEvents(gameId string) error {
+	s.Lock()
+	defer s.Unlock()
+	err := os.Remove(s.eventsPath(gameId))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -1,7 +1,11 @@
 package wg
 
-import "net/http"
+import (
+	"io"
+	"net/http"
+)
 
+// FakeConn keeps what it's sent and never receives anything, wgtest has a connection that can be scripted
 type FakeConn struct {
 	FakeIp string
 	Closed bool
@@ -18,7 +22,7 @@ func (c *FakeConn) Send(v interface{}) {
 }
 
 func (c *FakeConn) Recv(v interface{}) error {
-	return nil
+	return io.EOF
 }
 
 func (c *FakeConn) SendRaw(v []byte) {
@@ -26,7 +30,7 @@ func (c *FakeConn) SendRaw(v []byte) {
 }
 
 func (c *FakeConn) RecvRaw(v []byte) error {
-	return nil
+	return io.EOF
 }
 
 func (c *FakeConn) Close() error {
@@ -39,5 +43,5 @@ func (c *FakeConn) Ip() string {
 }
 
 func (c *FakeConn) Cookie(name string) (*http.Cookie, error) {
-	return &http.Cookie{}, nil
+	return nil, http.ErrNoCookie
 }
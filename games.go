@@ -1,60 +1,140 @@
 package wg
 
 import (
+	"encoding/json"
+	"log"
+	"math/rand"
 	"sync"
 	"time"
 )
 
 var AllGames = NewGames()
 
-const gameCleanup = 48 * time.Hour
-
-func init() {
-	// check if games are abandoned, and if so remove them
-	go func() {
-		for {
-			time.Sleep(1 * time.Hour)
-			for _, id := range AllGames.Ids() {
-				game := AllGames.Get(id)
-				if time.Now().Sub(game.Created) > gameCleanup && time.Now().Sub(game.Updated) > gameCleanup {
-					game.Cmd <- &Command{Type: cmdStop}
-					AllGames.Delete(id)
-				}
-			}
-		}
-	}()
-}
-
 type Game struct {
-	Class interface{}   `json:"-"`
+	Rules Rules         `json:"-"`
 	Cmd   chan *Command `json:"-"`
 
 	Id      string
+	Type    string `json:"-"`
 	Version int
+	Host    int       `json:",omitempty"` // player id of the host
+	Locked  bool      `json:",omitempty"` // no new players can join
 	Created time.Time `json:"-"`
 	Updated time.Time `json:"-"`
+
+	// Timers are the host's settings for how many seconds each phase lasts
+	Timers map[string]int `json:",omitempty"`
+	// TimeLeft is milliseconds until the current phase times out
+	TimeLeft int `json:",omitempty"`
+
+	// Public games are listed in the lobby
+	Public bool `json:"-"`
+	// StrictVersion drops game commands sent for any version but the current one
+	StrictVersion bool `json:"-"`
+	// SpectatorDelay holds back what spectators see, so they can't tip off players in hidden role games
+	SpectatorDelay time.Duration `json:"-"`
+	// Rand is the game's random source, games use it instead of math/rand so replays come out the same
+	Rand         *rand.Rand `json:"-"`
+	seed         int64
+	seeds        *rand.Rand // picks the seed for each command
+	cmdSeed      int64      // what Rand was seeded with for the command being handled
+	restored     bool
+	replaying    bool
+	playerCursor int
+
+	spectators     map[string][]Connector // every tab each spectator is watching from
+	spectatorsLock sync.RWMutex
+	delayed        chan delayed
+
+	views   map[Connector]*viewState
+	connIds map[Connector]int64 // the number each connection is logged under
+
+	scrollback []*ChatMsg
+	chatTimes  map[string][]time.Time
+
+	host     string // cookie of the host
+	password string
+	banned   map[string]bool // player cookies and IPs
+
+	clock    Clock
+	timer    Timer
+	timerSeq int         // tells a timer that went off apart from one that was disarmed
+	injected int32       // injected commands not handled yet
+	syncs    []Connector // Idle calls waiting for the injected commands
+	phase    string
+	deadline time.Time
+	done     chan struct{} // closed when the game stops
+
+	emptySince time.Time // when the last player left, zero while anyone is in the room
+	idleSince  time.Time // when the last player disconnected, zero while anyone is connected
+
+	registry  *Games
+	regLock   sync.Mutex // the registry is set from other goroutines while the game runs
+	info      RoomInfo   // last thing published to the lobby, guarded by the registry lock
+	wasPublic bool
 }
 
-func NewGame(class interface{}, id string) *Game {
-	return &Game{
-		Cmd:     make(chan *Command),
-		Class:   class,
-		Id:      id,
-		Created: time.Now(),
-		Updated: time.Now(),
+// Option changes how a game is made
+type Option func(*Game)
+
+// WithSeed makes the game's randomness the same every time, for tests and reproducing bugs
+func WithSeed(seed int64) Option {
+	return func(g *Game) {
+		g.setSeed(seed)
 	}
 }
 
+// WithClock runs the game's timers and idea of now off the clock, for tests that don't want to wait
+func WithClock(clock Clock) Option {
+	return func(g *Game) {
+		g.clock = clock
+	}
+}
+
+func NewGame(rules Rules, id string, opts ...Option) *Game {
+	g := &Game{
+		Cmd:          make(chan *Command),
+		Rules:        rules,
+		playerCursor: 1,
+		done:         make(chan struct{}),
+		Id:           id,
+		clock:        realClock{},
+	}
+	g.setSeed(time.Now().UnixNano())
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.Created = g.clock.Now()
+	g.Updated = g.Created
+	return g
+}
+
+// Seed is what the game's randomness started from
+func (g *Game) Seed() int64 {
+	return g.seed
+}
+
+func (g *Game) setSeed(seed int64) {
+	g.seed = seed
+	g.seeds = rand.New(rand.NewSource(seed))
+	g.Rand = rand.New(rand.NewSource(seed))
+}
+
 type Games struct {
 	sync.RWMutex
-	games map[string]*Game
+	games   map[string]*Game
 	players map[string]*Game
+	members map[string]map[string]bool // game id to the players index entries pointing at it
+
+	listeners map[Connector]bool
+	draining  bool // no new games while the server shuts down
 }
 
 func NewGames() *Games {
 	return &Games{
-		games: map[string]*Game{},
+		games:   map[string]*Game{},
 		players: map[string]*Game{},
+		members: map[string]map[string]bool{},
 	}
 }
 
@@ -74,26 +154,103 @@ func (g *Games) Get(id string) *Game {
 	return g.games[id]
 }
 
-func (g *Games) Set(game *Game, pid string) {
+func (g *Games) Set(game *Game, pids ...string) {
 	if game.Id == "" {
 		// this is programmer error, ok with panic
 		panic("game needs an ID")
 	}
 	g.Lock()
 	g.games[game.Id] = game
-	g.players[pid] = game
+	game.regLock.Lock()
+	game.registry = g
+	game.regLock.Unlock()
+	for _, pid := range pids {
+		if old, ok := g.players[pid]; ok {
+			delete(g.members[old.Id], pid)
+		}
+		g.players[pid] = game
+		if g.members[game.Id] == nil {
+			g.members[game.Id] = map[string]bool{}
+		}
+		g.members[game.Id][pid] = true
+	}
 	g.Unlock()
 }
 
+// games is the registry the game was Set in, nil if it hasn't been
+func (g *Game) games() *Games {
+	g.regLock.Lock()
+	defer g.regLock.Unlock()
+	return g.registry
+}
+
 func (g *Games) Delete(id string) {
 	g.Lock()
+	removed, ok := g.games[id]
 	delete(g.games, id)
-	for pid, game := range g.players {
-		if game.Id == id {
-			defer delete(g.players, pid)
-		}
+	for pid := range g.members[id] {
+		delete(g.players, pid)
 	}
+	delete(g.members, id)
 	g.Unlock()
+	if ok && removed.info.Public {
+		g.notify(RoomInfo{Id: id, Removed: true})
+	}
+}
+
+// remove ends the game the way a reaped room ends, players are told it was stopped and hung up on. Then it's
+// forgotten, including what was saved of it.
+func (g *Games) remove(game *Game) {
+	data, _ := json.Marshal(ReapStopped)
+	if game.send(&Command{Type: cmdReap, Data: data}) {
+		<-game.done
+	} else {
+		log.Println("Game", game.Id, "didn't take the stop command")
+	}
+	// a game in a registry forgets itself, this is for the ones that aren't or had already stopped
+	g.forget(game.Id)
+}
+
+// forget deletes the game from the registry and everything saved about it, except the event log of a public game
+// which is kept so anyone can replay it
+func (g *Games) forget(id string) {
+	g.Delete(id)
+	// a tournament table that ends without a result is forfeited, so the tournament doesn't wait on a room that's gone
+	tournaments.finished(id, nil)
+	if Storage != nil {
+		if err := Storage.Delete(id); err != nil {
+			log.Println("Failed to delete game", id, err)
+		}
+	}
+	if Events != nil && !publicLog(id) {
+		if err := Events.DeleteEvents(id); err != nil {
+			log.Println("Failed to delete events of game", id, err)
+		}
+	}
+}
+
+// reap asks every game whether its room is over, each decides for itself in its own goroutine
+func (g *Games) reap() {
+	var wg sync.WaitGroup
+	for _, id := range g.Ids() {
+		game := g.Get(id)
+		if game == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			game.send(&Command{Type: cmdReap})
+		}()
+	}
+	wg.Wait()
+}
+
+// Draining is true once the server has stopped making new games
+func (g *Games) Draining() bool {
+	g.RLock()
+	defer g.RUnlock()
+	return g.draining
 }
 
 func (g *Games) Find(pid string) *Game {
@@ -30,4 +30,13 @@ func TestGames(t *testing.T) {
 	if len(games.Ids()) != 0 {
 		t.Error("There should be 0 games", games.Ids())
 	}
+
+	// players index follows players from game to game
+	one, two := NewGame(nil, "1"), NewGame(nil, "2")
+	games.Set(one, "a", "b")
+	games.Set(two, "a")
+	games.Delete("1")
+	if games.Find("a") != two || games.Find("b") != nil {
+		t.Error("Deleting a game should only forget the players still in it", games.Find("a"), games.Find("b"))
+	}
 }
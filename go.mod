@@ -4,5 +4,6 @@ go 1.14
 
 require (
 	github.com/google/uuid v0.0.0-20171129191014-dec09d789f3d
+	golang.org/x/crypto v0.0.0-20180820150726-614d502a4dac
 	golang.org/x/net v0.0.0-20180826012351-8a410e7b638d
 )
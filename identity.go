@@ -0,0 +1,102 @@
+package wg
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CookieSecret signs player cookies so nobody can pass themselves off as another player by learning their id.
+// The mains set it from WG_COOKIE_SECRET, without that a random one is made and cookies don't survive a restart.
+var CookieSecret = randomSecret()
+
+func randomSecret() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("No randomness for the cookie secret ", err)
+	}
+	return b
+}
+
+func mac(playerId string) string {
+	h := hmac.New(sha256.New, CookieSecret)
+	h.Write([]byte(playerId))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// signId is the cookie value for a player, their id and its signature
+func signId(playerId string) string {
+	return playerId + "." + mac(playerId)
+}
+
+// verifyId gets the player id back out of a cookie value, false if it wasn't signed with CookieSecret
+func verifyId(value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i <= 0 {
+		return "", false
+	}
+	playerId := value[:i]
+	if !hmac.Equal([]byte(value[i+1:]), []byte(mac(playerId))) {
+		return "", false
+	}
+	return playerId, true
+}
+
+// unsigned are the players whose cookie from before cookies were signed has been swapped for a signed one
+var unsigned = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: map[string]bool{}}
+
+// legacyId takes a cookie from before cookies were signed, a bare player id, so the player keeps their games. It's
+// only taken once, after that the player has a signed cookie and the bare id is worth no more than a forged one.
+func legacyId(value string) (string, bool) {
+	if id, err := uuid.Parse(value); err != nil || id.String() != value {
+		return "", false
+	}
+	unsigned.Lock()
+	defer unsigned.Unlock()
+	if unsigned.seen[value] {
+		return "", false
+	}
+	unsigned.seen[value] = true
+	return value, true
+}
+
+// PlayerCookie is the signed cookie that makes the browser this player. It's Lax so other sites' posts don't carry it.
+func PlayerCookie(playerId string) *http.Cookie {
+	return &http.Cookie{
+		Name:     COOKIE_NAME,
+		Value:    signId(playerId),
+		Path:     "/",
+		Expires:  time.Now().Add(24 * 365 * time.Hour),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// requestPlayer is who the request's cookie says it's from, "" if there's no cookie or it wasn't signed by us
+func requestPlayer(r *http.Request) string {
+	cookie, err := r.Cookie(COOKIE_NAME)
+	if err != nil {
+		return ""
+	}
+	playerId, _ := verifyId(cookie.Value)
+	return playerId
+}
+
+// UseCookieSecret sets CookieSecret, an empty secret keeps the random one
+func UseCookieSecret(secret string) {
+	if secret == "" {
+		log.Println("No cookie secret set, players will get new identities when the server restarts")
+		return
+	}
+	CookieSecret = []byte(secret)
+}
@@ -2,23 +2,36 @@ package justone
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/jakecoffman/wg"
 	"log"
-	"math/rand"
-	"runtime/debug"
 	"strings"
-	"time"
 )
 
+// Name is the game type, used to restore saved games
+const Name = "justone"
+
 func init() {
-	rand.Seed(time.Now().UnixNano())
+	wg.RegisterRestorer(Name, Restore)
+	wg.RegisterBuilder(Name, create)
+	wg.RegisterCatalog(Name, wg.Catalog{
+		Commands: map[string]interface{}{
+			cmdName:      "",
+			cmdReady:     nil,
+			cmdWrite:     "",
+			cmdReconcile: "",
+			cmdGuess:     "",
+		},
+		Messages: map[string]interface{}{
+			"all": UpdateMsg{},
+		},
+	})
 }
 
 type JustOne struct {
 	*wg.Game
 
 	Players       []*Player
-	playerCursor  int
 	State         string
 	guesserCursor int
 
@@ -27,12 +40,7 @@ type JustOne struct {
 }
 
 type Player struct {
-	ws        wg.Connector
-	Uuid      string `json:"-"`
-	Id        int
-	Name      string
-	Connected bool
-	Ip        string `json:"-"`
+	*wg.Player
 	Ready     bool
 	Clue      string `json:"-"`
 	IsGuesser bool
@@ -48,17 +56,81 @@ func Find(players []*Player, uuid string) (*Player, int) {
 	return nil, -1
 }
 
-func NewGame(id string) *wg.Game {
+func NewGame(id string, opts ...wg.Option) *wg.Game {
+	game := create(id, opts...)
+	game.Start()
+	return game
+}
+
+func create(id string, opts ...wg.Option) *wg.Game {
 	g := &JustOne{
-		Players:      []*Player{},
-		playerCursor: 1,
+		Players: []*Player{},
 	}
-	g.Game = wg.NewGame(g, id)
-	go g.run()
+	g.Game = wg.NewGame(g, id, opts...)
+	g.Type = Name
+	g.StrictVersion = true
 	g.reset()
 	return g.Game
 }
 
+// state is everything in JustOne that needs saving, including the private parts
+type state struct {
+	Players       []*playerState
+	State         string
+	GuesserCursor int
+	GuessMe       string
+	Win           bool
+}
+
+type playerState struct {
+	Player
+	Uuid string
+	Clue string
+}
+
+func (g *JustOne) MarshalState() (json.RawMessage, error) {
+	s := &state{
+		State:         g.State,
+		GuesserCursor: g.guesserCursor,
+		GuessMe:       g.GuessMe,
+		Win:           g.Win,
+	}
+	for _, p := range g.Players {
+		s.Players = append(s.Players, &playerState{Player: *p, Uuid: p.Uuid, Clue: p.Clue})
+	}
+	return json.Marshal(s)
+}
+
+// Restore brings a saved game back, everyone starts out disconnected
+func Restore(snap *wg.Snapshot) (*wg.Game, error) {
+	var s state
+	if err := json.Unmarshal(snap.State, &s); err != nil {
+		return nil, err
+	}
+	g := &JustOne{
+		Players:       []*Player{},
+		State:         s.State,
+		guesserCursor: s.GuesserCursor,
+		GuessMe:       s.GuessMe,
+		Win:           s.Win,
+	}
+	for _, ps := range s.Players {
+		p := ps.Player
+		if p.Player == nil {
+			p.Player = &wg.Player{}
+		}
+		p.Uuid = ps.Uuid
+		p.Clue = ps.Clue
+		p.Connected = false
+		g.Players = append(g.Players, &p)
+	}
+	g.Game = wg.RestoreGame(g, snap)
+	g.StrictVersion = true
+	g.timers()
+	g.Start()
+	return g.Game, nil
+}
+
 func (g *JustOne) reset() {
 	g.State = stateLobby
 }
@@ -86,53 +158,60 @@ const (
 	cmdGuess      = "guess"
 )
 
-func (g *JustOne) run() {
-	var cmd *wg.Command
+func (g *JustOne) Roster() []*wg.Player {
+	var players []*wg.Player
+	for _, p := range g.Players {
+		players = append(players, p.Player)
+	}
+	return players
+}
 
-	defer func() {
-		if r := recover(); r != nil {
-			log.Println("Game crashed", r)
-			log.Printf("State: %#v\n", g)
-			log.Println("Last command received:", cmd)
-			debug.PrintStack()
-		}
-	}()
+func (g *JustOne) HandleCommand(cmd *wg.Command) bool {
+	defer g.timers()
+	switch cmd.Type {
+	case cmdReady:
+		return g.handleReady(cmd)
+	case cmdName:
+		return g.handleName(cmd)
+	case cmdWrite:
+		return g.handleWrite(cmd)
+	case cmdReconcile:
+		return g.handleReconcile(cmd)
+	case cmdGuess:
+		return g.handleGuess(cmd)
+	default:
+		log.Println("Unknown message:", cmd.Type)
+		return false
+	}
+}
 
-	var update bool
-	for {
-		cmd = <-g.Cmd
+// Timers keep one slow writer from holding up the round
+func (g *JustOne) Timers() map[string]int {
+	return map[string]int{stateWrite: 90}
+}
 
-		if g.Version != cmd.Version {
-			continue
+// Timeout skips the clues of anyone who didn't write one in time
+func (g *JustOne) Timeout(phase string) bool {
+	defer g.timers()
+	if phase != stateWrite || g.State != stateWrite {
+		return false
+	}
+	for _, p := range g.Players {
+		p.Ready = false
+		if p.Clue == "" && !p.IsGuesser {
+			p.SendMsg("Time ran out so your clue was skipped")
 		}
+	}
+	g.State = stateReconcile
+	return true
+}
 
-		switch cmd.Type {
-		case cmdJoin:
-			update = g.handleJoin(cmd)
-		case cmdLeave:
-			update = g.handleLeave(cmd)
-		case cmdDisconnect:
-			update = g.handleDisconnect(cmd)
-		case cmdStop:
-			return
-		case cmdReady:
-			update = g.handleReady(cmd)
-		case cmdName:
-			update = g.handleName(cmd)
-		case cmdWrite:
-			update = g.handleWrite(cmd)
-		case cmdReconcile:
-			update = g.handleReconcile(cmd)
-		case cmdGuess:
-			update = g.handleGuess(cmd)
-		default:
-			log.Println("Unknown message:", cmd.Type)
-			continue
-		}
-		if update {
-			g.sendEveryoneEverything()
-			g.Updated = time.Now()
-		}
+// timers arms the timer for the phase the game is in
+func (g *JustOne) timers() {
+	if g.State == stateWrite {
+		g.Arm(stateWrite)
+	} else {
+		g.Disarm()
 	}
 }
 
@@ -141,97 +220,73 @@ type UpdateMsg struct {
 	Update *JustOne
 }
 
-func (g *JustOne) sendEveryoneEverything() {
-	for _, p := range g.Players {
-		if p.ws != nil {
-			msg := &UpdateMsg{Type: "all", Update: g}
-			p.ws.Send(msg)
-		}
-	}
+func (g *JustOne) ViewFor(p *wg.Player) interface{} {
+	return &UpdateMsg{Type: "all", Update: g}
 }
 
-type MsgMsg struct {
-	Type string
-	Msg  string
+// SpectatorView is the same as everyone else's, the word and clues are never sent
+func (g *JustOne) SpectatorView() interface{} {
+	return &UpdateMsg{Type: "all", Update: g}
 }
 
-func sendMsg(c wg.Connector, msg string) {
-	c.Send(&MsgMsg{Type: "msg", Msg: msg})
+func (g *JustOne) Capacity() int {
+	return 10
 }
 
-func (g *JustOne) sendMsgAll(msg string) {
-	for _, p := range g.Players {
-		if p.ws != nil {
-			p.ws.Send(&MsgMsg{Type: "msg", Msg: msg})
+func (g *JustOne) InLobby() bool {
+	return g.State == stateLobby
+}
+
+// CanChat keeps the guesser out of the conversation while clues are being written
+func (g *JustOne) CanChat(from, to *wg.Player) bool {
+	if g.State != stateWrite {
+		return true
+	}
+	if p, _ := Find(g.Players, from.Uuid); p != nil && p.IsGuesser {
+		return false
+	}
+	if to != nil {
+		if p, _ := Find(g.Players, to.Uuid); p != nil && p.IsGuesser {
+			return false
 		}
 	}
+	return true
 }
 
-func (g *JustOne) handleJoin(cmd *wg.Command) bool {
-	player, i := Find(g.Players, cmd.PlayerId)
+func (g *JustOne) OnJoin(p *wg.Player) error {
+	_, i := Find(g.Players, p.Uuid)
 	if i == -1 {
 		// player was not here before
 		if g.State != stateLobby {
-			sendMsg(cmd.Ws, "Can't join game in progress")
-			return false
+			return errors.New("Can't join game in progress")
 		}
 		if len(g.Players) >= 10 {
 			// can't have more than 10 players
-			sendMsg(cmd.Ws, "Can't have more than 10 players")
-			return false
-		}
-		player = &Player{Uuid: cmd.PlayerId, Id: g.playerCursor}
-		g.Players = append(g.Players, player)
-		g.playerCursor += 1
-	}
-	player.ws = cmd.Ws
-	player.Connected = true
-	player.Ip = player.ws.Ip()
-	return true
-}
-
-func (g *JustOne) handleLeave(cmd *wg.Command) bool {
-	for i, player := range g.Players {
-		if player.Uuid == cmd.PlayerId {
-			g.Players = append(g.Players[0:i], g.Players[i+1:]...)
-			return true
+			return errors.New("Can't have more than 10 players")
 		}
+		g.Players = append(g.Players, &Player{Player: p})
 	}
-	return false
+	return nil
 }
 
-func (g *JustOne) handleDisconnect(cmd *wg.Command) bool {
-	player, i := Find(g.Players, cmd.PlayerId)
-	if i == -1 {
-		log.Println("Couldn't find player", cmd.PlayerId)
-		return false
-	}
-	player.ws = nil
-	player.Connected = false
-	return true
+func (g *JustOne) OnLeave(p *wg.Player) {
+	_, i := Find(g.Players, p.Uuid)
+	g.Players = append(g.Players[0:i], g.Players[i+1:]...)
 }
 
 func (g *JustOne) handleName(cmd *wg.Command) bool {
 	p, _ := Find(g.Players, cmd.PlayerId)
 	if g.State != stateLobby && p.Name != "" {
-		sendMsg(p.ws, "Can only change name in the lobby")
+		p.SendMsg("Can only change name in the lobby")
 		return false
 	}
 
-	var name string
-	err := json.Unmarshal(cmd.Data, &name)
-	if err != nil {
+	if err := p.Rename(cmd.Data); err != nil {
 		log.Println(err)
-		sendMsg(p.ws, "Got invalid data for name")
+		p.SendMsg("Got invalid data for name")
 		return false
 	}
 
-	if len(name) > 8 {
-		p.Name = name[0:8]
-	} else {
-		p.Name = name
-	}
-
 	return true
 }
 
@@ -239,7 +294,7 @@ func (g *JustOne) handleReady(cmd *wg.Command) bool {
 	p, _ := Find(g.Players, cmd.PlayerId)
 
 	if g.State != stateLobby && g.State != stateEnd {
-		sendMsg(p.ws, "Already ready already")
+		p.SendMsg("Already ready already")
 		return false
 	}
 
@@ -255,7 +310,7 @@ func (g *JustOne) handleReady(cmd *wg.Command) bool {
 	if g.guesserCursor > len(g.Players) {
 		g.guesserCursor = 0
 	}
-	g.GuessMe = wordlist[rand.Intn(len(wordlist))]
+	g.GuessMe = wordlist[g.Rand.Intn(len(wordlist))]
 
 	return true
 }
@@ -264,18 +319,18 @@ func (g *JustOne) handleWrite(cmd *wg.Command) bool {
 	p, _ := Find(g.Players, cmd.PlayerId)
 
 	if g.State != stateWrite {
-		sendMsg(p.ws, "Not in write state")
+		p.SendMsg("Not in write state")
 		return false
 	}
 
 	if p.IsGuesser {
-		sendMsg(p.ws, "Guesser doesn't write...")
+		p.SendMsg("Guesser doesn't write...")
 		return false
 	}
 
 	err := json.Unmarshal(cmd.Data, &p.Clue)
 	if err != nil {
-		sendMsg(p.ws, err.Error())
+		p.SendMsg(err.Error())
 		return false
 	}
 
@@ -294,7 +349,7 @@ func (g *JustOne) handleReconcile(cmd *wg.Command) bool {
 	p, _ := Find(g.Players, cmd.PlayerId)
 
 	if g.State != stateReconcile {
-		sendMsg(p.ws, "Not in reconcile state")
+		p.SendMsg("Not in reconcile state")
 		return false
 	}
 
@@ -303,7 +358,7 @@ func (g *JustOne) handleReconcile(cmd *wg.Command) bool {
 	var answer string
 	err := json.Unmarshal(cmd.Data, &answer)
 	if err != nil {
-		sendMsg(p.ws, err.Error())
+		p.SendMsg(err.Error())
 		return false
 	}
 	if answer == "dupe" {
@@ -324,12 +379,12 @@ func (g *JustOne) handleGuess(cmd *wg.Command) bool {
 	p, _ := Find(g.Players, cmd.PlayerId)
 
 	if g.State != stateGuess {
-		sendMsg(p.ws, "Not in guess state")
+		p.SendMsg("Not in guess state")
 		return false
 	}
 
 	if !p.IsGuesser {
-		sendMsg(p.ws, "Not the guesser")
+		p.SendMsg("Not the guesser")
 		return false
 	}
 
@@ -337,7 +392,7 @@ func (g *JustOne) handleGuess(cmd *wg.Command) bool {
 	var guess string
 	err := json.Unmarshal(cmd.Data, &guess)
 	if err != nil {
-		sendMsg(p.ws, err.Error())
+		p.SendMsg(err.Error())
 		return false
 	}
 	g.Win = strings.ToUpper(guess) == g.GuessMe
@@ -0,0 +1,111 @@
+package wg
+
+import (
+	"log"
+	"time"
+)
+
+// Lifecycle says when a room is over, a zero duration never ends the room for that reason
+type Lifecycle struct {
+	// Empty is how long a room with nobody in it lasts
+	Empty time.Duration
+	// Idle is how long a room lasts once every player has disconnected
+	Idle time.Duration
+	// Inactive is how long a room lasts without any commands, even with players connected
+	Inactive time.Duration
+	// MaxAge is how long any room lasts
+	MaxAge time.Duration
+}
+
+// DefaultLifecycle is used for game types that don't register their own
+var DefaultLifecycle = Lifecycle{
+	Empty:    10 * time.Minute,
+	Idle:     time.Hour,
+	Inactive: 48 * time.Hour,
+}
+
+var lifecycles = map[string]Lifecycle{}
+
+// RegisterLifecycle sets when rooms of a type are reaped, games do this in init
+func RegisterLifecycle(gameType string, l Lifecycle) {
+	lifecycles[gameType] = l
+}
+
+func lifecycleFor(gameType string) Lifecycle {
+	if l, ok := lifecycles[gameType]; ok {
+		return l
+	}
+	return DefaultLifecycle
+}
+
+// Reapable is implemented by games that want to know when their room is reaped, to notify players or archive
+// results. It's called from the game's goroutine just before it stops.
+type Reapable interface {
+	OnReap(reason string)
+}
+
+const (
+	ReapEmpty    = "empty"
+	ReapIdle     = "idle"
+	ReapInactive = "inactive"
+	ReapMaxAge   = "max age"
+	ReapStopped  = "stopped" // by an admin
+)
+
+// ReapedMsg tells players the room is gone
+type ReapedMsg struct {
+	Type   string
+	Reason string
+}
+
+// trackIdle notes when the room emptied or everyone disconnected, called after every command
+func (g *Game) trackIdle(now time.Time) {
+	roster := g.Rules.Roster()
+	if len(roster) > 0 {
+		g.emptySince = time.Time{}
+	} else if g.emptySince.IsZero() {
+		g.emptySince = now
+	}
+	for _, p := range roster {
+		if len(p.conns) > 0 {
+			g.idleSince = time.Time{}
+			return
+		}
+	}
+	if g.idleSince.IsZero() {
+		g.idleSince = now
+	}
+}
+
+// reapReason is why the room should end now, or empty if it shouldn't
+func (g *Game) reapReason(now time.Time) string {
+	l := lifecycleFor(g.Type)
+	over := func(since time.Time, d time.Duration) bool {
+		return d > 0 && !since.IsZero() && now.Sub(since) > d
+	}
+	switch {
+	case over(g.emptySince, l.Empty):
+		return ReapEmpty
+	case over(g.idleSince, l.Idle):
+		return ReapIdle
+	case over(g.Updated, l.Inactive):
+		return ReapInactive
+	case over(g.Created, l.MaxAge):
+		return ReapMaxAge
+	}
+	return ""
+}
+
+// reap ends the room from its own goroutine, the caller stops the goroutine after
+func (g *Game) reap(reason string) {
+	log.Println("Reaping game", g.Id, reason)
+	if r, ok := g.Rules.(Reapable); ok {
+		r.OnReap(reason)
+	}
+	g.SendAll(&ReapedMsg{Type: "reaped", Reason: reason})
+	if registry := g.games(); registry != nil {
+		registry.forget(g.Id)
+	}
+	// players are left holding a stopped game otherwise, reconnecting starts them somewhere new
+	g.hangUp()
+}
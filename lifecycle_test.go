@@ -0,0 +1,51 @@
+package wg
+
+import (
+	"testing"
+	"time"
+)
+
+type reapRules struct {
+	testRules
+	reason string
+}
+
+func (r *reapRules) OnReap(reason string) {
+	r.reason = reason
+}
+
+func TestLifecycle(t *testing.T) {
+	RegisterLifecycle("reaptest", Lifecycle{Empty: time.Minute, Idle: time.Hour})
+	rules := &reapRules{}
+	game := NewGame(rules, "1")
+	game.Type = "reaptest"
+	games := NewGames()
+	games.Set(game, "a")
+
+	now := time.Now()
+	game.trackIdle(now)
+	if game.reapReason(now.Add(2*time.Minute)) != ReapEmpty {
+		t.Error("Expected an empty room to be reaped")
+	}
+	game.handle(&Command{PlayerId: "a", Ws: NewFakeConn("a"), Type: cmdJoin})
+	game.trackIdle(now)
+	if reason := game.reapReason(now.Add(48 * time.Hour)); reason != "" {
+		t.Error("Rooms with players connected shouldn't be reaped", reason)
+	}
+	game.handle(&Command{PlayerId: "a", Type: cmdDisconnect})
+	game.trackIdle(now)
+	if reason := game.reapReason(now.Add(30 * time.Minute)); reason != "" {
+		t.Error("Disconnected players get the idle timeout", reason)
+	}
+
+	game.idleSince = now.Add(-2 * time.Hour)
+	game.Start()
+	games.reap()
+	<-game.done
+	if rules.reason != ReapIdle {
+		t.Error("Expected the game to be told it was reaped", rules.reason)
+	}
+	if games.Get("1") != nil || games.Find("a") != nil {
+		t.Error("Reaped games should be forgotten")
+	}
+}
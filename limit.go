@@ -0,0 +1,230 @@
+package wg
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on what one client can do, change them before serving
+var (
+	// CommandRate is the commands a second a player can keep up, CommandBurst how many they can send at once
+	CommandRate  = 10.0
+	CommandBurst = 20.0
+	// IpCommandRate and IpCommandBurst are the same for everyone behind one IP
+	IpCommandRate  = 40.0
+	IpCommandBurst = 80.0
+	// MaxPayloadBytes is the biggest message a client can send
+	MaxPayloadBytes = 16 * 1024
+	// MaxConnsPerIp is how many connections one IP can have open
+	MaxConnsPerIp = 20
+	// BanStrikes is how many times a minute an IP can go over a limit before it's banned for BanLength
+	BanStrikes = 30.0
+	BanLength  = 10 * time.Minute
+)
+
+// bucket is a token bucket, tokens refill at rate a second up to burst
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) take(now time.Time, rate, burst float64) bool {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// limiter keeps a bucket for each key
+type limiter struct {
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+func (l *limiter) take(key string, now time.Time, rate, burst float64) bool {
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	if now.Sub(l.pruned) > time.Minute {
+		// a bucket that has refilled is the same as no bucket
+		for k, b := range l.buckets {
+			if now.Sub(b.last).Seconds()*rate >= burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{}
+		l.buckets[key] = b
+	}
+	return b.take(now, rate, burst)
+}
+
+// abuse tracks every client of the server, it's shared by all games
+var abuse = struct {
+	sync.Mutex
+	players limiter
+	ips     limiter
+	strikes limiter
+	conns   map[string]int
+	bans    map[string]time.Time
+}{conns: map[string]int{}, bans: map[string]time.Time{}}
+
+// allowCommand takes a token for the player and their IP, going over counts as a strike against the IP
+func allowCommand(playerId, ip string) (allowed, banned bool) {
+	now := time.Now()
+	abuse.Lock()
+	defer abuse.Unlock()
+	if until, ok := abuse.bans[ip]; ok && now.Before(until) {
+		return false, true
+	}
+	if !abuse.players.take(playerId, now, CommandRate, CommandBurst) ||
+		(ip != "" && !abuse.ips.take(ip, now, IpCommandRate, IpCommandBurst)) {
+		return false, strike(ip, now, "commands")
+	}
+	return true, false
+}
+
+// strike counts against the IP and bans it if it has too many, the caller holds the lock
+func strike(ip string, now time.Time, reason string) bool {
+	if ip == "" || abuse.strikes.take(ip, now, BanStrikes/60, BanStrikes) {
+		return false
+	}
+	abuse.bans[ip] = now.Add(BanLength)
+	log.Println("Banned", ip, "for", BanLength, "after going over the limit on", reason)
+	return true
+}
+
+// isBanned is true while the IP is temporarily banned
+func isBanned(ip string) bool {
+	abuse.Lock()
+	defer abuse.Unlock()
+	until, ok := abuse.bans[ip]
+	if ok && time.Now().After(until) {
+		delete(abuse.bans, ip)
+		return false
+	}
+	return ok
+}
+
+// openConn counts a connection from the IP, false if it has too many already
+func openConn(ip string) bool {
+	if ip == "" {
+		return true
+	}
+	abuse.Lock()
+	defer abuse.Unlock()
+	if abuse.conns[ip] >= MaxConnsPerIp {
+		strike(ip, time.Now(), "connections")
+		return false
+	}
+	abuse.conns[ip]++
+	return true
+}
+
+func closeConn(ip string) {
+	if ip == "" {
+		return
+	}
+	abuse.Lock()
+	if abuse.conns[ip]--; abuse.conns[ip] <= 0 {
+		delete(abuse.conns, ip)
+	}
+	abuse.Unlock()
+}
+
+// TrustedProxies are the networks of the proxies in front of the server, only they are believed about X-Forwarded-For
+var TrustedProxies []*net.IPNet
+
+// TrustProxies sets TrustedProxies from CIDRs or plain IPs
+func TrustProxies(addrs ...string) error {
+	var nets []*net.IPNet
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "/") {
+			if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+				addr += "/32"
+			} else {
+				addr += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(addr)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, n)
+	}
+	TrustedProxies = nets
+	return nil
+}
+
+// UseTrustedProxies trusts a comma separated list of proxies, what servers read from WG_TRUSTED_PROXIES
+func UseTrustedProxies(list string) error {
+	var addrs []string
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return TrustProxies(addrs...)
+}
+
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIp is who sent the request. Only when it came through a trusted proxy is X-Forwarded-For read, right to left
+// since each proxy appends, and the first address that isn't one of the proxies is the client. Anything to the left of
+// that was sent by the client and can't be believed.
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// failedLogin counts a wrong password or bad login against the IP
+func failedLogin(ip string) {
+	abuse.Lock()
+	strike(ip, time.Now(), "logins")
+	abuse.Unlock()
+}
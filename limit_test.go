@@ -0,0 +1,105 @@
+package wg
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	var b bucket
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !b.take(now, 1, 3) {
+			t.Fatal("Expected the burst to be allowed", i)
+		}
+	}
+	if b.take(now, 1, 3) {
+		t.Error("Expected the bucket to be empty")
+	}
+	if !b.take(now.Add(time.Second), 1, 3) || b.take(now.Add(time.Second), 1, 3) {
+		t.Error("Expected one token a second")
+	}
+}
+
+// cleanAbuse forgets every limit, strike and ban, now and once the test is over
+func cleanAbuse(t *testing.T) {
+	reset := func() {
+		abuse.Lock()
+		defer abuse.Unlock()
+		abuse.players, abuse.ips, abuse.strikes = limiter{}, limiter{}, limiter{}
+		abuse.conns, abuse.bans = map[string]int{}, map[string]time.Time{}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestAbuse(t *testing.T) {
+	cleanAbuse(t)
+	defer func(burst, strikes float64) {
+		CommandBurst, BanStrikes = burst, strikes
+	}(CommandBurst, BanStrikes)
+	CommandBurst, BanStrikes = 2, 2
+
+	var allowed, limited int
+	banned := false
+	for i := 0; i < 10 && !banned; i++ {
+		var ok bool
+		if ok, banned = allowCommand("abuse-player", "9.9.9.9"); ok {
+			allowed++
+		} else {
+			limited++
+		}
+	}
+	if allowed != 2 || !banned {
+		t.Error("Expected the burst, then limits, then a ban", allowed, limited, banned)
+	}
+	if !isBanned("9.9.9.9") || isBanned("9.9.9.8") {
+		t.Error("Only the spamming IP should be banned")
+	}
+	if ok, _ := allowCommand("abuse-other", "9.9.9.8"); !ok {
+		t.Error("Other players shouldn't be limited")
+	}
+
+	defer func(max int) { MaxConnsPerIp = max }(MaxConnsPerIp)
+	MaxConnsPerIp = 1
+	if !openConn("8.8.8.8") || openConn("8.8.8.8") {
+		t.Error("Expected the second connection to be refused")
+	}
+	closeConn("8.8.8.8")
+	if !openConn("8.8.8.8") {
+		t.Error("Closing should free up the connection")
+	}
+	closeConn("8.8.8.8")
+}
+
+func TestClientIp(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "1.2.3.4:5678"
+	if ip := clientIp(r); ip != "1.2.3.4" {
+		t.Error(ip)
+	}
+	r.Header.Set("X-Forwarded-For", "5.6.7.8, 10.0.0.1")
+	if ip := clientIp(r); ip != "1.2.3.4" {
+		t.Error("Expected X-Forwarded-For to be ignored from anyone but a proxy", ip)
+	}
+
+	defer func() { TrustedProxies = nil }()
+	if err := TrustProxies("1.2.3.4", "10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if ip := clientIp(r); ip != "5.6.7.8" {
+		t.Error("Expected the hop before the proxies", ip)
+	}
+	// a client making up addresses only adds to the left
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 5.6.7.8, 10.0.0.1")
+	if ip := clientIp(r); ip != "5.6.7.8" {
+		t.Error("Expected the right-most untrusted hop", ip)
+	}
+	if err := UseTrustedProxies(" 1.2.3.4, ,10.0.0.0/8 "); err != nil || len(TrustedProxies) != 2 {
+		t.Error("Expected a list from the environment to be trusted", err, TrustedProxies)
+	}
+	if TrustProxies("nonsense") == nil {
+		t.Error("Expected bad proxies to be refused")
+	}
+}
@@ -0,0 +1,177 @@
+package wg
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// Describer is implemented by games that want to show up nicely in the lobby
+type Describer interface {
+	// Capacity is the most players the game allows, 0 means no limit
+	Capacity() int
+	// InLobby is true when new players can still join
+	InLobby() bool
+}
+
+// RoomInfo is what the lobby shows about a game
+type RoomInfo struct {
+	Id       string
+	Type     string
+	Public   bool
+	Players  int
+	Capacity int    `json:",omitempty"`
+	State    string // lobby or playing
+	Locked   bool   `json:",omitempty"`
+	Password bool   `json:",omitempty"` // joining needs a password
+	Removed  bool   `json:",omitempty"`
+}
+
+const (
+	roomLobby   = "lobby"
+	roomPlaying = "playing"
+)
+
+// JoinRequest is the data of a join command, older clients send just the id as a string
+type JoinRequest struct {
+	Id       string
+	Public   bool
+	Password string // sets the password when creating a game, checked when joining one
+	Type     string // the kind of game to create, servers with one kind ignore it
+}
+
+func (r *JoinRequest) UnmarshalJSON(b []byte) error {
+	var id string
+	if err := json.Unmarshal(b, &id); err == nil {
+		r.Id = id
+		return nil
+	}
+	type plain JoinRequest
+	return json.Unmarshal(b, (*plain)(r))
+}
+
+// LobbyMsg is the list of open games, sent when a connection starts listening to the lobby
+type LobbyMsg struct {
+	Type  string
+	Games []RoomInfo
+}
+
+// RoomMsg is a change to a single game in the lobby
+type RoomMsg struct {
+	Type string
+	Game RoomInfo
+}
+
+// describe is called from the game goroutine to work out what the lobby should show
+func (g *Game) describe() RoomInfo {
+	info := RoomInfo{Id: g.Id, Type: g.Type, Public: g.Public, State: roomLobby, Locked: g.Locked, Password: g.password != ""}
+	for _, p := range g.Rules.Roster() {
+		if p.Connected {
+			info.Players++
+		}
+	}
+	if d, ok := g.Rules.(Describer); ok {
+		info.Capacity = d.Capacity()
+		if !d.InLobby() {
+			info.State = roomPlaying
+		}
+	}
+	return info
+}
+
+// publish updates the lobby if anything it shows about the game changed
+func (g *Game) publish() {
+	registry := g.games()
+	if registry == nil {
+		return
+	}
+	info := g.describe()
+	registry.Lock()
+	changed := g.info != info
+	g.info = info
+	registry.Unlock()
+	if changed && (info.Public || g.wasPublic) {
+		registry.notify(info)
+	}
+	g.wasPublic = info.Public
+}
+
+// List returns the public games, newest first
+func (g *Games) List() []RoomInfo {
+	g.RLock()
+	defer g.RUnlock()
+	var infos []RoomInfo
+	for _, game := range g.games {
+		if game.info.Public {
+			infos = append(infos, game.info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return g.games[infos[i].Id].Created.After(g.games[infos[j].Id].Created)
+	})
+	return infos
+}
+
+// infos is what the lobby knows about every game, public or not
+func (g *Games) infos() []RoomInfo {
+	g.RLock()
+	defer g.RUnlock()
+	var infos []RoomInfo
+	for _, game := range g.games {
+		info := game.info
+		// games that haven't handled a command yet haven't been described
+		info.Id = game.Id
+		info.Type = game.Type
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+	return infos
+}
+
+// Listen sends the lobby to the connection and then keeps it up to date until Unlisten
+func (g *Games) Listen(ws Connector) {
+	g.Lock()
+	if g.listeners == nil {
+		g.listeners = map[Connector]bool{}
+	}
+	g.listeners[ws] = true
+	g.Unlock()
+	games := g.List()
+	if games == nil {
+		games = []RoomInfo{}
+	}
+	ws.Send(&LobbyMsg{Type: "lobby", Games: games})
+}
+
+func (g *Games) Unlisten(ws Connector) {
+	g.Lock()
+	delete(g.listeners, ws)
+	g.Unlock()
+}
+
+func (g *Games) notify(info RoomInfo) {
+	g.RLock()
+	var listeners []Connector
+	for ws := range g.listeners {
+		listeners = append(listeners, ws)
+	}
+	g.RUnlock()
+	for _, ws := range listeners {
+		ws.Send(&RoomMsg{Type: "room", Game: info})
+	}
+}
+
+// ListGames serves the public games as JSON
+func ListGames(w http.ResponseWriter, r *http.Request) {
+	games := AllGames.List()
+	if games == nil {
+		games = []RoomInfo{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(games); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,77 @@
+package wg
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJoinRequest(t *testing.T) {
+	var join JoinRequest
+	if err := json.Unmarshal([]byte(`"123456"`), &join); err != nil || join.Id != "123456" {
+		t.Error("Expected plain id to decode", join, err)
+	}
+	join = JoinRequest{}
+	if err := json.Unmarshal([]byte(`{"Id":"","Public":true}`), &join); err != nil || join.Id != "" || !join.Public {
+		t.Error("Expected object to decode", join, err)
+	}
+}
+
+func TestLobby(t *testing.T) {
+	games := NewGames()
+	listener := NewFakeConn("listener")
+	games.Listen(listener)
+	if msg := (<-listener.Msgs).(*LobbyMsg); len(msg.Games) != 0 {
+		t.Error("Expected no games", msg.Games)
+	}
+
+	private := NewGame(&testRules{}, "1")
+	games.Set(private)
+	private.handle(&Command{PlayerId: "a", Ws: NewFakeConn("a"), Type: cmdJoin})
+	private.publish()
+
+	public := NewGame(&testRules{}, "2")
+	public.Type = "test"
+	public.Public = true
+	games.Set(public)
+	public.handle(&Command{PlayerId: "b", Ws: NewFakeConn("b"), Type: cmdJoin})
+	public.publish()
+
+	if msg := (<-listener.Msgs).(*RoomMsg); msg.Game.Id != "2" || msg.Game.Players != 1 || msg.Game.State != roomLobby {
+		t.Error("Expected public game to be announced", msg.Game)
+	}
+	if len(listener.Msgs) != 0 {
+		t.Error("Private games shouldn't be announced")
+	}
+	public.publish()
+	if len(listener.Msgs) != 0 {
+		t.Error("Nothing changed so nothing should be announced")
+	}
+
+	list := games.List()
+	if len(list) != 1 || list[0].Id != "2" || list[0].Type != "test" {
+		t.Error("Expected only the public game to be listed", list)
+	}
+
+	games.Delete("2")
+	if msg := (<-listener.Msgs).(*RoomMsg); msg.Game.Id != "2" || !msg.Game.Removed {
+		t.Error("Expected game to be removed", msg.Game)
+	}
+
+	games.Unlisten(listener)
+	games.Set(public)
+	public.handle(&Command{PlayerId: "c", Ws: NewFakeConn("c"), Type: cmdJoin})
+	public.publish()
+	if len(listener.Msgs) != 0 {
+		t.Error("Listener left the lobby")
+	}
+}
+
+func TestListGames(t *testing.T) {
+	w := httptest.NewRecorder()
+	ListGames(w, httptest.NewRequest("GET", "/games", nil))
+	var infos []RoomInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil || infos == nil {
+		t.Error("Expected a JSON list", w.Body.String(), err)
+	}
+}
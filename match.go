@@ -0,0 +1,355 @@
+package wg
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Players who queue for a game type wait in a pool until there are enough of them close enough in rating to fill a
+// room. The longer someone waits the further from their rating they'll be matched, and after BotWait games that have
+// bots fill the seats nobody came for. The room is made, everyone is joined to it and the game is started for them.
+
+const (
+	cmdQueue   = "queue"
+	cmdUnqueue = "unqueue"
+	// sent by the server once the matched players are in, Data is how many bots to add
+	cmdMatch = "match"
+)
+
+const (
+	// matchSpread is how far apart in rating players are matched straight away
+	matchSpread = 100.0
+	// matchWiden is how much further apart they can be for every matchWidenEvery they wait
+	matchWiden      = 50.0
+	matchWidenEvery = 5 * time.Second
+	// matchTick is how often the pools are looked at
+	matchTick = time.Second
+)
+
+// BotWait is how long players wait for others before bots fill the empty seats
+var BotWait = 30 * time.Second
+
+// Matchmaking is how many players a game type is matched with, games register it in init to be queued for
+type Matchmaking struct {
+	Min, Max int
+	// Bots can fill seats, the game's rules implement Matchable
+	Bots bool
+}
+
+var matchmaking = map[string]Matchmaking{}
+
+// RegisterMatchmaking lets players queue for a game type, games do this in init
+func RegisterMatchmaking(gameType string, m Matchmaking) {
+	matchmaking[gameType] = m
+}
+
+// Matchable is implemented by games matchmaking can start. It's called from the game's goroutine once the matched
+// players have joined.
+type Matchable interface {
+	// StartMatch adds the bots and starts the game
+	StartMatch(bots int) error
+}
+
+// QueueRequest is the Data of the queue command, a plain string is just the Type. Min and Max narrow the number of
+// players the game is started with.
+type QueueRequest struct {
+	Type     string
+	Min, Max int `json:",omitempty"`
+}
+
+func (r *QueueRequest) UnmarshalJSON(b []byte) error {
+	var gameType string
+	if err := json.Unmarshal(b, &gameType); err == nil {
+		r.Type = gameType
+		return nil
+	}
+	type plain QueueRequest
+	return json.Unmarshal(b, (*plain)(r))
+}
+
+// QueueMsg tells a player where they are in the queue. Room is set once they've been matched, they're already in it.
+type QueueMsg struct {
+	Type     string
+	GameType string
+	Queued   bool
+	Waiting  int    `json:",omitempty"` // how many are in the pool
+	Room     string `json:",omitempty"`
+}
+
+// ticket is a player waiting in a pool
+type ticket struct {
+	gameType string
+	playerId string
+	ws       Connector
+	rating   float64
+	min, max int
+	queued   time.Time
+	newGame  func(gameType, id string) (*Game, error)
+
+	game   *Game         // set before joined is closed, nil if the room couldn't be made or the ticket was replaced
+	joined chan struct{} // closed once the player has been sent to the room
+}
+
+// spread is how far from their rating the player will be matched by now
+func (t *ticket) spread(now time.Time) float64 {
+	return matchSpread + matchWiden*math.Floor(float64(now.Sub(t.queued))/float64(matchWidenEvery))
+}
+
+// matchmaker keeps a pool of tickets for each game type
+type matchmaker struct {
+	sync.Mutex
+	clock Clock
+	pools map[string][]*ticket
+	timer Timer
+}
+
+func newMatchmaker(clock Clock) *matchmaker {
+	return &matchmaker{clock: clock, pools: map[string][]*ticket{}}
+}
+
+var queue = newMatchmaker(realClock{})
+
+// add puts the player in the pool for the game type
+func (m *matchmaker) add(req QueueRequest, playerId string, ws Connector, newGame func(gameType, id string) (*Game, error)) (*ticket, error) {
+	mm, ok := matchmaking[req.Type]
+	if !ok {
+		return nil, errors.New("Can't queue for that game")
+	}
+	t := &ticket{
+		gameType: req.Type,
+		playerId: playerId,
+		ws:       ws,
+		rating:   profiles.rating(req.Type, playerId),
+		min:      mm.Min,
+		max:      mm.Max,
+		newGame:  newGame,
+		joined:   make(chan struct{}),
+	}
+	if req.Min > t.min {
+		t.min = req.Min
+	}
+	if req.Max > 0 && req.Max < t.max {
+		t.max = req.Max
+	}
+	if t.min > t.max {
+		return nil, errors.New("No game has that many players")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	t.queued = m.clock.Now()
+	// a player queues once, from another tab the new ticket replaces the old one
+	for gameType, pool := range m.pools {
+		var rest []*ticket
+		for _, other := range pool {
+			if other.playerId != playerId {
+				rest = append(rest, other)
+				continue
+			}
+			if other.ws != ws {
+				other.ws.Send(&QueueMsg{Type: "queue", GameType: gameType})
+			}
+			close(other.joined)
+		}
+		m.pools[gameType] = rest
+	}
+	m.pools[req.Type] = append(m.pools[req.Type], t)
+	ws.Send(&QueueMsg{Type: "queue", GameType: req.Type, Queued: true, Waiting: len(m.pools[req.Type])})
+	if m.timer == nil {
+		m.timer = m.clock.AfterFunc(matchTick, m.run)
+	}
+	return t, nil
+}
+
+// leave takes the ticket out of its pool. If it was already matched it waits until the player is in the room and
+// returns the room, so the caller can go on as if they had joined it.
+func (m *matchmaker) leave(t *ticket) *Game {
+	m.Lock()
+	for gameType, pool := range m.pools {
+		for i, other := range pool {
+			if other == t {
+				m.pools[gameType] = append(pool[:i:i], pool[i+1:]...)
+				m.Unlock()
+				return nil
+			}
+		}
+	}
+	m.Unlock()
+	<-t.joined
+	return t.game
+}
+
+// matched is the room the ticket was matched to, false while it's still waiting
+func (m *matchmaker) matched(t *ticket) (*Game, bool) {
+	select {
+	case <-t.joined:
+		return t.game, true
+	default:
+		return nil, false
+	}
+}
+
+// run is called by the timer, it keeps going as long as anyone is waiting
+func (m *matchmaker) run() {
+	m.tick(m.clock.Now())
+	m.Lock()
+	defer m.Unlock()
+	m.timer = nil
+	for _, pool := range m.pools {
+		if len(pool) > 0 {
+			m.timer = m.clock.AfterFunc(matchTick, m.run)
+			return
+		}
+	}
+}
+
+// match is players to seat together
+type match struct {
+	gameType string
+	tickets  []*ticket
+	bots     int
+}
+
+// tick makes rooms for everyone who can be matched now
+func (m *matchmaker) tick(now time.Time) {
+	var matches []*match
+	m.Lock()
+	for gameType, pool := range m.pools {
+		for {
+			found := group(pool, matchmaking[gameType], now)
+			if found == nil {
+				break
+			}
+			found.gameType = gameType
+			matches = append(matches, found)
+			var rest []*ticket
+			for _, t := range pool {
+				if !found.has(t) {
+					rest = append(rest, t)
+				}
+			}
+			pool = rest
+		}
+		m.pools[gameType] = pool
+	}
+	m.Unlock()
+
+	for _, found := range matches {
+		found.open()
+	}
+}
+
+func (found *match) has(t *ticket) bool {
+	for _, other := range found.tickets {
+		if other == t {
+			return true
+		}
+	}
+	return false
+}
+
+// group finds players to seat together, starting with whoever has waited longest. Around them go the players
+// closest in rating that are within both of their spreads, as many as everyone's Min and Max allow.
+func group(pool []*ticket, mm Matchmaking, now time.Time) *match {
+	anchors := append([]*ticket(nil), pool...)
+	sort.SliceStable(anchors, func(i, j int) bool {
+		return anchors[i].queued.Before(anchors[j].queued)
+	})
+	for _, anchor := range anchors {
+		var near []*ticket
+		for _, t := range pool {
+			gap := math.Abs(t.rating - anchor.rating)
+			if t != anchor && gap <= anchor.spread(now) && gap <= t.spread(now) {
+				near = append(near, t)
+			}
+		}
+		sort.SliceStable(near, func(i, j int) bool {
+			return math.Abs(near[i].rating-anchor.rating) < math.Abs(near[j].rating-anchor.rating)
+		})
+
+		found := &match{tickets: []*ticket{anchor}}
+		lo, hi := anchor.min, anchor.max
+		for _, t := range near {
+			if len(found.tickets) >= hi {
+				break
+			}
+			if t.max <= len(found.tickets) || t.min > hi {
+				continue
+			}
+			found.tickets = append(found.tickets, t)
+			if t.min > lo {
+				lo = t.min
+			}
+			if t.max < hi {
+				hi = t.max
+			}
+		}
+		if len(found.tickets) >= lo {
+			return found
+		}
+		if mm.Bots && now.Sub(anchor.queued) >= BotWait {
+			found.bots = lo - len(found.tickets)
+			return found
+		}
+	}
+	return nil
+}
+
+// open makes the room, sends the players to it and starts the game
+func (found *match) open() {
+	var game *Game
+	var err error
+	if AllGames.Draining() {
+		err = errors.New(drainMsg)
+	} else {
+		game, err = found.tickets[0].newGame(found.gameType, GenId())
+	}
+	if err != nil {
+		log.Println("Couldn't make a matched game", err)
+		for _, t := range found.tickets {
+			sendError(t.ws, ErrUnknownType, err.Error())
+			t.ws.Send(&QueueMsg{Type: "queue", GameType: found.gameType})
+			close(t.joined)
+		}
+		return
+	}
+
+	var pids []string
+	for _, t := range found.tickets {
+		pids = append(pids, t.playerId)
+	}
+	log.Println("Matched", pids, "and", found.bots, "bots in game", game.Id)
+	AllGames.Set(game, pids...)
+	for _, t := range found.tickets {
+		t.ws.Send(&QueueMsg{Type: "queue", GameType: found.gameType, Room: game.Id})
+		game.deliver(&Command{Type: cmdJoin, PlayerId: t.playerId, Ws: t.ws})
+		t.game = game
+		close(t.joined)
+	}
+	data, _ := json.Marshal(found.bots)
+	game.deliver(&Command{Type: cmdMatch, Data: data})
+}
+
+// startMatch is the server's command to start a matched game
+func (g *Game) startMatch(cmd *Command) bool {
+	rules, ok := g.Rules.(Matchable)
+	if !ok {
+		return false
+	}
+	var bots int
+	if err := json.Unmarshal(cmd.Data, &bots); err != nil {
+		log.Println("Got invalid data for match", err)
+		return false
+	}
+	if err := rules.StartMatch(bots); err != nil {
+		log.Println("Couldn't start matched game", g.Id, err)
+		g.SendMsgAll(err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,247 @@
+package wg
+
+import (
+	"testing"
+	"time"
+)
+
+// matchRules remembers how matchmaking started it
+type matchRules struct {
+	testRules
+	started bool
+	bots    int
+}
+
+func (r *matchRules) StartMatch(bots int) error {
+	r.started, r.bots = true, bots
+	return nil
+}
+
+func newMatched(id string, opts ...Option) *Game {
+	game := NewGame(&matchRules{}, id, opts...)
+	game.Type = "matched"
+	return game
+}
+
+// testClock only moves when the test says, its timers never go off
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) AfterFunc(d time.Duration, f func()) Timer {
+	return stoppedTimer{}
+}
+
+type stoppedTimer struct{}
+
+func (stoppedTimer) Stop() bool {
+	return false
+}
+
+// nextQueue skips to the next queue message or error sent to the connection
+func nextQueue(t *testing.T, conn *scriptConn) interface{} {
+	t.Helper()
+	for {
+		select {
+		case msg := <-conn.Msgs:
+			switch msg.(type) {
+			case *QueueMsg, *MsgMsg:
+				return msg
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected a queue message")
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	now := time.Now()
+	at := func(rating float64, wait time.Duration) *ticket {
+		return &ticket{rating: rating, min: 2, max: 4, queued: now.Add(-wait)}
+	}
+	mm := Matchmaking{Min: 2, Max: 4}
+
+	far := at(1900, 0)
+	found := group([]*ticket{at(1500, 2*time.Second), far, at(1550, 0), at(1450, time.Second)}, mm, now)
+	if found == nil || len(found.tickets) != 3 || found.has(far) {
+		t.Fatal("Expected the players close in rating to be matched", found)
+	}
+	if group([]*ticket{far, at(1500, 0)}, mm, now) != nil {
+		t.Error("Expected players far apart to wait")
+	}
+	if group([]*ticket{at(1900, 40*time.Second), at(1500, 40*time.Second)}, mm, now) == nil {
+		t.Error("Expected players who waited long enough to meet")
+	}
+
+	small := at(1500, 0)
+	small.max = 2
+	if found = group([]*ticket{small, at(1500, 0), at(1500, 0)}, mm, now); found == nil || len(found.tickets) != 2 {
+		t.Error("Expected the most players someone asked for to be kept", found)
+	}
+
+	bots := Matchmaking{Min: 3, Max: 3, Bots: true}
+	alone := at(1500, 0)
+	alone.min, alone.max = 3, 3
+	if group([]*ticket{alone}, bots, now) != nil {
+		t.Error("Expected bots to wait for BotWait")
+	}
+	alone.queued = now.Add(-BotWait)
+	if found = group([]*ticket{alone}, bots, now); found == nil || found.bots != 2 {
+		t.Error("Expected bots to fill the empty seats", found)
+	}
+	if group([]*ticket{alone}, mm, now) != nil {
+		t.Error("Expected games without bots to keep waiting")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	RegisterBuilder("matched", newMatched)
+	RegisterMatchmaking("matched", Matchmaking{Min: 2, Max: 3, Bots: true})
+	clock := &testClock{now: time.Now()}
+	queue = newMatchmaker(clock)
+	defer func() { queue = newMatchmaker(realClock{}) }()
+
+	handle := ProcessGameTypes("matched")
+	var conns []*scriptConn
+	var done []chan struct{}
+	connect := func(playerId string) *scriptConn {
+		conn := &scriptConn{FakeConn: NewFakeConn(playerId), cmds: make(chan *Command)}
+		finished := make(chan struct{})
+		go func() {
+			handle(conn, "queue-"+playerId)
+			close(finished)
+		}()
+		conns = append(conns, conn)
+		done = append(done, finished)
+		return conn
+	}
+	defer func() {
+		for i, conn := range conns {
+			close(conn.cmds)
+			<-done[i]
+		}
+		for _, id := range AllGames.Ids() {
+			if game := AllGames.Get(id); game != nil && game.Type == "matched" {
+				AllGames.remove(game)
+			}
+		}
+	}()
+
+	a, b := connect("a"), connect("b")
+	a.cmds <- &Command{Type: cmdQueue, Data: []byte(`{"Type":"matched","Min":4}`)}
+	if msg, ok := nextQueue(t, a).(*MsgMsg); !ok || msg.Code != ErrInvalid {
+		t.Error("Expected more players than the game takes to be refused", msg)
+	}
+	a.cmds <- &Command{Type: cmdQueue, Data: []byte(`"matched"`)}
+	if msg, ok := nextQueue(t, a).(*QueueMsg); !ok || !msg.Queued || msg.Waiting != 1 {
+		t.Fatal("Expected to be queued", msg)
+	}
+	b.cmds <- &Command{Type: cmdQueue, Data: []byte(`"matched"`)}
+	nextQueue(t, b)
+
+	queue.tick(clock.now)
+	game := AllGames.Find("queue-a")
+	if game == nil || AllGames.Find("queue-b") != game {
+		t.Fatal("Expected a and b to be matched into one room")
+	}
+	for _, conn := range []*scriptConn{a, b} {
+		if msg, ok := nextQueue(t, conn).(*QueueMsg); !ok || msg.Room != game.Id || msg.Queued {
+			t.Error("Expected to hear which room", msg)
+		}
+	}
+	a.cmds <- &Command{Type: "roll"}
+	// the next command is only read once roll is with the game
+	a.cmds <- &Command{Type: cmdList}
+	game.Idle()
+	rules := game.Rules.(*matchRules)
+	if !rules.started || rules.bots != 0 || len(rules.players) != 2 {
+		t.Error("Expected the game to be started with both players", rules.started, rules.bots, len(rules.players))
+	}
+	if len(rules.handled) != 1 || rules.handled[0] != "roll" {
+		t.Error("Expected commands to go to the matched room", rules.handled)
+	}
+
+	c := connect("c")
+	c.cmds <- &Command{Type: cmdQueue, Data: []byte(`"matched"`)}
+	nextQueue(t, c)
+	queue.tick(clock.now)
+	if AllGames.Find("queue-c") != nil {
+		t.Fatal("Expected c to wait for someone else")
+	}
+	clock.now = clock.now.Add(BotWait)
+	queue.tick(clock.now)
+	game = AllGames.Find("queue-c")
+	if game == nil {
+		t.Fatal("Expected c to get a room once BotWait passed")
+	}
+	game.Idle()
+	if rules = game.Rules.(*matchRules); !rules.started || rules.bots != 1 {
+		t.Error("Expected a bot to fill the empty seat", rules.bots)
+	}
+
+	d := connect("d")
+	d.cmds <- &Command{Type: cmdQueue, Data: []byte(`"matched"`)}
+	nextQueue(t, d)
+	d.cmds <- &Command{Type: cmdUnqueue}
+	if msg, ok := nextQueue(t, d).(*QueueMsg); !ok || msg.Queued {
+		t.Error("Expected to leave the queue", msg)
+	}
+	if len(queue.pools["matched"]) != 0 {
+		t.Error("Expected the pool to be empty", len(queue.pools["matched"]))
+	}
+}
+
+func TestQueueTabs(t *testing.T) {
+	RegisterBuilder("matched", newMatched)
+	RegisterMatchmaking("matched", Matchmaking{Min: 2, Max: 3})
+	clock := &testClock{now: time.Now()}
+	queue = newMatchmaker(clock)
+	defer func() { queue = newMatchmaker(realClock{}) }()
+	newGame := func(gameType, id string) (*Game, error) {
+		game := newMatched(id)
+		game.Start()
+		return game, nil
+	}
+
+	first, second := NewFakeConn("a1"), NewFakeConn("a2")
+	old, err := queue.add(QueueRequest{Type: "matched"}, "a", first, newGame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-first.Msgs
+	if _, err = queue.add(QueueRequest{Type: "matched"}, "a", second, newGame); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.pools["matched"]) != 1 {
+		t.Fatal("Expected the player to be queued once", len(queue.pools["matched"]))
+	}
+	if msg, ok := (<-first.Msgs).(*QueueMsg); !ok || msg.Queued {
+		t.Error("Expected the first tab to be told it left the queue", msg)
+	}
+	if game, ok := queue.matched(old); !ok || game != nil {
+		t.Error("Expected the replaced ticket to be done with no room")
+	}
+	if queue.leave(old) != nil {
+		t.Error("Expected the replaced ticket to leave without a room")
+	}
+
+	queue.tick(clock.now)
+	if AllGames.Find("a") != nil {
+		t.Fatal("Expected the player not to be matched against themselves")
+	}
+	queue.add(QueueRequest{Type: "matched"}, "b", NewFakeConn("b"), newGame)
+	queue.tick(clock.now)
+	game := AllGames.Find("a")
+	if game == nil || AllGames.Find("b") != game {
+		t.Fatal("Expected a and b to be matched")
+	}
+	defer AllGames.remove(game)
+	game.Idle()
+	if rules := game.Rules.(*matchRules); !rules.started || len(rules.players) != 2 {
+		t.Error("Expected the game to start with two players", len(rules.players))
+	}
+}
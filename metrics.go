@@ -0,0 +1,76 @@
+package wg
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// counter is a count per game type that only goes up
+type counter struct {
+	sync.Mutex
+	counts map[string]int64
+}
+
+func (c *counter) inc(gameType string) {
+	c.Lock()
+	if c.counts == nil {
+		c.counts = map[string]int64{}
+	}
+	c.counts[gameType]++
+	c.Unlock()
+}
+
+func (c *counter) get() map[string]int64 {
+	c.Lock()
+	defer c.Unlock()
+	counts := map[string]int64{}
+	for k, v := range c.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+var (
+	commandsTotal counter
+	crashesTotal  counter
+	connections   int64 // open player connections, of any transport
+)
+
+// Metrics serves counts about the server in the Prometheus text format
+func Metrics(w http.ResponseWriter, r *http.Request) {
+	games := map[string]int64{}
+	players := map[string]int64{}
+	for _, info := range AllGames.infos() {
+		games[info.Type]++
+		players[info.Type] += int64(info.Players)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "wg_games", "gauge", "Games in memory.", games)
+	writeMetric(w, "wg_players_connected", "gauge", "Players connected to a game.", players)
+	writeMetric(w, "wg_commands_total", "counter", "Commands handled by games.", commandsTotal.get())
+	writeMetric(w, "wg_crashes_total", "counter", "Commands that crashed a game.", crashesTotal.get())
+	fmt.Fprintf(w, "# HELP wg_connections Open player connections.\n# TYPE wg_connections gauge\nwg_connections %d\n",
+		atomic.LoadInt64(&connections))
+}
+
+func writeMetric(w io.Writer, name, kind, help string, byType map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	var types []string
+	for t := range byType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(w, "%s{type=\"%s\"} %d\n", name, escapeLabel(t), byType[t])
+	}
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
@@ -0,0 +1,31 @@
+package wg
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	game := NewGame(&testRules{}, "metrics1")
+	game.Type = `odd"type`
+	AllGames.Set(game)
+	defer AllGames.Delete(game.Id)
+	crashesTotal.inc(game.Type)
+	crashes := crashesTotal.get()[game.Type]
+
+	w := httptest.NewRecorder()
+	Metrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE wg_games gauge",
+		`wg_games{type="odd\"type"} 1`,
+		fmt.Sprintf(`wg_crashes_total{type="odd\"type"} %d`, crashes),
+		"# TYPE wg_connections gauge",
+	} {
+		if !strings.Contains(body, line) {
+			t.Error("Expected", line, "in", body)
+		}
+	}
+}
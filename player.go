@@ -2,16 +2,27 @@ package wg
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"math/rand"
+	"sync"
+	"time"
 )
 
 const letterBytes = "1234567890"
 
+// ids has its own source so game codes aren't predictable, whatever anyone does to math/rand
+var ids = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
 func GenId() string {
+	ids.Lock()
+	defer ids.Unlock()
 	b := make([]byte, 6)
 	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+		b[i] = letterBytes[ids.Intn(len(letterBytes))]
 	}
 	return string(b)
 }
@@ -22,72 +33,366 @@ const (
 	cmdJoin       = "join"
 	cmdLeave      = "leave"
 	cmdStop       = "stop"
+	cmdSpectate   = "spectate"
+	cmdChat       = "chat"
+	cmdList       = "list"
+	cmdAck        = "ack"
+	cmdHello      = "hello"
+
+	// host only
+	cmdKick   = "kick"
+	cmdBan    = "ban"
+	cmdLock   = "lock"
+	cmdHost   = "host"
+	cmdTimers = "timers"
+
+	// sent by the server, never by players
+	cmdTimeout  = "timeout"
+	cmdInspect  = "inspect"
+	cmdAnnounce = "announce"
+	cmdReap     = "reap"
+	cmdSync     = "sync"
 )
 
+// Player is someone in a game, games embed it in their own player type
+type Player struct {
+	conns     []Connector // one for each tab or device the player has open
+	Uuid      string      `json:"-"`
+	Id        int
+	Name      string
+	Connected bool
+	Ip        string `json:"-"`
+}
+
+// Send sends a message to every connection the player has open
+func (p *Player) Send(v interface{}) {
+	for _, c := range p.conns {
+		c.Send(v)
+	}
+}
+
+// SendMsg shows the player a message
+func (p *Player) SendMsg(msg string) {
+	for _, c := range p.conns {
+		sendMsg(c, msg)
+	}
+}
+
+// SendError tells the player something didn't work, code is one of the Err constants
+func (p *Player) SendError(code, msg string) {
+	for _, c := range p.conns {
+		sendError(c, code, msg)
+	}
+}
+
+func (p *Player) hasConn(c Connector) bool {
+	return hasConn(p.conns, c)
+}
+
+func hasConn(conns []Connector, c Connector) bool {
+	for _, conn := range conns {
+		if conn == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Player) addConn(c Connector) {
+	if !p.hasConn(c) {
+		p.conns = append(p.conns, c)
+	}
+	p.Connected = true
+}
+
+// dropConn forgets one connection, or all of them when c is nil
+func (p *Player) dropConn(c Connector) {
+	var conns []Connector
+	for _, conn := range p.conns {
+		if c != nil && conn != c {
+			conns = append(conns, conn)
+		}
+	}
+	p.conns = conns
+	p.Connected = len(conns) > 0
+}
+
+// Rename sets the player's name from command data, names are cut off at 8 characters
+func (p *Player) Rename(data json.RawMessage) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	if len(name) > 8 {
+		p.Name = name[0:8]
+	} else {
+		p.Name = name
+	}
+	return nil
+}
+
+// MsgMsg shows the player a message, Code is set when it's an error
+type MsgMsg struct {
+	Type string
+	Msg  string
+	Code string `json:",omitempty"`
+}
+
+func sendMsg(c Connector, msg string) {
+	c.Send(&MsgMsg{Type: "msg", Msg: msg})
+}
+
+func sendError(c Connector, code, msg string) {
+	c.Send(&MsgMsg{Type: "msg", Msg: msg, Code: code})
+}
+
 type Command struct {
 	PlayerId string
 	Ws       Connector
 	Type     string
 	Version  int
 	Data     json.RawMessage
+
+	injected bool // by the game itself, counted until it's handled so Idle can wait for it
+}
+
+// SendMsg shows a message to whoever sent the command
+func (c *Command) SendMsg(msg string) {
+	if c.Ws != nil {
+		sendMsg(c.Ws, msg)
+	}
+}
+
+// SendError tells whoever sent the command why it didn't work, code is one of the Err constants
+func (c *Command) SendError(code, msg string) {
+	if c.Ws != nil {
+		sendError(c.Ws, code, msg)
+	}
+}
+
+// ProcessPlayerCommands handles the players of a server with one kind of game
+func ProcessPlayerCommands(NewGame func(string, ...Option) *Game) func(Connector, string) {
+	return processPlayerCommands(func(gameType string) (string, error) {
+		return gameType, nil
+	}, func(gameType, id string) (*Game, error) {
+		return NewGame(id), nil
+	})
 }
 
-func ProcessPlayerCommands(NewGame func(string) *Game) func(Connector, string) {
+// ProcessGameTypes handles the players of a server with several kinds of game, join picks which one is created.
+// Each type needs a registered builder, the first type is created when join doesn't say.
+func ProcessGameTypes(types ...string) func(Connector, string) {
+	if len(types) == 0 {
+		panic("no game types")
+	}
+	for _, t := range types {
+		if builders[t] == nil {
+			// this is programmer error, ok with panic
+			panic("no builder for game type " + t)
+		}
+	}
+	resolve := func(gameType string) (string, error) {
+		if gameType == "" {
+			gameType = types[0]
+		}
+		for _, t := range types {
+			if t == gameType {
+				return t, nil
+			}
+		}
+		return "", errors.New("Unknown game type")
+	}
+	return processPlayerCommands(resolve, func(gameType, id string) (*Game, error) {
+		t, err := resolve(gameType)
+		if err != nil {
+			return nil, err
+		}
+		game := builders[t](id)
+		game.Start()
+		return game, nil
+	})
+}
+
+// processPlayerCommands reads a player's commands until they go. resolve is the game type the server makes for the
+// type a player asked for, newGame makes a room of it.
+func processPlayerCommands(resolve func(gameType string) (string, error), newGame func(gameType, id string) (*Game, error)) func(Connector, string) {
 	return func(ws Connector, playerId string) {
-		cmd := &Command{}
 		var game *Game
+		// spectators can watch but any game commands they send are dropped
+		var spectating bool
+		// set while the player waits in the matchmaking queue
+		var queued *ticket
+		// unqueue takes the player out of the queue, or sends them on to the room they were just matched to
+		unqueue := func() {
+			if queued == nil {
+				return
+			}
+			if matched := queue.leave(queued); matched != nil {
+				game, spectating = matched, false
+			}
+			queued = nil
+		}
 
 		defer func() {
+			AllGames.Unlisten(ws)
+			unqueue()
 			if game != nil {
 				log.Printf("Player %v disconnected\n", playerId)
-				game.Cmd <- &Command{Type: cmdDisconnect, PlayerId: playerId}
+				game.deliver(&Command{Type: cmdDisconnect, PlayerId: playerId, Ws: ws})
 			}
 		}()
 
 		var id string
+		var join JoinRequest
 		for {
+			cmd := &Command{}
 			if err := ws.Recv(cmd); err != nil {
 				return
 			}
+			if allowed, banned := allowCommand(playerId, ws.Ip()); banned {
+				sendError(ws, ErrBanned, "You've been sending too much, come back later")
+				return
+			} else if !allowed {
+				sendError(ws, ErrRateLimited, "Slow down")
+				continue
+			}
 			cmd.Ws = ws
 			cmd.PlayerId = playerId
+			if queued != nil {
+				if matched, ok := queue.matched(queued); ok {
+					game, spectating, queued = matched, false, nil
+				}
+			}
 			switch cmd.Type {
+			case cmdHello:
+				protocol, ok := negotiate(cmd.Data)
+				if !ok {
+					sendError(ws, ErrProtocol, "Please refresh, this version of the game is no longer supported")
+					return
+				}
+				ws.Send(&HelloMsg{Type: "hello", Protocol: protocol, MinProtocol: minProtocol})
+			case cmdList:
+				AllGames.Listen(ws)
 			case cmdRejoin:
+				AllGames.Unlisten(ws)
+				unqueue()
 				game = AllGames.Find(playerId)
 				if game == nil {
+					if AllGames.Draining() {
+						sendError(ws, ErrDraining, drainMsg)
+						continue
+					}
+					var err error
 					id = GenId()
-					game = NewGame(id)
+					if game, err = newGame("", id); err != nil {
+						sendError(ws, ErrUnknownType, err.Error())
+						continue
+					}
 					AllGames.Set(game, playerId)
 				}
 				cmd.Type = cmdJoin
-				game.Cmd <- cmd
+				spectating = false
+				game.deliver(cmd)
 			case cmdJoin:
+				unqueue()
 				if game != nil {
-					game.Cmd <- &Command{Type: cmdLeave, PlayerId: playerId}
+					game.deliver(&Command{Type: cmdLeave, PlayerId: playerId, Ws: ws})
 					game = nil
 				}
+				spectating = false
+				AllGames.Unlisten(ws)
 
-				if err := json.Unmarshal(cmd.Data, &id); err != nil {
+				join = JoinRequest{}
+				if err := json.Unmarshal(cmd.Data, &join); err != nil {
 					log.Println("Couldn't decode join code", err)
 					continue
 				}
+				id = join.Id
+
+				if id != "" {
+					game = AllGames.Get(id)
+				}
+				if game == nil && AllGames.Draining() {
+					sendError(ws, ErrDraining, drainMsg)
+					continue
+				}
 
 				// new
-				if id == "" {
+				if game == nil {
+					var err error
 					id = GenId()
-					game = NewGame(id)
+					if game, err = newGame(join.Type, id); err != nil {
+						sendError(ws, ErrUnknownType, err.Error())
+						continue
+					}
+					game.Public = join.Public
+					game.password = join.Password
 					AllGames.Set(game, playerId)
-				} else if game = AllGames.Get(id); game == nil {
-					id = GenId()
-					game = NewGame(id)
+				} else {
+					// remember where the player went so rejoin finds it
 					AllGames.Set(game, playerId)
 				}
-				game.Cmd <- cmd
+				game.deliver(cmd)
+			case cmdSpectate:
+				// the code, or a JoinRequest with the password
+				var spectate JoinRequest
+				if err := json.Unmarshal(cmd.Data, &spectate); err != nil {
+					log.Println("Couldn't decode spectate code", err)
+					continue
+				}
+				id = spectate.Id
+				watch := AllGames.Get(id)
+				if watch == nil {
+					sendError(ws, ErrNotFound, "Game not found")
+					continue
+				}
+				AllGames.Unlisten(ws)
+				unqueue()
+				if game != nil {
+					game.deliver(&Command{Type: cmdLeave, PlayerId: playerId, Ws: ws})
+				}
+				game = watch
+				spectating = true
+				game.deliver(cmd)
+			case cmdQueue:
+				var req QueueRequest
+				if err := json.Unmarshal(cmd.Data, &req); err != nil {
+					sendError(ws, ErrBadData, "Got invalid data for queue")
+					continue
+				}
+				if AllGames.Draining() {
+					sendError(ws, ErrDraining, drainMsg)
+					continue
+				}
+				var err error
+				if req.Type, err = resolve(req.Type); err != nil {
+					sendError(ws, ErrUnknownType, err.Error())
+					continue
+				}
+				unqueue()
+				if game != nil {
+					game.deliver(&Command{Type: cmdLeave, PlayerId: playerId, Ws: ws})
+					game = nil
+				}
+				spectating = false
+				AllGames.Unlisten(ws)
+				if queued, err = queue.add(req, playerId, ws, newGame); err != nil {
+					sendError(ws, ErrInvalid, err.Error())
+				}
+			case cmdUnqueue:
+				if queued == nil {
+					continue
+				}
+				gameType := queued.gameType
+				unqueue()
+				if game == nil {
+					ws.Send(&QueueMsg{Type: "queue", GameType: gameType})
+				}
 			case cmdStop:
 				// players can't stop the game goroutine
 			default:
-				if game != nil {
-					game.Cmd <- cmd
+				if game != nil && !spectating {
+					game.deliver(cmd)
 				}
 			}
 		}
@@ -0,0 +1,56 @@
+package wg
+
+import (
+	"io"
+	"testing"
+)
+
+// scriptConn is a player sending the commands put on cmds
+type scriptConn struct {
+	*FakeConn
+	cmds chan *Command
+}
+
+func (c *scriptConn) Recv(v interface{}) error {
+	cmd, ok := <-c.cmds
+	if !ok {
+		return io.EOF
+	}
+	*v.(*Command) = *cmd
+	return nil
+}
+
+func TestGameTypes(t *testing.T) {
+	RegisterBuilder("dice", newDice)
+	RegisterBuilder("typetest", func(id string, opts ...Option) *Game {
+		game := NewGame(&testRules{}, id, opts...)
+		game.Type = "typetest"
+		return game
+	})
+	conn := &scriptConn{FakeConn: NewFakeConn("a"), cmds: make(chan *Command)}
+	done := make(chan struct{})
+	go func() {
+		ProcessGameTypes("dice", "typetest")(conn, "typetest-a")
+		close(done)
+	}()
+
+	conn.cmds <- &Command{Type: cmdJoin, Data: []byte(`{"Type":"typetest"}`)}
+	<-conn.Msgs
+	game := AllGames.Find("typetest-a")
+	if game == nil || game.Type != "typetest" {
+		t.Fatal("Expected join to make the type asked for", game)
+	}
+	defer AllGames.remove(game)
+
+	conn.cmds <- &Command{Type: cmdJoin, Data: []byte(`{"Type":"nope"}`)}
+	for {
+		if msg, ok := (<-conn.Msgs).(*MsgMsg); ok {
+			if msg.Msg != "Unknown game type" {
+				t.Error("Expected unknown types to be refused", msg.Msg)
+			}
+			break
+		}
+	}
+	close(conn.cmds)
+	<-done
+}
@@ -0,0 +1,161 @@
+package wg
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// ProtocolVersion is the wire protocol this server speaks, it goes up when messages change in ways old clients
+// can't handle. Version 1 is clients from before hello and error codes, version 2 is clients that don't answer pings.
+const ProtocolVersion = 3
+
+// minProtocol is the oldest protocol still served
+const minProtocol = 1
+
+// Error codes, sent in MsgMsg.Code so clients don't have to match on the text
+const (
+	ErrBadData            = "bad_data"        // Data doesn't have the shape the command takes
+	ErrUnknownCommand     = "unknown_command" // the game has no command of that type
+	ErrNotHost            = "not_host"        // only the host can do that
+	ErrNotFound           = "not_found"       // no such game or player
+	ErrRefused            = "refused"         // the game wouldn't let the player in
+	ErrUnknownType        = "unknown_type"    // the server doesn't make games of that type
+	ErrDraining           = "draining"        // the server isn't making new games
+	ErrProtocol           = "protocol"        // the client's protocol isn't supported
+	ErrInvalid            = "invalid"         // the game rules don't allow it
+	ErrRateLimited        = "rate_limited"    // the command was dropped for coming too fast
+	ErrBanned             = "banned"          // the IP is banned for a while for going over the limits
+	ErrTooManyConnections = "too_many_connections"
+)
+
+// HelloMsg answers a client's hello with the protocol agreed on
+type HelloMsg struct {
+	Type        string
+	Protocol    int
+	MinProtocol int
+}
+
+// HelloRequest is the Data of the hello command, the newest protocol the client speaks
+type HelloRequest struct {
+	Protocol int
+}
+
+// negotiate picks the protocol for a client, false if there isn't one both sides speak
+func negotiate(data json.RawMessage) (int, bool) {
+	var hello HelloRequest
+	if err := json.Unmarshal(data, &hello); err != nil {
+		return 0, false
+	}
+	protocol := hello.Protocol
+	if protocol > ProtocolVersion {
+		protocol = ProtocolVersion
+	}
+	return protocol, protocol >= minProtocol
+}
+
+// Catalog declares the messages of a game. Commands maps each command a player can send to an example of its Data,
+// nil for commands that take none. Messages maps each message Type the game sends to an example of the message.
+type Catalog struct {
+	Commands map[string]interface{}
+	Messages map[string]interface{}
+}
+
+// OneOf is the Data of a command that takes one of a few shapes
+type OneOf []interface{}
+
+var catalogs = map[string]Catalog{}
+
+// RegisterCatalog declares the messages of a game type, commands not in it are refused. Games do this in init.
+func RegisterCatalog(gameType string, c Catalog) {
+	catalogs[gameType] = c
+}
+
+// coreCatalog is what every game understands and sends, whatever the type
+var coreCatalog = Catalog{
+	Commands: map[string]interface{}{
+		cmdHello:    HelloRequest{},
+		cmdList:     nil,
+		cmdJoin:     OneOf{"", JoinRequest{}},
+		cmdRejoin:   nil,
+		cmdQueue:    OneOf{"", QueueRequest{}},
+		cmdUnqueue:  nil,
+		cmdLeave:    nil,
+		cmdSpectate: OneOf{"", JoinRequest{}},
+		cmdChat:     chatIn{},
+		cmdAck:      0,
+		cmdKick:     0,
+		cmdBan:      0,
+		cmdHost:     0,
+		cmdLock:     false,
+		cmdTimers:   map[string]int{},
+		cmdResume:   ResumeRequest{},
+		cmdPong:     nil,
+	},
+	Messages: map[string]interface{}{
+		"cookie":      cookieMsg{},
+		"hello":       HelloMsg{},
+		"session":     SessionMsg{},
+		"msg":         MsgMsg{},
+		"lobby":       LobbyMsg{},
+		"queue":       QueueMsg{},
+		"room":        RoomMsg{},
+		"chat":        ChatMsg{},
+		"chathistory": ChatHistoryMsg{},
+		"kicked":      KickedMsg{},
+		"reaped":      ReapedMsg{},
+		"patch":       PatchMsg{},
+		"snapshot":    SnapshotMsg{},
+		"resume":      ResumeMsg{},
+		"ping":        PingMsg{},
+	},
+}
+
+// NewMessage makes an empty message of the kind a game sends as msgType for decoding into, nil if the game's
+// catalog doesn't declare it. Clients use it to get game messages back as the game's own types.
+func NewMessage(gameType, msgType string) interface{} {
+	example, ok := catalogs[gameType].Messages[msgType]
+	if !ok {
+		example, ok = coreCatalog.Messages[msgType]
+	}
+	if !ok || example == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(example)).Interface()
+}
+
+// checkCommand makes sure a game command is in the game's catalog and its Data has the right shape
+func (g *Game) checkCommand(cmd *Command) (code string, err error) {
+	catalog, ok := catalogs[g.Type]
+	if !ok {
+		return "", nil
+	}
+	example, ok := catalog.Commands[cmd.Type]
+	if !ok {
+		return ErrUnknownCommand, fmt.Errorf("Unknown command %v", cmd.Type)
+	}
+	if example == nil {
+		return "", nil
+	}
+	if err := decodeAs(example, cmd.Data); err != nil {
+		return ErrBadData, fmt.Errorf("Got invalid data for %v", cmd.Type)
+	}
+	return "", nil
+}
+
+func decodeAs(example interface{}, data json.RawMessage) error {
+	if shapes, ok := example.(OneOf); ok {
+		var err error
+		for _, shape := range shapes {
+			if err = decodeAs(shape, data); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+	v := reflect.New(reflect.TypeOf(example)).Interface()
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,93 @@
+package wg
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type schemaNode struct {
+	Name     string
+	Skip     string `json:"-"`
+	Count    int    `json:"count,omitempty"`
+	Children []*schemaNode
+	hidden   bool
+}
+
+type schemaEmbed struct {
+	schemaNode
+	Extra bool
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf(schemaEmbed{})
+	props := s["properties"].(Schema)
+	if _, ok := props["Skip"]; ok {
+		t.Error("json:\"-\" fields shouldn't be described")
+	}
+	if _, ok := props["hidden"]; ok {
+		t.Error("Unexported fields shouldn't be described")
+	}
+	if props["count"].(Schema)["type"] != "integer" || props["Name"].(Schema)["type"] != "string" {
+		t.Error("Expected embedded fields to be promoted under their JSON names", props)
+	}
+	if !reflect.DeepEqual(s["required"], []string{"Children", "Extra", "Name"}) {
+		t.Error("omitempty fields aren't always there", s["required"])
+	}
+	if _, err := json.Marshal(ProtocolSchema()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	RegisterCatalog("checked", Catalog{Commands: map[string]interface{}{
+		"move": []int{},
+		"pass": nil,
+		"pick": OneOf{0, schemaNode{}},
+	}})
+	rules := &testRules{}
+	game := NewGame(rules, "1")
+	game.Type = "checked"
+	conn := NewFakeConn("a")
+	game.handle(&Command{PlayerId: "a", Ws: conn, Type: cmdJoin})
+	drain(conn)
+
+	for _, c := range []struct {
+		cmd  string
+		data string
+		code string
+	}{
+		{"move", `[1,2]`, ""},
+		{"move", `"up"`, ErrBadData},
+		{"pass", `{"anything":1}`, ""},
+		{"pick", `3`, ""},
+		{"pick", `{"Name":"x"}`, ""},
+		{"pick", `"x"`, ErrBadData},
+		{"dance", ``, ErrUnknownCommand},
+	} {
+		before := len(rules.handled)
+		game.handle(&Command{PlayerId: "a", Ws: conn, Type: c.cmd, Data: []byte(c.data)})
+		if c.code == "" {
+			if len(rules.handled) != before+1 {
+				t.Error("Expected", c.cmd, c.data, "to reach the game")
+			}
+			continue
+		}
+		msgs := drain(conn)
+		if len(rules.handled) != before || len(msgs) != 1 || msgs[0].(*MsgMsg).Code != c.code {
+			t.Error("Expected", c.cmd, c.data, "to be refused with", c.code, msgs)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	if p, ok := negotiate([]byte(`{"Protocol":99}`)); !ok || p != ProtocolVersion {
+		t.Error("Newer clients should get the newest protocol the server speaks", p)
+	}
+	if p, ok := negotiate([]byte(`{"Protocol":1}`)); !ok || p != 1 {
+		t.Error("Older clients should get what they speak", p)
+	}
+	if _, ok := negotiate([]byte(`{"Protocol":0}`)); ok {
+		t.Error("Expected too old a protocol to be refused")
+	}
+}
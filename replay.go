@@ -0,0 +1,161 @@
+package wg
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Builder makes a game that hasn't been started yet, so it can be replayed
+type Builder func(id string, opts ...Option) *Game
+
+var builders = map[string]Builder{}
+
+// RegisterBuilder tells wg how to make games of a type for replays, games do this in init
+func RegisterBuilder(gameType string, build Builder) {
+	builders[gameType] = build
+}
+
+// GameTypes lists every game type that registered a builder
+func GameTypes() []string {
+	var types []string
+	for t := range builders {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ReplayMsg is the game after one of its events, the replay endpoint streams these
+type ReplayMsg struct {
+	Type    string
+	Event   string
+	Time    time.Time
+	Version int
+	Update  interface{}
+}
+
+// Replay rebuilds a game from its event log, stopping at the first event applied at version or later, 0 plays
+// everything. each is called after every event with the game as it was then.
+func Replay(id string, events []*Event, version int, each func(g *Game, e *Event)) (*Game, error) {
+	if len(events) == 0 || events[0].Type != eventNew {
+		return nil, errors.New("event log doesn't start with a new game")
+	}
+	var gameType string
+	if err := json.Unmarshal(events[0].Data, &gameType); err != nil {
+		return nil, err
+	}
+	build, ok := builders[gameType]
+	if !ok {
+		return nil, errors.New("can't replay games of type " + gameType)
+	}
+	g := build(id, WithSeed(events[0].Seed))
+	g.replaying = true
+	if each != nil {
+		each(g, events[0])
+	}
+	for _, e := range events[1:] {
+		if version > 0 && e.GameVersion >= version {
+			break
+		}
+		g.Rand.Seed(e.Seed)
+		g.cmdSeed = e.Seed
+		g.handle(&Command{PlayerId: e.PlayerId, Ws: replayConn{ip: e.Ip, conn: e.Conn}, Type: e.Type, Version: e.Version, Data: e.Data})
+		if each != nil {
+			each(g, e)
+		}
+	}
+	return g, nil
+}
+
+// ReplayGame streams what spectators would have seen after each event, one JSON message per line.
+// Pass ?version= to stop early. Anything newer than the game's spectator delay is left out.
+// Only public games without a password can be replayed once they've ended, the admin token replays any game.
+func ReplayGame(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/replay/")
+	if Events == nil {
+		http.Error(w, "Games aren't being logged", http.StatusNotFound)
+		return
+	}
+	var version int
+	if v := r.URL.Query().Get("version"); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Bad version", http.StatusBadRequest)
+			return
+		}
+	}
+	events, err := Events.Events(id)
+	if err != nil || len(events) == 0 {
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+	if !isAdmin(r) {
+		// private games look the same as missing ones
+		if !events[len(events)-1].Public {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+		if AllGames.Get(id) != nil {
+			http.Error(w, "The game hasn't ended yet", http.StatusForbidden)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	_, err = Replay(id, events, version, func(g *Game, e *Event) {
+		view, ok := g.Rules.(Spectatable)
+		if !ok || time.Since(e.Time) < g.SpectatorDelay {
+			return
+		}
+		msg := &ReplayMsg{Type: "replay", Event: e.Type, Time: e.Time, Version: g.Version, Update: view.SpectatorView()}
+		if err := enc.Encode(msg); err != nil {
+			log.Println(err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// replayConn stands in for the players' connections during a replay, nothing is sent anywhere. Each connection the
+// game had is told apart by the number it was logged with, so one tab closing doesn't close a player's others.
+type replayConn struct {
+	ip   string
+	conn int64
+}
+
+func (c replayConn) Send(v interface{}) {}
+
+func (c replayConn) Recv(v interface{}) error {
+	return errors.New("replays can't be read from")
+}
+
+func (c replayConn) SendRaw(v []byte) {}
+
+func (c replayConn) RecvRaw(v []byte) error {
+	return errors.New("replays can't be read from")
+}
+
+func (c replayConn) Close() error {
+	return nil
+}
+
+func (c replayConn) Ip() string {
+	return c.ip
+}
+
+func (c replayConn) Cookie(name string) (*http.Cookie, error) {
+	return nil, http.ErrNoCookie
+}
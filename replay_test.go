@@ -0,0 +1,203 @@
+package wg
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// diceRules rolls a die for every command, so replays only match if the randomness does
+type diceRules struct {
+	testRules
+	game  *Game
+	rolls []int
+}
+
+func (r *diceRules) HandleCommand(cmd *Command) bool {
+	r.rolls = append(r.rolls, r.game.Rand.Intn(1000))
+	r.game.Version++
+	return true
+}
+
+func (r *diceRules) SpectatorView() interface{} {
+	return r.rolls
+}
+
+func newDice(id string, opts ...Option) *Game {
+	r := &diceRules{}
+	r.game = NewGame(r, id, opts...)
+	r.game.Type = "dice"
+	return r.game
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Events = store
+	defer func() { Events = nil }()
+	RegisterBuilder("dice", newDice)
+
+	game := newDice("1", WithSeed(42))
+	game.Start()
+	conn := NewFakeConn("a")
+	game.Cmd <- &Command{PlayerId: "a", Ws: conn, Type: cmdJoin}
+	for i := 0; i < 5; i++ {
+		game.Cmd <- &Command{PlayerId: "a", Ws: conn, Type: "roll"}
+	}
+	game.Cmd <- &Command{PlayerId: "a", Ws: conn, Type: cmdChat, Data: []byte(`{"Text":"hi"}`)}
+	game.Cmd <- &Command{Type: cmdStop}
+	rolls := game.Rules.(*diceRules).rolls
+
+	events, err := store.Events("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 7 || events[0].Type != eventNew || events[0].Seed != 42 {
+		t.Fatal("Expected the new game, the join and the rolls to be logged", len(events))
+	}
+
+	replayed, err := Replay("1", events, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := replayed.Rules.(*diceRules).rolls
+	if len(again) != len(rolls) || replayed.Version != 5 {
+		t.Fatal("Expected every roll to be replayed", again)
+	}
+	for i := range rolls {
+		if rolls[i] != again[i] {
+			t.Error("Replay rolled differently", rolls, again)
+		}
+	}
+	if replayed.Player("a") == nil {
+		t.Error("Expected the player to be replayed too")
+	}
+
+	replayed, _ = Replay("1", events, 3, nil)
+	if replayed.Version != 3 || len(replayed.Rules.(*diceRules).rolls) != 3 {
+		t.Error("Expected replay to stop at version 3", replayed.Version)
+	}
+
+	w := httptest.NewRecorder()
+	ReplayGame(w, httptest.NewRequest("GET", "/replay/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Error("Expected private games to need the admin token", w.Code)
+	}
+	defer func() { AdminToken = "" }()
+	AdminToken = "secret"
+	req := httptest.NewRequest("GET", "/replay/1?version=2", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	ReplayGame(w, req)
+	scanner := bufio.NewScanner(w.Body)
+	var msgs []ReplayMsg
+	for scanner.Scan() {
+		var msg ReplayMsg
+		if err = json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	// new game, join and two rolls
+	if len(msgs) != 4 || msgs[3].Version != 2 {
+		t.Error("Expected the updates up to version 2", msgs)
+	}
+
+	w = httptest.NewRecorder()
+	ReplayGame(w, httptest.NewRequest("GET", "/replay/nope", nil))
+	if w.Code != 404 {
+		t.Error("Expected missing game to 404", w.Code)
+	}
+
+	public := newDice("2", WithSeed(42))
+	public.Public = true
+	public.Start()
+	AllGames.Set(public, "a")
+	public.Cmd <- &Command{PlayerId: "a", Ws: conn, Type: cmdJoin}
+	public.Idle()
+	w = httptest.NewRecorder()
+	ReplayGame(w, httptest.NewRequest("GET", "/replay/2", nil))
+	if w.Code != http.StatusForbidden {
+		t.Error("Expected games still going not to be replayed", w.Code)
+	}
+	AllGames.remove(public)
+	w = httptest.NewRecorder()
+	ReplayGame(w, httptest.NewRequest("GET", "/replay/2", nil))
+	if w.Code != http.StatusOK {
+		t.Error("Expected an ended public game to be replayed", w.Code)
+	}
+
+	private := newDice("3", WithSeed(42))
+	private.Start()
+	AllGames.Set(private, "b")
+	private.Cmd <- &Command{PlayerId: "b", Ws: NewFakeConn("b"), Type: cmdJoin}
+	AllGames.remove(private)
+	if events, _ = store.Events("3"); len(events) != 0 {
+		t.Error("Expected the log of an ended private game to be deleted", len(events))
+	}
+}
+
+func TestWithSeed(t *testing.T) {
+	a := NewGame(&testRules{}, "1", WithSeed(7))
+	b := NewGame(&testRules{}, "2", WithSeed(7))
+	if a.Seed() != 7 || a.Rand.Int63() != b.Rand.Int63() {
+		t.Error("Games with the same seed should roll the same")
+	}
+	a.record(&Command{Type: "roll"})
+	b.record(&Command{Type: "roll"})
+	if a.cmdSeed != b.cmdSeed || a.Rand.Int63() != b.Rand.Int63() {
+		t.Error("Commands should be seeded the same too")
+	}
+	if NewGame(&testRules{}, "3").Seed() == 7 {
+		t.Error("Games should be seeded randomly by default")
+	}
+}
+
+func TestReplayTabs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, _ := NewFileStore(dir)
+	Events = store
+	defer func() { Events = nil }()
+	RegisterBuilder("dice", newDice)
+
+	game := newDice("tabs", WithSeed(42))
+	game.Start()
+	defer game.Stop()
+	// two tabs behind the same IP
+	tab1, tab2 := NewFakeConn("1.1.1.1"), NewFakeConn("1.1.1.1")
+	game.Cmd <- &Command{PlayerId: "a", Ws: tab1, Type: cmdJoin}
+	game.Cmd <- &Command{PlayerId: "a", Ws: tab2, Type: cmdJoin}
+	game.Cmd <- &Command{PlayerId: "b", Ws: NewFakeConn("2.2.2.2"), Type: cmdJoin}
+	game.Cmd <- &Command{PlayerId: "a", Ws: tab1, Type: cmdDisconnect}
+	game.Idle()
+	if !game.IsHost("a") {
+		t.Fatal("Expected a to still be host with a tab open")
+	}
+
+	events, err := store.Events("tabs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := Replay("tabs", events, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := replayed.Player("a"); p == nil || !p.Connected || !replayed.IsHost("a") {
+		t.Error("Expected the replay to keep a's other tab", p)
+	}
+}
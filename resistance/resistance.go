@@ -2,23 +2,49 @@ package resistance
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jakecoffman/wg"
 	"log"
-	"math/rand"
-	"runtime/debug"
 	"sort"
 	"strconv"
 	"time"
 )
 
+// Name is the game type, used to restore saved games
+const Name = "resistance"
+
+// spectators see the game late so they can't tell anyone what just happened
+const spectatorDelay = 30 * time.Second
+
+func init() {
+	wg.RegisterRestorer(Name, Restore)
+	wg.RegisterBuilder(Name, create)
+	wg.RegisterResults(Name)
+	wg.RegisterMatchmaking(Name, wg.Matchmaking{Min: 5, Max: 10, Bots: true})
+	wg.RegisterCatalog(Name, wg.Catalog{
+		Commands: map[string]interface{}{
+			cmdName:        "",
+			cmdReady:       nil,
+			cmdAddBot:      nil,
+			cmdRemoveBot:   nil,
+			cmdStart:       nil,
+			cmdAssign:      []int{},
+			cmdVoteTeam:    false,
+			cmdVoteMission: false,
+		},
+		Messages: map[string]interface{}{
+			"all": UpdateMsg{},
+		},
+	})
+}
+
 type Resist struct {
 	*wg.Game
 
-	Players      []*Player
-	playerCursor int
-	Leader       int // Leader is the position of the leader in the Players list
+	Players []*Player
+	Leader  int // Leader is the position of the leader in the Players list
 
 	State          string
 	Missions       []*Mission
@@ -28,13 +54,8 @@ type Resist struct {
 }
 
 type Player struct {
-	ws        wg.Connector
-	Uuid      string `json:"-"`
-	Id        int
-	Name      string
-	Connected bool
-	Ip        string `json:"-"`
-	IsSpy     bool   `json:"-"`
+	*wg.Player
+	IsSpy     bool `json:"-"`
 	IsBot     bool
 	IsReady   bool
 	IsLeader  bool
@@ -79,17 +100,108 @@ func NewMissions(slots []int) []*Mission {
 	return missions
 }
 
-func NewGame(id string) *wg.Game {
+func NewGame(id string, opts ...wg.Option) *wg.Game {
+	game := create(id, opts...)
+	game.Start()
+	return game
+}
+
+func create(id string, opts ...wg.Option) *wg.Game {
 	g := &Resist{
-		Players:      []*Player{},
-		playerCursor: 1,
+		Players: []*Player{},
 	}
-	g.Game = wg.NewGame(g, id)
-	go g.run()
+	g.Game = wg.NewGame(g, id, opts...)
+	g.Type = Name
+	g.SpectatorDelay = spectatorDelay
 	g.reset()
 	return g.Game
 }
 
+// state is everything in Resist that needs saving, the public structs hide secrets from JSON
+type state struct {
+	Players        []*playerState
+	Leader         int
+	State          string
+	Missions       []*missionState
+	CurrentMission int
+	History        []*History
+	NumFailed      int
+}
+
+type playerState struct {
+	Player
+	Uuid      string
+	IsSpy     bool
+	Suspicion int
+}
+
+type missionState struct {
+	Mission
+	SuccessVotes map[int]bool
+}
+
+func (g *Resist) MarshalState() (json.RawMessage, error) {
+	s := &state{
+		Leader:         g.Leader,
+		State:          g.State,
+		CurrentMission: g.CurrentMission,
+		History:        g.History,
+		NumFailed:      g.NumFailed,
+	}
+	for _, p := range g.Players {
+		s.Players = append(s.Players, &playerState{Player: *p, Uuid: p.Uuid, IsSpy: p.IsSpy, Suspicion: p.suspicion})
+	}
+	for _, m := range g.Missions {
+		s.Missions = append(s.Missions, &missionState{Mission: *m, SuccessVotes: m.successVotes})
+	}
+	return json.Marshal(s)
+}
+
+// Restore brings a saved game back, everyone starts out disconnected
+func Restore(snap *wg.Snapshot) (*wg.Game, error) {
+	var s state
+	if err := json.Unmarshal(snap.State, &s); err != nil {
+		return nil, err
+	}
+	g := &Resist{
+		Players:        []*Player{},
+		Leader:         s.Leader,
+		State:          s.State,
+		Missions:       []*Mission{},
+		CurrentMission: s.CurrentMission,
+		History:        s.History,
+		NumFailed:      s.NumFailed,
+	}
+	for _, ps := range s.Players {
+		p := ps.Player
+		if p.Player == nil {
+			p.Player = &wg.Player{}
+		}
+		p.Uuid = ps.Uuid
+		p.IsSpy = ps.IsSpy
+		p.suspicion = ps.Suspicion
+		p.Connected = false
+		g.Players = append(g.Players, &p)
+	}
+	for _, ms := range s.Missions {
+		m := ms.Mission
+		m.successVotes = ms.SuccessVotes
+		if m.successVotes == nil {
+			m.successVotes = map[int]bool{}
+		}
+		g.Missions = append(g.Missions, &m)
+	}
+	if g.History == nil {
+		g.History = []*History{}
+	}
+	g.Game = wg.RestoreGame(g, snap)
+	g.SpectatorDelay = spectatorDelay
+	g.botTurn()
+	g.timers()
+	g.Start()
+	return g.Game, nil
+}
+
 func (g *Resist) reset() {
 	g.State = stateLobby
 	g.History = []*History{}
@@ -125,7 +237,7 @@ const (
 	cmdStop       = "stop"
 	cmdName       = "name"
 
-	// anyone can do these things
+	// only the host can do these things
 	cmdAddBot    = "addbot"
 	cmdRemoveBot = "removebot"
 	cmdStart     = "start"
@@ -138,60 +250,99 @@ const (
 	cmdReady       = "ready" // make a new game, or start current game
 )
 
-func (g *Resist) run() {
-	var cmd *wg.Command
-
-	defer func() {
-		if r := recover(); r != nil {
-			log.Println("Game crashed", r)
-			log.Printf("State: %#v\n", g)
-			log.Println("Last command received:", cmd)
-			debug.PrintStack()
-		}
-	}()
+func (g *Resist) Roster() []*wg.Player {
+	var players []*wg.Player
+	for _, p := range g.Players {
+		players = append(players, p.Player)
+	}
+	return players
+}
 
+func (g *Resist) HandleCommand(cmd *wg.Command) bool {
 	var update bool
-	for {
-		// handle the case where a bot is now a leader
-		if g.State == stateTeambuilding && g.Players[g.Leader].IsBot {
-			g.botLeader()
-		}
-		cmd = <-g.Cmd
-
-		switch cmd.Type {
-		case cmdJoin:
-			update = g.handleJoin(cmd)
-		case cmdLeave:
-			update = g.handleLeave(cmd)
-		case cmdDisconnect:
-			update = g.handleDisconnect(cmd)
-		case cmdStop:
-			return
-		case cmdAddBot:
-			update = g.handleAddBot(cmd)
-		case cmdRemoveBot:
-			update = g.handleRemoveBot(cmd)
-		case cmdStart:
-			update = g.handleStart(cmd)
-		case cmdAssign: // leader sent his chosen assignment
-			update = g.handleAssignTeam(cmd)
-		case cmdVoteTeam:
-			update = g.handleVote(cmd)
-		case cmdVoteMission:
-			update = g.handleMission(cmd)
-		case cmdReady:
-			update = g.handleReady(cmd)
-		case cmdName:
-			update = g.handleName(cmd)
-		default:
-			log.Println("Unknown message:", cmd.Type)
+	switch cmd.Type {
+	case cmdAddBot:
+		update = g.handleAddBot(cmd)
+	case cmdRemoveBot:
+		update = g.handleRemoveBot(cmd)
+	case cmdStart:
+		update = g.handleStart(cmd)
+	case cmdAssign: // leader sent his chosen assignment
+		update = g.handleAssignTeam(cmd)
+	case cmdVoteTeam:
+		update = g.handleVote(cmd)
+	case cmdVoteMission:
+		update = g.handleMission(cmd)
+	case cmdReady:
+		update = g.handleReady(cmd)
+	case cmdName:
+		update = g.handleName(cmd)
+	default:
+		log.Println("Unknown message:", cmd.Type)
+		return false
+	}
+	if g.botTurn() {
+		update = true
+	}
+	g.timers()
+	return update
+}
+
+// botTurn has a bot that's leading pick its team, however the game got to it being their turn
+func (g *Resist) botTurn() bool {
+	if g.State != stateTeambuilding || g.Leader >= len(g.Players) || !g.Players[g.Leader].IsBot {
+		return false
+	}
+	g.Broadcast()
+	g.botLeader()
+	return true
+}
+
+// Timers give the leader a couple of minutes to pick a team
+func (g *Resist) Timers() map[string]int {
+	return map[string]int{stateTeambuilding: 120}
+}
+
+// Timeout picks a random team for a leader that took too long
+func (g *Resist) Timeout(phase string) bool {
+	if phase != stateTeambuilding || g.State != stateTeambuilding {
+		return false
+	}
+	leader := g.Players[g.Leader]
+	team, _ := json.Marshal(g.Rand.Perm(len(g.Players))[:g.Missions[g.CurrentMission].Slots])
+	leader.SendMsg("Time ran out so a random team was picked")
+	update := g.handleAssignTeam(&wg.Command{PlayerId: leader.Uuid, Version: g.Version, Data: team})
+	g.timers()
+	return update
+}
+
+// timers arms the timer for the phase the game is in
+func (g *Resist) timers() {
+	if g.State == stateTeambuilding && !g.Players[g.Leader].IsBot {
+		g.Arm(stateTeambuilding)
+	} else {
+		g.Disarm()
+	}
+}
+
+// finish reports the game to wg, everyone on the winning side won
+func (g *Resist) finish() {
+	spies := g.State == stateSpywin
+	var results []wg.Result
+	for _, p := range g.Players {
+		if p.IsBot {
 			continue
 		}
-		if update {
-			g.sendEveryoneEverything()
-			g.Updated = time.Now()
+		r := wg.Result{PlayerId: p.Uuid, Won: p.IsSpy == spies, Role: "resistance"}
+		if p.IsSpy {
+			r.Role = "spy"
+		}
+		if r.Won {
+			r.Score = 1
 		}
+		results = append(results, r)
 	}
+	g.Finish(results)
 }
 
 func (g *Resist) botLeader() {
@@ -200,7 +351,7 @@ func (g *Resist) botLeader() {
 		// assign one spy with lowest suspicion, then pick from low suspicion
 		var spies []int
 		var resistance []int
-		for _, id := range rand.Perm(len(g.Players)) {
+		for _, id := range g.Rand.Perm(len(g.Players)) {
 			if g.Players[id].IsSpy {
 				spies = append(spies, id)
 			} else {
@@ -219,7 +370,7 @@ func (g *Resist) botLeader() {
 		}
 	} else {
 		// bot isn't spy, assign from the lowest suspicion first
-		ordered := rand.Perm(len(g.Players))
+		ordered := g.Rand.Perm(len(g.Players))
 		sort.Slice(ordered, func(i, j int) bool {
 			return g.Players[ordered[i]].suspicion < g.Players[ordered[j]].suspicion
 		})
@@ -240,7 +391,6 @@ func (g *Resist) botLeader() {
 		g.Players[i].OnMission = true
 	}
 	g.State = stateTeamvoting
-	g.sendEveryoneEverything()
 }
 
 type UpdateMsg struct {
@@ -255,40 +405,45 @@ type secret struct {
 	IsReady, IsLeader, OnMission bool
 }
 
-func (g *Resist) sendEveryoneEverything() {
+func (g *Resist) Capacity() int {
+	return 10
+}
+
+func (g *Resist) InLobby() bool {
+	return g.State == stateLobby
+}
+
+func (g *Resist) HostCommands() []string {
+	return []string{cmdAddBot, cmdRemoveBot, cmdStart}
+}
+
+func (g *Resist) spies() []int {
 	var spies []int
 	for i, p := range g.Players {
 		if p.IsSpy {
 			spies = append(spies, i)
 		}
 	}
-	for _, p := range g.Players {
-		if p.ws != nil {
-			msg := &UpdateMsg{Type: "all", Update: g}
-			msg.You = &secret{Id: p.Id, IsReady: p.IsReady, IsLeader: p.IsLeader, OnMission: p.OnMission}
-			if g.State == stateResistanceWin || g.State == stateSpywin || p.IsSpy {
-				msg.You.Spies = spies
-			}
-			p.ws.Send(msg)
-		}
-	}
+	return spies
 }
 
-type MsgMsg struct {
-	Type string
-	Msg  string
-}
-
-func sendMsg(c wg.Connector, msg string) {
-	c.Send(&MsgMsg{Type: "msg", Msg: msg})
+func (g *Resist) ViewFor(player *wg.Player) interface{} {
+	p, _ := Find(g.Players, player.Uuid)
+	msg := &UpdateMsg{Type: "all", Update: g}
+	msg.You = &secret{Id: p.Id, IsReady: p.IsReady, IsLeader: p.IsLeader, OnMission: p.OnMission}
+	if g.State == stateResistanceWin || g.State == stateSpywin || p.IsSpy {
+		msg.You.Spies = g.spies()
+	}
+	return msg
 }
 
-func (g *Resist) sendMsgAll(msg string) {
-	for _, p := range g.Players {
-		if p.ws != nil {
-			p.ws.Send(&MsgMsg{Type: "msg", Msg: msg})
-		}
+// SpectatorView is what a player would see without any secrets, spies are revealed when the game ends
+func (g *Resist) SpectatorView() interface{} {
+	msg := &UpdateMsg{Type: "all", Update: g, You: &secret{}}
+	if g.State == stateResistanceWin || g.State == stateSpywin {
+		msg.You.Spies = g.spies()
 	}
+	return msg
 }
 
 func (g *Resist) resetReadies() {
@@ -297,48 +452,26 @@ func (g *Resist) resetReadies() {
 	}
 }
 
-func (g *Resist) handleJoin(cmd *wg.Command) bool {
-	player, i := Find(g.Players, cmd.PlayerId)
+func (g *Resist) OnJoin(p *wg.Player) error {
+	_, i := Find(g.Players, p.Uuid)
 	if i == -1 {
 		// player was not here before
 		if g.State != stateLobby {
-			sendMsg(cmd.Ws, "Can't join game in progress")
-			return false
+			return errors.New("Can't join game in progress")
 		}
 		if len(g.Players) >= 10 {
 			// can't have more than 10 players
-			sendMsg(cmd.Ws, "Can't have more than 10 players")
-			return false
-		}
-		player = &Player{Uuid: cmd.PlayerId, Id: g.playerCursor}
-		g.Players = append(g.Players, player)
-		g.playerCursor += 1
-	}
-	player.ws = cmd.Ws
-	player.Connected = true
-	player.Ip = player.ws.Ip()
-	return true
-}
-
-func (g *Resist) handleLeave(cmd *wg.Command) bool {
-	for i, player := range g.Players {
-		if player.Uuid == cmd.PlayerId {
-			g.Players = append(g.Players[0:i], g.Players[i+1:]...)
-			return true
+			return errors.New("Can't have more than 10 players")
 		}
+		g.Players = append(g.Players, &Player{Player: p})
 	}
-	return false
+	return nil
 }
 
-func (g *Resist) handleDisconnect(cmd *wg.Command) bool {
-	player, i := Find(g.Players, cmd.PlayerId)
-	if i == -1 {
-		log.Println("Couldn't find player", cmd.PlayerId)
-		return false
-	}
-	player.ws = nil
-	player.Connected = false
-	return true
+func (g *Resist) OnLeave(p *wg.Player) {
+	_, i := Find(g.Players, p.Uuid)
+	g.Players = append(g.Players[0:i], g.Players[i+1:]...)
+	g.botTurn()
 }
 
 func (g *Resist) handleReady(cmd *wg.Command) bool {
@@ -367,12 +500,12 @@ func (g *Resist) handleReady(cmd *wg.Command) bool {
 
 func (g *Resist) handleStart(cmd *wg.Command) bool {
 	if g.State != stateLobby {
-		sendMsg(cmd.Ws, "Illegal state")
+		cmd.SendMsg("Illegal state")
 		return false
 	}
 
 	if len(g.Players) < 5 || len(g.Players) > 10 {
-		sendMsg(cmd.Ws, "Need 5-10 players to start the game")
+		cmd.SendMsg("Need 5-10 players to start the game")
 		return false
 	}
 
@@ -383,7 +516,7 @@ func (g *Resist) handleStart(cmd *wg.Command) bool {
 	// remove unconnected players and reorder them, leader always starts in position 1
 	{
 		var newPlayers []*Player
-		walk := rand.Perm(len(g.Players))
+		walk := g.Rand.Perm(len(g.Players))
 		for _, i := range walk {
 			if !g.Players[i].IsBot && !g.Players[i].Connected {
 				continue
@@ -397,7 +530,7 @@ func (g *Resist) handleStart(cmd *wg.Command) bool {
 	// assign secret roles to players (based on # of players)
 	{
 		numSpies := map[int]int{5: 2, 6: 2, 7: 3, 8: 3, 9: 3, 10: 4}[len(g.Players)]
-		walk := rand.Perm(len(g.Players))
+		walk := g.Rand.Perm(len(g.Players))
 		for i, j := range walk {
 			if i >= numSpies {
 				break
@@ -420,14 +553,26 @@ func (g *Resist) handleStart(cmd *wg.Command) bool {
 	return true
 }
 
+// StartMatch fills the seats matchmaking couldn't with bots and starts the game
+func (g *Resist) StartMatch(bots int) error {
+	for i := 0; i < bots; i++ {
+		if !g.HandleCommand(&wg.Command{Type: cmdAddBot}) {
+			return errors.New("Can't have more than 10 players")
+		}
+	}
+	if !g.HandleCommand(&wg.Command{Type: cmdStart}) {
+		return errors.New("Need 5-10 players to start the game")
+	}
+	return nil
+}
+
 func (g *Resist) handleAddBot(cmd *wg.Command) bool {
 	if len(g.Players) >= 10 {
-		sendMsg(cmd.Ws, "Can't have more than 10 players")
+		cmd.SendMsg("Can't have more than 10 players")
 		return false
 	}
-	player := &Player{Uuid: uuid.New().String(), Id: g.playerCursor, IsBot: true}
+	player := &Player{Player: g.NewPlayer(uuid.New().String()), IsBot: true}
 	g.Players = append(g.Players, player)
-	g.playerCursor += 1
 	return true
 }
 
@@ -438,7 +583,7 @@ func (g *Resist) handleRemoveBot(cmd *wg.Command) bool {
 			return true
 		}
 	}
-	sendMsg(cmd.Ws, "These aren't the bot's you're looking for...")
+	cmd.SendMsg("These aren't the bot's you're looking for...")
 	return false
 }
 
@@ -451,12 +596,12 @@ func (g *Resist) handleAssignTeam(cmd *wg.Command) bool {
 	err := json.Unmarshal(cmd.Data, &assignment)
 	if err != nil {
 		log.Println(err)
-		sendMsg(cmd.Ws, "Got invalid data for team assignment")
+		cmd.SendMsg("Got invalid data for team assignment")
 		return false
 	}
 	thisMission := g.Missions[g.CurrentMission]
 	if len(assignment) != thisMission.Slots {
-		sendMsg(cmd.Ws, fmt.Sprint("Number of assignments needs to be ", thisMission.Slots, " but got ", len(assignment)))
+		cmd.SendMsg(fmt.Sprint("Number of assignments needs to be ", thisMission.Slots, " but got ", len(assignment)))
 		return false
 	}
 	thisMission.Assignments = assignment
@@ -479,7 +624,7 @@ func (g *Resist) handleVote(cmd *wg.Command) bool {
 	err := json.Unmarshal(cmd.Data, &vote)
 	if err != nil {
 		log.Println(err)
-		sendMsg(cmd.Ws, "Got invalid data for team assignment")
+		cmd.SendMsg("Got invalid data for team assignment")
 		return false
 	}
 	thisMission.Votes[i] = vote
@@ -488,7 +633,7 @@ func (g *Resist) handleVote(cmd *wg.Command) bool {
 	for i, player := range g.Players {
 		if player.IsBot {
 			if g.NumFailed < 4 {
-				thisMission.Votes[i] = rand.Intn(2) == 1
+				thisMission.Votes[i] = g.Rand.Intn(2) == 1
 			} else {
 				thisMission.Votes[i] = true
 			}
@@ -516,21 +661,19 @@ func (g *Resist) handleVote(cmd *wg.Command) bool {
 		for _, i := range g.Missions[g.CurrentMission].Assignments {
 			if g.Players[i].IsBot {
 				p := g.Players[i]
-				// have to do this in a goroutine since it blocks
-				go func(bot *Player, v int) {
-					g.Cmd <- &wg.Command{
-						PlayerId: bot.Uuid,
-						Type:     cmdVoteMission,
-						Data:     []byte(strconv.FormatBool(!bot.IsSpy)),
-						Version:  v,
-					}
-				}(p, g.Version)
+				g.Inject(&wg.Command{
+					PlayerId: p.Uuid,
+					Type:     cmdVoteMission,
+					Data:     []byte(strconv.FormatBool(!p.IsSpy)),
+					Version:  g.Version,
+				})
 			}
 		}
 	} else {
 		g.NumFailed += 1
 		if g.NumFailed == 5 {
 			g.State = stateSpywin
+			g.finish()
 		} else {
 			g.State = stateTeambuilding
 			g.Players[g.Leader].IsLeader = false
@@ -560,13 +703,13 @@ func (g *Resist) handleMission(cmd *wg.Command) bool {
 	err := json.Unmarshal(cmd.Data, &vote)
 	if err != nil {
 		log.Println(err)
-		sendMsg(p.ws, "Got invalid data for team assignment")
+		p.SendMsg("Got invalid data for team assignment")
 		return false
 	}
 
 	thisMission := g.Missions[g.CurrentMission]
 	if !p.IsSpy && vote == false {
-		sendMsg(p.ws, "Resistance cannot vote to fail missions")
+		p.SendMsg("Resistance cannot vote to fail missions")
 		return false
 	}
 	thisMission.successVotes[i] = vote
@@ -613,11 +756,13 @@ func (g *Resist) handleMission(cmd *wg.Command) bool {
 	if succeeds >= 3 {
 		g.State = stateResistanceWin
 		g.resetReadies()
+		g.finish()
 		return true
 	}
 	if fails >= 3 {
 		g.State = stateSpywin
 		g.resetReadies()
+		g.finish()
 		return true
 	}
 
@@ -637,12 +782,12 @@ func (g *Resist) handleMission(cmd *wg.Command) bool {
 		for _, i := range thisMission.Assignments {
 			g.Players[i].suspicion -= 3
 		}
-		g.sendMsgAll("Mission successful! 🙌")
+		g.SendMsgAll("Mission successful! 🙌")
 	} else {
 		for _, i := range thisMission.Assignments {
 			g.Players[i].suspicion++
 		}
-		g.sendMsgAll("Mission failed! 💥")
+		g.SendMsgAll("Mission failed! 💥")
 	}
 	return true
 }
@@ -650,24 +795,16 @@ func (g *Resist) handleMission(cmd *wg.Command) bool {
 func (g *Resist) handleName(cmd *wg.Command) bool {
 	p, _ := Find(g.Players, cmd.PlayerId)
 	if g.State != stateLobby && p.Name != "" {
-		sendMsg(p.ws, "Wait for the lobby to change your name again")
+		p.SendMsg("Wait for the lobby to change your name again")
 		return false
 	}
 
-	var name string
-	err := json.Unmarshal(cmd.Data, &name)
-	if err != nil {
+	if err := p.Rename(cmd.Data); err != nil {
 		log.Println(err)
-		sendMsg(p.ws, "Got invalid data for name")
+		p.SendMsg("Got invalid data for name")
 		return false
 	}
 
-	if len(name) > 8 {
-		p.Name = name[0:8]
-	} else {
-		p.Name = name
-	}
-
 	return true
 }
 
@@ -1,81 +1,155 @@
 package resistance
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/jakecoffman/wg"
-	"log"
 	"math/rand"
 	"testing"
-	"time"
+
+	"github.com/jakecoffman/wg"
+	"github.com/jakecoffman/wg/wgtest"
 )
 
 func TestResistance(t *testing.T) {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	rand.Seed(time.Now().UnixNano())
+	// pinned so a failure plays out the same way every run, wgtest seeds the game
+	r := rand.New(rand.NewSource(1))
 
-	const gameId = "0"
-	const player1 = "1"
-	p1Conn := wg.NewFakeConn(player1)
+	game := wgtest.New(t, NewGame)
+	resistance := game.Rules.(*Resist)
 
-	game := NewGame(gameId)
-	resistance := game.Class.(*Resist)
-
-	game.Cmd <- &wg.Command{player1, p1Conn, cmdJoin, resistance.Version, nil}
-	game.Cmd <- &wg.Command{player1, p1Conn, cmdAddBot, resistance.Version, nil}
-	game.Cmd <- &wg.Command{player1, p1Conn, cmdAddBot, resistance.Version, nil}
-	game.Cmd <- &wg.Command{player1, p1Conn, cmdAddBot, resistance.Version, nil}
-	game.Cmd <- &wg.Command{player1, p1Conn, cmdAddBot, resistance.Version, nil}
-	game.Cmd <- &wg.Command{player1, p1Conn, cmdStart, resistance.Version, nil}
-
-	false := []byte("false")
-	true := []byte("true")
+	p1 := game.Join("1")
+	for i := 0; i < 4; i++ {
+		p1.Do(cmdAddBot, nil)
+	}
+	p1.Do(cmdStart, nil)
 
 	var spies, resist int
-	for spies + resist < 1000 {
-	drain:
-		for {
-			select {
-			case <-p1Conn.Msgs:
-			default:
-				break drain
-			}
-		}
-
-		// let the resistance goroutine go, probably should improve this with locking
-		time.Sleep(1*time.Millisecond)
-
+	for spies+resist < 1000 {
+		p1.Clear()
+		// the bots' mission votes are handled before Do returns, so the state is always the player's move
 		switch resistance.State {
 		case stateTeambuilding:
-			assignment := rand.Perm(5)[:resistance.Missions[resistance.CurrentMission].Slots]
-			b, _ := json.Marshal(assignment)
-			game.Cmd <- &wg.Command{player1, p1Conn, cmdAssign, game.Version, b}
+			p1.Do(cmdAssign, r.Perm(5)[:resistance.Missions[resistance.CurrentMission].Slots])
 		case stateTeamvoting:
-			if rand.Intn(2) == 0 {
-				game.Cmd <- &wg.Command{player1, p1Conn, cmdVoteTeam, game.Version, false}
-			} else {
-				game.Cmd <- &wg.Command{player1, p1Conn, cmdVoteTeam, game.Version, true}
-			}
+			p1.Do(cmdVoteTeam, r.Intn(2) == 0)
 		case stateMission:
-			if rand.Intn(2) == 0 {
-				game.Cmd <- &wg.Command{player1, p1Conn, cmdVoteMission, game.Version, false}
-			} else {
-				game.Cmd <- &wg.Command{player1, p1Conn, cmdVoteMission, game.Version, true}
-			}
+			p1.Do(cmdVoteMission, r.Intn(2) == 0)
 		case stateSpywin:
 			spies++
-			fmt.Println(spies + resist)
-			game.Cmd <- &wg.Command{player1, p1Conn, cmdReady, game.Version, nil}
+			p1.Do(cmdReady, nil)
 		case stateResistanceWin:
 			resist++
-			fmt.Println(spies + resist)
-			game.Cmd <- &wg.Command{player1, p1Conn, cmdReady, game.Version, nil}
+			p1.Do(cmdReady, nil)
 		case stateLobby:
-			game.Cmd <- &wg.Command{player1, p1Conn, cmdStart, game.Version, nil}
+			p1.Do(cmdStart, nil)
 		default:
-			log.Fatal("ERROR:", resistance.State)
+			t.Fatal("Unknown state", resistance.State)
 		}
 	}
 
-	fmt.Println("Spies", spies, "Resist", resist)
+	t.Log("Spies", spies, "Resist", resist)
+}
+
+func TestRestore(t *testing.T) {
+	game := wgtest.New(t, NewGame)
+	resistance := game.Rules.(*Resist)
+
+	p1 := game.Join("1")
+	for i := 0; i < 4; i++ {
+		p1.Do(cmdAddBot, nil)
+	}
+	p1.Do(cmdStart, nil)
+	game.Stop()
+
+	state, err := resistance.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(&wg.Snapshot{Id: "0", Type: Name, State: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Stop()
+	again := restored.Rules.(*Resist)
+
+	if again.State != resistance.State || len(again.Players) != len(resistance.Players) || len(again.Missions) != 5 {
+		t.Fatal("Game didn't survive the round trip", again)
+	}
+	for i, p := range resistance.Players {
+		q := again.Players[i]
+		if p.Uuid != q.Uuid || p.IsSpy != q.IsSpy || p.IsBot != q.IsBot || q.Connected {
+			t.Error("Player didn't survive the round trip", p, q)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// not started, so the test can call into the game without racing the command loop
+	resistance := &Resist{Players: []*Player{}}
+	resistance.Game = wg.NewGame(resistance, "0")
+	resistance.reset()
+	for i := 0; i < 5; i++ {
+		resistance.Players = append(resistance.Players, &Player{Player: resistance.NewPlayer(fmt.Sprint(i)), IsBot: true})
+	}
+	resistance.handleStart(&wg.Command{})
+	resistance.Players[resistance.Leader].IsBot = false
+
+	if !resistance.Timeout(stateTeambuilding) || resistance.State != stateTeamvoting {
+		t.Fatal("Expected a team to be picked", resistance.State)
+	}
+	if len(resistance.Missions[0].Assignments) != resistance.Missions[0].Slots {
+		t.Error("Expected the mission to be filled", resistance.Missions[0].Assignments)
+	}
+	if resistance.Timeout(stateTeambuilding) {
+		t.Error("Team was already picked")
+	}
+}
+
+func TestStartMatch(t *testing.T) {
+	resistance := &Resist{Players: []*Player{}}
+	resistance.Game = wg.NewGame(resistance, "0")
+	resistance.reset()
+	for i := 0; i < 2; i++ {
+		p := &Player{Player: resistance.NewPlayer(fmt.Sprint(i))}
+		p.Connected = true
+		resistance.Players = append(resistance.Players, p)
+	}
+	if err := resistance.StartMatch(2); err == nil {
+		t.Error("Expected 4 players to be too few")
+	}
+	if err := resistance.StartMatch(1); err != nil || resistance.State == stateLobby || len(resistance.Players) != 5 {
+		t.Fatal("Expected bots to make up the numbers", err, resistance.State, len(resistance.Players))
+	}
+}
+
+func TestBotLeader(t *testing.T) {
+	// started without going through HandleCommand, so the bot leader hasn't had its turn
+	resistance := &Resist{Players: []*Player{}}
+	resistance.Game = wg.NewGame(resistance, "0")
+	resistance.reset()
+	for i := 0; i < 5; i++ {
+		resistance.Players = append(resistance.Players, &Player{Player: resistance.NewPlayer(fmt.Sprint(i)), IsBot: true})
+	}
+	resistance.handleStart(&wg.Command{})
+	if resistance.State != stateTeambuilding {
+		t.Fatal("Expected the bot to be waiting to lead", resistance.State)
+	}
+	state, err := resistance.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(&wg.Snapshot{Id: "0", Type: Name, State: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Stop()
+	restored.Idle()
+	if again := restored.Rules.(*Resist); again.State != stateTeamvoting {
+		t.Error("Expected the restored bot leader to pick a team", again.State)
+	}
+
+	// a command that changes nothing still gives the bot its turn
+	resistance.Players[0].Name = "bot"
+	if !resistance.HandleCommand(&wg.Command{PlayerId: "0", Type: cmdName, Data: []byte("not json")}) || resistance.State != stateTeamvoting {
+		t.Error("Expected the bot leader to pick a team", resistance.State)
+	}
 }
@@ -0,0 +1,154 @@
+package wg
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// HostOnly is implemented by games that have commands only the host may send, like starting the game
+type HostOnly interface {
+	HostCommands() []string
+}
+
+// KickedMsg tells a player they were removed from the game
+type KickedMsg struct {
+	Type   string
+	Banned bool
+}
+
+func (g *Game) isHostCommand(cmdType string) bool {
+	h, ok := g.Rules.(HostOnly)
+	if !ok {
+		return false
+	}
+	for _, t := range h.HostCommands() {
+		if t == cmdType {
+			return true
+		}
+	}
+	return false
+}
+
+// setHost makes the player the host, nil means no one is
+func (g *Game) setHost(p *Player) {
+	if p == nil {
+		g.host = ""
+		g.Host = 0
+		return
+	}
+	g.host = p.Uuid
+	g.Host = p.Id
+}
+
+// IsHost reports whether the player with this cookie is the host
+func (g *Game) IsHost(uuid string) bool {
+	return g.host != "" && g.host == uuid
+}
+
+// admit checks if a new player is allowed in, returning why not
+func (g *Game) admit(cmd *Command) string {
+	if reason := g.allowed(cmd); reason != "" {
+		return reason
+	}
+	if !tournaments.seated(g.Id, cmd.PlayerId) {
+		return "This table is for the tournament's players"
+	}
+	return ""
+}
+
+// allowed checks bans, the lock and the password, for players and spectators alike
+func (g *Game) allowed(cmd *Command) string {
+	if g.banned[cmd.PlayerId] || (cmd.Ws.Ip() != "" && g.banned[cmd.Ws.Ip()]) {
+		return "You are banned from this game"
+	}
+	if g.Locked {
+		return "This game is locked"
+	}
+	if g.password != "" {
+		var join JoinRequest
+		if err := json.Unmarshal(cmd.Data, &join); err != nil || join.Password != g.password {
+			return "Wrong password"
+		}
+	}
+	return ""
+}
+
+// hostCommand handles the commands that manage the room, only the host can send them
+func (g *Game) hostCommand(cmd *Command) bool {
+	if !g.IsHost(cmd.PlayerId) {
+		cmd.SendError(ErrNotHost, "Only the host can do that")
+		return false
+	}
+
+	if cmd.Type == cmdTimers {
+		return g.setTimers(cmd)
+	}
+	if cmd.Type == cmdLock {
+		var locked bool
+		if err := json.Unmarshal(cmd.Data, &locked); err != nil {
+			cmd.SendError(ErrBadData, "Got invalid data for lock")
+			return false
+		}
+		g.Locked = locked
+		return true
+	}
+
+	var id int
+	if err := json.Unmarshal(cmd.Data, &id); err != nil {
+		cmd.SendError(ErrBadData, "Got invalid data for player")
+		return false
+	}
+	var target *Player
+	for _, p := range g.Rules.Roster() {
+		if p.Id == id {
+			target = p
+		}
+	}
+	if target == nil {
+		cmd.SendError(ErrNotFound, "Player not found")
+		return false
+	}
+
+	switch cmd.Type {
+	case cmdHost:
+		g.setHost(target)
+	case cmdKick, cmdBan:
+		if target.Uuid == cmd.PlayerId {
+			cmd.SendMsg("You can't kick yourself")
+			return false
+		}
+		banned := cmd.Type == cmdBan
+		if banned {
+			if g.banned == nil {
+				g.banned = map[string]bool{}
+			}
+			g.banned[target.Uuid] = true
+			if target.Ip != "" {
+				g.banned[target.Ip] = true
+			}
+		}
+		log.Println("Player", target.Id, "was removed from game", g.Id, "banned:", banned)
+		target.Send(&KickedMsg{Type: "kicked", Banned: banned})
+		g.Rules.OnLeave(target)
+		g.forgetViews(target)
+		target.dropConn(nil)
+	}
+	return true
+}
+
+// passHost gives the host to the next connected player when the host leaves or disconnects
+func (g *Game) passHost() {
+	host := g.Player(g.host)
+	if host != nil && host.Connected {
+		return
+	}
+	for _, p := range g.Rules.Roster() {
+		if p.Connected {
+			g.setHost(p)
+			return
+		}
+	}
+	if host == nil {
+		g.setHost(nil)
+	}
+}
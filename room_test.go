@@ -0,0 +1,76 @@
+package wg
+
+import "testing"
+
+type hostRules struct {
+	testRules
+}
+
+func (r *hostRules) HostCommands() []string {
+	return []string{"start"}
+}
+
+func TestRoom(t *testing.T) {
+	rules := &hostRules{}
+	game := NewGame(rules, "1")
+	game.password = "secret"
+
+	a := NewFakeConn("1.1.1.1")
+	game.handle(&Command{PlayerId: "a", Ws: a, Type: cmdJoin, Data: []byte(`{"Id":"1","Password":"secret"}`)})
+	if !game.IsHost("a") || game.Host != 1 {
+		t.Fatal("First player should be the host", game.Host)
+	}
+
+	b := NewFakeConn("2.2.2.2")
+	if game.handle(&Command{PlayerId: "b", Ws: b, Type: cmdJoin, Data: []byte(`"1"`)}) || len(rules.players) != 1 {
+		t.Error("Expected wrong password to be refused")
+	}
+	game.handle(&Command{PlayerId: "b", Ws: b, Type: cmdJoin, Data: []byte(`{"Id":"1","Password":"secret"}`)})
+	if len(rules.players) != 2 {
+		t.Fatal("Expected right password to join")
+	}
+	drain(a)
+	drain(b)
+
+	game.handle(&Command{PlayerId: "b", Ws: b, Type: "start"})
+	if len(rules.handled) != 0 || len(drain(b)) != 1 {
+		t.Error("Only the host should be able to start")
+	}
+	game.handle(&Command{PlayerId: "a", Type: "start"})
+	if len(rules.handled) != 1 {
+		t.Error("Host should be able to start")
+	}
+
+	game.handle(&Command{PlayerId: "b", Type: cmdKick, Data: []byte(`1`)})
+	if len(rules.players) != 2 {
+		t.Error("Only the host can kick")
+	}
+	game.handle(&Command{PlayerId: "a", Type: cmdBan, Data: []byte(`2`)})
+	if len(rules.players) != 1 || !(<-b.Msgs).(*KickedMsg).Banned {
+		t.Fatal("Expected b to be banned")
+	}
+	game.handle(&Command{PlayerId: "c", Ws: NewFakeConn("2.2.2.2"), Type: cmdJoin, Data: []byte(`{"Password":"secret"}`)})
+	if len(rules.players) != 1 {
+		t.Error("Ban should cover the IP too")
+	}
+
+	game.handle(&Command{PlayerId: "a", Type: cmdLock, Data: []byte(`true`)})
+	game.handle(&Command{PlayerId: "d", Ws: NewFakeConn("4.4.4.4"), Type: cmdJoin, Data: []byte(`{"Password":"secret"}`)})
+	if len(rules.players) != 1 {
+		t.Error("Locked room shouldn't let anyone in")
+	}
+	if game.describe().Locked != true || game.describe().Password != true {
+		t.Error("Lobby should show the room is locked", game.describe())
+	}
+	game.handle(&Command{PlayerId: "a", Type: cmdLock, Data: []byte(`false`)})
+	game.handle(&Command{PlayerId: "d", Ws: NewFakeConn("4.4.4.4"), Type: cmdJoin, Data: []byte(`{"Password":"secret"}`)})
+
+	game.handle(&Command{PlayerId: "a", Type: cmdDisconnect})
+	if !game.IsHost("d") {
+		t.Error("Host should pass to a connected player")
+	}
+	game.handle(&Command{PlayerId: "d", Type: cmdHost, Data: []byte(`1`)})
+	if !game.IsHost("a") {
+		t.Error("Host should be transferable")
+	}
+}
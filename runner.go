@@ -0,0 +1,281 @@
+package wg
+
+import (
+	"encoding/json"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// Rules is implemented by each game, the runner takes care of everything that isn't game specific
+type Rules interface {
+	// Roster returns everyone in the game, including bots
+	Roster() []*Player
+	// OnJoin is called when a player joins or comes back, return an error to turn them away
+	OnJoin(p *Player) error
+	// OnLeave is called when a player leaves the game for good
+	OnLeave(p *Player)
+	// HandleCommand applies a game command, return true to send everyone the new state
+	HandleCommand(cmd *Command) bool
+	// ViewFor is the state message sent to a player, it should only contain what they are allowed to see
+	ViewFor(p *Player) interface{}
+}
+
+// Start begins processing commands, games call this once they are fully set up
+func (g *Game) Start() {
+	for _, p := range g.Rules.Roster() {
+		if p.Id >= g.playerCursor {
+			g.playerCursor = p.Id + 1
+		}
+	}
+	g.logCreated()
+	g.trackIdle(g.clock.Now())
+	go g.run()
+}
+
+func (g *Game) run() {
+	for {
+		cmd := <-g.Cmd
+		if cmd.Type == cmdStop {
+			log.Println("Stopping game", g.Id)
+			g.stop()
+			return
+		}
+		if cmd.Type == cmdReap {
+			reason := g.reapReason(g.clock.Now())
+			// an admin stopping the room says why, it goes whatever its lifecycle says
+			json.Unmarshal(cmd.Data, &reason)
+			if reason != "" && cmd.PlayerId == "" {
+				g.reap(reason)
+				g.stop()
+				return
+			}
+			continue
+		}
+		if cmd.Type == cmdSync && cmd.PlayerId == "" {
+			g.syncs = append(g.syncs, cmd.Ws)
+			g.synced()
+			continue
+		}
+		commandsTotal.inc(g.Type)
+		g.record(cmd)
+		if g.handle(cmd) {
+			g.Broadcast()
+		}
+		g.Updated = g.clock.Now()
+		g.trackIdle(g.Updated)
+		g.Save()
+		g.publish()
+		if cmd.injected {
+			atomic.AddInt32(&g.injected, -1)
+		}
+		g.synced()
+	}
+}
+
+// synced answers the Idle calls once nothing the game injected is still on its way
+func (g *Game) synced() {
+	if len(g.syncs) == 0 || atomic.LoadInt32(&g.injected) > 0 {
+		return
+	}
+	for _, c := range g.syncs {
+		c.Send(nil)
+	}
+	g.syncs = nil
+}
+
+func (g *Game) stop() {
+	if g.delayed != nil {
+		close(g.delayed)
+	}
+	g.Disarm()
+	close(g.done)
+}
+
+// Idle waits until the game has handled everything sent to it so far, and anything it injected into itself while
+// handling it. It's for tests, false means the game stopped.
+func (g *Game) Idle() bool {
+	conn := &replyConn{reply: make(chan interface{}, 1)}
+	select {
+	case g.Cmd <- &Command{Type: cmdSync, Ws: conn}:
+	case <-g.done:
+		return false
+	}
+	select {
+	case <-conn.reply:
+		return true
+	case <-g.done:
+		return false
+	}
+}
+
+// Stop ends the game's goroutine, it does nothing if the game already stopped
+func (g *Game) Stop() {
+	g.deliver(&Command{Type: cmdStop})
+}
+
+// deliver hands the game a command from a player, it's dropped if the game has stopped
+func (g *Game) deliver(cmd *Command) {
+	select {
+	case g.Cmd <- cmd:
+	case <-g.done:
+	}
+}
+
+// handle processes one command, a crash is logged and the game keeps going
+func (g *Game) handle(cmd *Command) (update bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Game crashed", r)
+			crashesTotal.inc(g.Type)
+			log.Println("Seed:", g.seed, "command seed:", g.cmdSeed)
+			log.Printf("State: %#v\n", g.Rules)
+			log.Println("Last command received:", cmd)
+			debug.PrintStack()
+			update = false
+		}
+	}()
+
+	switch cmd.Type {
+	case cmdJoin:
+		return g.join(cmd)
+	case cmdSpectate:
+		return g.spectate(cmd)
+	case cmdChat:
+		return g.chat(cmd)
+	case cmdAck:
+		return g.ack(cmd)
+	case cmdKick, cmdBan, cmdLock, cmdHost, cmdTimers:
+		return g.hostCommand(cmd)
+	case cmdTimeout, cmdInspect, cmdAnnounce, cmdMatch:
+		return g.serverCommand(cmd)
+	case cmdLeave:
+		if g.unspectate(cmd.PlayerId, cmd.Ws) {
+			return false
+		}
+		update = g.leave(cmd)
+		g.passHost()
+		return update
+	case cmdDisconnect:
+		if g.unspectate(cmd.PlayerId, cmd.Ws) {
+			return false
+		}
+		update = g.disconnect(cmd)
+		g.passHost()
+		return update
+	}
+	if g.StrictVersion && cmd.Version != g.Version {
+		return false
+	}
+	if code, err := g.checkCommand(cmd); err != nil {
+		cmd.SendError(code, err.Error())
+		return false
+	}
+	if g.isHostCommand(cmd.Type) && !g.IsHost(cmd.PlayerId) {
+		cmd.SendError(ErrNotHost, "Only the host can do that")
+		return false
+	}
+	return g.Rules.HandleCommand(cmd)
+}
+
+// Player finds a player in the game by their cookie
+func (g *Game) Player(uuid string) *Player {
+	for _, p := range g.Rules.Roster() {
+		if p.Uuid == uuid {
+			return p
+		}
+	}
+	return nil
+}
+
+// NewPlayer makes a player that isn't connected, like a bot
+func (g *Game) NewPlayer(uuid string) *Player {
+	p := &Player{Uuid: uuid, Id: g.playerCursor}
+	g.playerCursor += 1
+	return p
+}
+
+// Broadcast sends every connected player their view of the game, and spectators theirs
+func (g *Game) Broadcast() {
+	g.TimeLeft = g.timeLeft()
+	for _, p := range g.Rules.Roster() {
+		if len(p.conns) > 0 {
+			g.sendView(p)
+		}
+	}
+	if view, ok := g.Rules.(Spectatable); ok {
+		g.sendSpectators(view.SpectatorView())
+	}
+}
+
+// SendMsgAll shows a message to every connected player
+func (g *Game) SendMsgAll(msg string) {
+	for _, p := range g.Rules.Roster() {
+		p.SendMsg(msg)
+	}
+}
+
+func (g *Game) join(cmd *Command) bool {
+	p := g.Player(cmd.PlayerId)
+	isNew := p == nil
+	if isNew {
+		if reason := g.admit(cmd); reason != "" {
+			sendError(cmd.Ws, ErrRefused, reason)
+			return false
+		}
+		p = &Player{Uuid: cmd.PlayerId, Id: g.playerCursor}
+	}
+	prev := *p
+	// another tab or device adds a connection, it doesn't replace the others
+	p.addConn(cmd.Ws)
+	p.Ip = cmd.Ws.Ip()
+	if err := g.Rules.OnJoin(p); err != nil {
+		sendError(cmd.Ws, ErrRefused, err.Error())
+		if !isNew {
+			*p = prev
+		}
+		return false
+	}
+	if isNew {
+		g.playerCursor += 1
+	}
+	g.passHost()
+	g.sendChatHistory(p)
+	return true
+}
+
+// leave takes the player out of the game, unless they still have it open somewhere else
+func (g *Game) leave(cmd *Command) bool {
+	p := g.Player(cmd.PlayerId)
+	if p == nil {
+		return false
+	}
+	if cmd.Ws != nil {
+		g.forgetView(cmd.Ws)
+		p.dropConn(cmd.Ws)
+		if p.Connected {
+			return false
+		}
+	}
+	g.Rules.OnLeave(p)
+	g.forgetViews(p)
+	p.dropConn(nil)
+	return true
+}
+
+func (g *Game) disconnect(cmd *Command) bool {
+	p := g.Player(cmd.PlayerId)
+	if p == nil {
+		log.Println("Couldn't find player", cmd.PlayerId)
+		return false
+	}
+	// only the last connection to drop disconnects the player
+	if cmd.Ws != nil {
+		g.forgetView(cmd.Ws)
+		p.dropConn(cmd.Ws)
+		return !p.Connected
+	}
+	g.forgetViews(p)
+	p.dropConn(nil)
+	return true
+}
@@ -0,0 +1,226 @@
+package wg
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testRules struct {
+	players []*Player
+	full    bool
+	handled []string
+}
+
+func (r *testRules) Roster() []*Player {
+	return r.players
+}
+
+func (r *testRules) OnJoin(p *Player) error {
+	for _, player := range r.players {
+		if player == p {
+			return nil
+		}
+	}
+	if r.full {
+		return errors.New("Full")
+	}
+	r.players = append(r.players, p)
+	return nil
+}
+
+func (r *testRules) OnLeave(p *Player) {
+	for i, player := range r.players {
+		if player == p {
+			r.players = append(r.players[:i], r.players[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *testRules) HandleCommand(cmd *Command) bool {
+	r.handled = append(r.handled, cmd.Type)
+	return true
+}
+
+func (r *testRules) ViewFor(p *Player) interface{} {
+	return p.Id
+}
+
+func TestRunner(t *testing.T) {
+	rules := &testRules{}
+	game := NewGame(rules, "1")
+	game.StrictVersion = true
+
+	conn1 := NewFakeConn("1")
+	if !game.handle(&Command{PlayerId: "a", Ws: conn1, Type: cmdJoin}) {
+		t.Fatal("Join should update")
+	}
+	conn2 := NewFakeConn("2")
+	game.handle(&Command{PlayerId: "b", Ws: conn2, Type: cmdJoin})
+	if len(rules.players) != 2 || rules.players[0].Id != 1 || rules.players[1].Id != 2 {
+		t.Fatal("Players should have been added with increasing ids", rules.players)
+	}
+
+	rules.full = true
+	conn3 := NewFakeConn("3")
+	if game.handle(&Command{PlayerId: "c", Ws: conn3, Type: cmdJoin}) {
+		t.Error("Join should have been refused")
+	}
+	if msg := (<-conn3.Msgs).(*MsgMsg); msg.Msg != "Full" {
+		t.Error("Expected refusal message got", msg.Msg)
+	}
+
+	game.handle(&Command{PlayerId: "a", Type: cmdDisconnect})
+	if rules.players[0].Connected {
+		t.Error("Player should be disconnected")
+	}
+	game.Broadcast()
+	if len(conn1.Msgs) != 0 || len(conn2.Msgs) != 1 {
+		t.Error("Only connected players should get broadcasts")
+	}
+
+	game.handle(&Command{PlayerId: "b", Type: "play", Version: 5})
+	game.handle(&Command{PlayerId: "b", Type: "play", Version: 0})
+	if len(rules.handled) != 1 {
+		t.Error("Old versions should have been dropped", rules.handled)
+	}
+
+	game.handle(&Command{PlayerId: "a", Type: cmdLeave})
+	if len(rules.players) != 1 || game.Player("a") != nil {
+		t.Error("Player should have left", rules.players)
+	}
+}
+
+type watchedRules struct {
+	testRules
+}
+
+func (r *watchedRules) SpectatorView() interface{} {
+	return "secret free"
+}
+
+func TestSpectate(t *testing.T) {
+	game := NewGame(&testRules{}, "1")
+	conn := NewFakeConn("1")
+	game.handle(&Command{PlayerId: "a", Ws: conn, Type: cmdSpectate})
+	if msg := (<-conn.Msgs).(*MsgMsg); msg.Msg != "This game can't be watched" {
+		t.Error("Expected refusal got", msg.Msg)
+	}
+
+	rules := &watchedRules{}
+	game = NewGame(rules, "2")
+	game.handle(&Command{PlayerId: "a", Ws: conn, Type: cmdSpectate})
+	if msg := <-conn.Msgs; msg != "secret free" {
+		t.Error("Spectator should be sent the spectator view", msg)
+	}
+	if len(rules.players) != 0 {
+		t.Error("Spectators aren't players")
+	}
+	game.Broadcast()
+	if msg := <-conn.Msgs; msg != "secret free" {
+		t.Error("Spectator should get broadcasts", msg)
+	}
+
+	game.SpectatorDelay = 20 * time.Millisecond
+	game.Broadcast()
+	if len(conn.Msgs) != 0 {
+		t.Error("Spectator shouldn't see anything until the delay is up")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if msg := <-conn.Msgs; string(msg.(json.RawMessage)) != `"secret free"` {
+		t.Error("Spectator should get delayed broadcasts", msg)
+	}
+
+	game.handle(&Command{PlayerId: "a", Type: cmdDisconnect})
+	game.Broadcast()
+	time.Sleep(50 * time.Millisecond)
+	if len(conn.Msgs) != 0 {
+		t.Error("Spectator left and shouldn't get messages")
+	}
+}
+
+func TestSpectateTabs(t *testing.T) {
+	game := NewGame(&watchedRules{}, "1")
+	first, second := NewFakeConn("1"), NewFakeConn("1")
+	for _, conn := range []*FakeConn{first, second} {
+		game.handle(&Command{PlayerId: "a", Ws: conn, Type: cmdSpectate})
+		<-conn.Msgs
+	}
+	game.handle(&Command{PlayerId: "a", Ws: first, Type: cmdDisconnect})
+	game.Broadcast()
+	if len(first.Msgs) != 0 {
+		t.Error("Expected the closed tab to stop getting updates")
+	}
+	if msg := <-second.Msgs; msg != "secret free" {
+		t.Error("Expected the other tab to keep watching", msg)
+	}
+}
+
+func TestSpectatePassword(t *testing.T) {
+	game := NewGame(&watchedRules{}, "1")
+	game.password = "sesame"
+	conn := NewFakeConn("1")
+	game.handle(&Command{PlayerId: "a", Ws: conn, Type: cmdSpectate, Data: []byte(`{"Id":"1","Password":"guess"}`)})
+	if msg, ok := (<-conn.Msgs).(*MsgMsg); !ok || msg.Code != ErrRefused || msg.Msg != "Wrong password" {
+		t.Error("Expected the wrong password to be refused", msg)
+	}
+	if len(game.spectators) != 0 {
+		t.Error("Expected no spectator")
+	}
+	game.handle(&Command{PlayerId: "a", Ws: conn, Type: cmdSpectate, Data: []byte(`{"Id":"1","Password":"sesame"}`)})
+	if msg := <-conn.Msgs; msg != "secret free" {
+		t.Error("Expected the password to let them watch", msg)
+	}
+
+	game = NewGame(&watchedRules{}, "2")
+	game.banned = map[string]bool{"b": true}
+	game.handle(&Command{PlayerId: "b", Ws: conn, Type: cmdSpectate, Data: []byte(`"2"`)})
+	if msg, ok := (<-conn.Msgs).(*MsgMsg); !ok || msg.Code != ErrRefused {
+		t.Error("Expected banned players not to watch", msg)
+	}
+}
+
+func TestTabs(t *testing.T) {
+	rules := &testRules{}
+	game := NewGame(rules, "1")
+	one, two := NewFakeConn("a"), NewFakeConn("a")
+	game.handle(&Command{PlayerId: "a", Ws: one, Type: cmdJoin})
+	game.handle(&Command{PlayerId: "a", Ws: two, Type: cmdJoin})
+	if len(rules.players) != 1 {
+		t.Fatal("A second tab is the same player", len(rules.players))
+	}
+	drain(one)
+	drain(two)
+
+	game.Broadcast()
+	if len(drain(one)) != 1 || len(drain(two)) != 1 {
+		t.Error("Every tab should get the view")
+	}
+
+	game.handle(&Command{PlayerId: "a", Ws: one, Type: cmdDisconnect})
+	p := game.Player("a")
+	if !p.Connected {
+		t.Error("Player is still connected in the other tab")
+	}
+	game.Broadcast()
+	if len(drain(one)) != 0 || len(drain(two)) != 1 {
+		t.Error("Only open tabs get the view")
+	}
+	game.handle(&Command{PlayerId: "a", Ws: two, Type: cmdDisconnect})
+	if p.Connected {
+		t.Error("Closing the last tab disconnects the player")
+	}
+
+	game.handle(&Command{PlayerId: "a", Ws: one, Type: cmdJoin})
+	game.handle(&Command{PlayerId: "a", Ws: two, Type: cmdJoin})
+	game.handle(&Command{PlayerId: "a", Ws: one, Type: cmdLeave})
+	if len(rules.players) != 1 {
+		t.Error("Leaving in one tab shouldn't take the player out of the game they have open in another")
+	}
+	game.handle(&Command{PlayerId: "a", Ws: two, Type: cmdLeave})
+	if len(rules.players) != 0 {
+		t.Error("Expected the player to leave")
+	}
+}
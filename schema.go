@@ -0,0 +1,190 @@
+package wg
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema document
+type Schema map[string]interface{}
+
+var (
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	timeType       = reflect.TypeOf(time.Time{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// SchemaOf describes the JSON that encoding/json makes of v, v is usually the zero value of a type
+func SchemaOf(v interface{}) Schema {
+	if v == nil {
+		return Schema{}
+	}
+	if shapes, ok := v.(OneOf); ok {
+		var any []Schema
+		for _, shape := range shapes {
+			any = append(any, SchemaOf(shape))
+		}
+		return Schema{"oneOf": any}
+	}
+	return schemaOf(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case t == rawMessageType, t.Implements(marshalerType), reflect.PtrTo(t).Implements(marshalerType):
+		// encodes itself, could be anything
+		return Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return Schema{"type": []string{"array", "null"}, "items": schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return Schema{"type": []string{"object", "null"}, "additionalProperties": schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			// recursive, stop here rather than forever
+			return Schema{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		properties := Schema{}
+		var required []string
+		fields(t, seen, properties, &required)
+		sort.Strings(required)
+		s := Schema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	// interfaces, funcs and channels
+	return Schema{}
+}
+
+// fields adds the struct's fields the way encoding/json names them, including those of embedded structs
+func fields(t reflect.Type, seen map[reflect.Type]bool, properties Schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fields(ft, seen, properties, required)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := schemaOf(f.Type, seen)
+		omit := false
+		for _, opt := range parts[1:] {
+			switch opt {
+			case "omitempty":
+				omit = true
+			case "string":
+				s = Schema{"type": "string"}
+			}
+		}
+		properties[name] = s
+		if !omit {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// optional drops required from a schema, the server always writes those fields but clients can leave any out
+func optional(s Schema) Schema {
+	delete(s, "required")
+	for _, v := range s {
+		switch v := v.(type) {
+		case Schema:
+			optional(v)
+		case []Schema:
+			for _, sub := range v {
+				optional(sub)
+			}
+		}
+	}
+	return s
+}
+
+// ProtocolSchema describes every message of the protocol, for the games registered and the ones passed in
+func ProtocolSchema(types ...string) Schema {
+	describe := func(c Catalog) Schema {
+		commands, messages := Schema{}, Schema{}
+		for name, v := range c.Commands {
+			commands[name] = optional(SchemaOf(v))
+		}
+		for name, v := range c.Messages {
+			messages[name] = SchemaOf(v)
+		}
+		return Schema{"commands": commands, "messages": messages}
+	}
+	if len(types) == 0 {
+		for t := range catalogs {
+			types = append(types, t)
+		}
+	}
+	games := Schema{}
+	for _, t := range types {
+		if c, ok := catalogs[t]; ok {
+			games[t] = describe(c)
+		}
+	}
+	return Schema{
+		"$schema":  "http://json-schema.org/draft-07/schema#",
+		"protocol": ProtocolVersion,
+		// every command a client sends looks like this, Data is described per command
+		"command": Schema{
+			"type":     "object",
+			"required": []string{"Type"},
+			"properties": Schema{
+				"Type":    Schema{"type": "string"},
+				"Version": Schema{"type": "integer"},
+				"Data":    Schema{},
+			},
+		},
+		"errors": []string{ErrBadData, ErrUnknownCommand, ErrNotHost, ErrNotFound, ErrRefused, ErrUnknownType,
+			ErrDraining, ErrProtocol, ErrInvalid, ErrRateLimited, ErrBanned, ErrTooManyConnections},
+		"core":  describe(coreCatalog),
+		"games": games,
+	}
+}
+
+// SchemaHandler serves the protocol schema of the games passed in, or of every game registered
+func SchemaHandler(types ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, ProtocolSchema(types...))
+	}
+}
@@ -0,0 +1,200 @@
+package wg
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// drainMsg is what players see when the server stops making games
+	drainMsg = "The server is restarting, your game will be back in a minute"
+	// reapEvery is how often games check their lifecycle
+	reapEvery = time.Minute
+	// shutdownWait is the default for how long a shutdown waits on games and connections
+	shutdownWait = 30 * time.Second
+)
+
+// Server runs the games and the HTTP server, and shuts both down gracefully for deploys
+type Server struct {
+	Games *Games
+	// Persist saves every game once it has stopped, on top of the saves after each command
+	Persist bool
+	// CertFile and KeyFile turn on TLS when both are set
+	CertFile, KeyFile string
+	// ShutdownWait is how long ListenAndServe gives the shutdown after its context is done
+	ShutdownWait time.Duration
+
+	http      *http.Server
+	quit      chan struct{}
+	drainOnce sync.Once
+	quitOnce  sync.Once
+}
+
+// NewServer serves handler on addr, handler can be nil for http.DefaultServeMux
+func NewServer(addr string, handler http.Handler) *Server {
+	s := &Server{
+		Games:        AllGames,
+		ShutdownWait: shutdownWait,
+		http:         &http.Server{Addr: addr, Handler: handler},
+		quit:         make(chan struct{}),
+	}
+	// SSE and poll requests would otherwise hold up the shutdown until they time out
+	s.http.RegisterOnShutdown(closeSessions)
+	return s
+}
+
+// ListenAndServe serves until ctx is done and then shuts down
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	go s.reap()
+	errs := make(chan error, 1)
+	go func() {
+		if s.CertFile != "" && s.KeyFile != "" {
+			errs <- s.http.ListenAndServeTLS(s.CertFile, s.KeyFile)
+		} else {
+			errs <- s.http.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	log.Println("Shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), s.ShutdownWait)
+	defer cancel()
+	return s.Shutdown(shutdown)
+}
+
+func (s *Server) reap() {
+	ticker := time.NewTicker(reapEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Games.reap()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Drain stops new games from being made and warns everyone playing, games already going carry on
+func (s *Server) Drain() {
+	s.drainOnce.Do(func() {
+		log.Println("Draining")
+		s.Games.Lock()
+		s.Games.draining = true
+		s.Games.Unlock()
+		// waits so the warning goes out before any stop from Shutdown
+		data, _ := json.Marshal(drainMsg)
+		var wg sync.WaitGroup
+		for _, id := range s.Games.Ids() {
+			game := s.Games.Get(id)
+			if game == nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				game.send(&Command{Type: cmdAnnounce, Data: data})
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+// Shutdown drains, lets every game finish the commands it was given and stops it, then stops the HTTP server
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Drain()
+	s.quitOnce.Do(func() {
+		close(s.quit)
+	})
+
+	var wg sync.WaitGroup
+	for _, id := range s.Games.Ids() {
+		game := s.Games.Get(id)
+		if game == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := game.shutdown(ctx, s.Persist); err != nil {
+				log.Println("Game", game.Id, "didn't stop", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return s.http.Shutdown(ctx)
+}
+
+// shutdown stops the game after the commands ahead of the stop, then hangs up on everyone in it
+func (g *Game) shutdown(ctx context.Context, persist bool) error {
+	select {
+	case g.Cmd <- &Command{Type: cmdStop}:
+	case <-g.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-g.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// the game's goroutine is gone so it's safe to touch the game from here
+	if persist {
+		g.Save()
+	}
+	g.hangUp()
+	return nil
+}
+
+// hangUp closes the connections of everyone in the game, from its goroutine or once that has stopped
+func (g *Game) hangUp() {
+	for _, p := range g.Rules.Roster() {
+		for _, c := range p.conns {
+			c.Close()
+		}
+	}
+	g.spectatorsLock.RLock()
+	for _, conns := range g.spectators {
+		for _, ws := range conns {
+			ws.Close()
+		}
+	}
+	g.spectatorsLock.RUnlock()
+}
+
+func closeSessions() {
+	sessions.RLock()
+	var conns []*httpConn
+	for _, c := range sessions.conns {
+		conns = append(conns, c)
+	}
+	sessions.RUnlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// SignalContext is done when the process is told to stop, for passing to ListenAndServe
+func SignalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx
+}
@@ -0,0 +1,43 @@
+package wg
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	server := NewServer("127.0.0.1:0", nil)
+	server.Games = NewGames()
+	game := NewGame(&testRules{}, "1")
+	game.Start()
+	server.Games.Set(game)
+	conn := NewFakeConn("a")
+	game.Cmd <- &Command{PlayerId: "a", Ws: conn, Type: cmdJoin}
+
+	server.Drain()
+	if !server.Games.Draining() {
+		t.Error("Expected the games to be draining")
+	}
+	for {
+		if msg, ok := (<-conn.Msgs).(*MsgMsg); ok && msg.Msg == drainMsg {
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-game.done:
+	default:
+		t.Error("Expected the game to be stopped")
+	}
+	if !conn.Closed {
+		t.Error("Expected players to be hung up on")
+	}
+	// commands for a stopped game are dropped instead of blocking the player forever
+	game.deliver(&Command{PlayerId: "a", Type: cmdLeave})
+}
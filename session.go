@@ -0,0 +1,354 @@
+package wg
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A session is what games see of a client. It outlives the client's connection so a flaky network doesn't make
+// the player flicker in and out: when the connection drops the session waits ResumeGrace for the client to come
+// back on a new connection with a resume command, then sends it whatever it missed.
+
+var (
+	// ResumeGrace is how long a dropped client has to resume before the game hears it disconnected
+	ResumeGrace = 20 * time.Second
+	// PingEvery is how often clients that answer pings get one, missing two drops the connection
+	PingEvery = 15 * time.Second
+)
+
+// resumeBuffer is how many messages are kept for a resuming client to catch up on
+const resumeBuffer = 200
+
+const (
+	cmdResume = "resume"
+	cmdPong   = "pong"
+)
+
+// pingProtocol is the first protocol whose clients answer pings
+const pingProtocol = 3
+
+// ResumeRequest is the Data of resume, the first command on a new connection. Received is how many messages the
+// client got on its old session, not counting the cookie, pings or resume messages.
+type ResumeRequest struct {
+	Token    string
+	Received int
+}
+
+// ResumeMsg answers a resume, when it didn't work the client carries on with the new session as if it just arrived
+type ResumeMsg struct {
+	Type    string
+	Resumed bool
+}
+
+// PingMsg should be answered with a pong command
+type PingMsg struct {
+	Type string
+}
+
+var resumable = struct {
+	sync.Mutex
+	sessions map[string]*session
+}{sessions: map[string]*session{}}
+
+// link is one physical connection, released is closed when no session is using it any more
+type link struct {
+	ws       Connector
+	released chan struct{}
+	once     sync.Once
+}
+
+func newLink(ws Connector) *link {
+	return &link{ws: ws, released: make(chan struct{})}
+}
+
+func (l *link) release() {
+	l.once.Do(func() {
+		close(l.released)
+	})
+}
+
+type sent struct {
+	b   []byte
+	raw bool
+}
+
+// session is a Connector over whichever connection the client has at the moment
+type session struct {
+	sync.Mutex
+	token    string
+	playerId string
+	link     *link
+	ip       string
+	cookies  Connector // the first connection, which the cookie came in on
+	grace    time.Duration
+
+	sent     int // messages sent over the whole session
+	ring     []sent
+	fresh    bool // nothing received yet, only a fresh session can be swapped for an old one
+	pinged   bool // the client answers pings
+	lastSeen time.Time
+
+	attached chan struct{}
+	ended    chan struct{}
+	endOnce  sync.Once
+}
+
+func newSession(playerId string, l *link) *session {
+	s := &session{
+		token:    uuid.New().String(),
+		playerId: playerId,
+		link:     l,
+		ip:       l.ws.Ip(),
+		cookies:  l.ws,
+		grace:    ResumeGrace,
+		fresh:    true,
+		lastSeen: time.Now(),
+		attached: make(chan struct{}, 1),
+		ended:    make(chan struct{}),
+	}
+	resumable.Lock()
+	resumable.sessions[s.token] = s
+	resumable.Unlock()
+	go s.ping()
+	return s
+}
+
+func (s *session) Send(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Failed to marshal message", err)
+		return
+	}
+	s.send(sent{b: b})
+}
+
+func (s *session) SendRaw(b []byte) {
+	s.send(sent{b: b, raw: true})
+}
+
+func (s *session) send(msg sent) {
+	s.Lock()
+	defer s.Unlock()
+	s.sent++
+	s.ring = append(s.ring, msg)
+	if len(s.ring) > resumeBuffer {
+		s.ring = s.ring[len(s.ring)-resumeBuffer:]
+	}
+	if s.link != nil {
+		write(s.link.ws, msg)
+	}
+}
+
+func write(ws Connector, msg sent) {
+	if msg.raw {
+		ws.SendRaw(msg.b)
+	} else {
+		ws.Send(json.RawMessage(msg.b))
+	}
+}
+
+// Recv waits out dropped connections, and handles the commands that are about the connection rather than the game
+func (s *session) Recv(v interface{}) error {
+	for {
+		l := s.current()
+		if l == nil {
+			return io.EOF
+		}
+		var raw json.RawMessage
+		if err := l.ws.Recv(&raw); err != nil {
+			s.detach(l)
+			continue
+		}
+		var head struct {
+			Type string
+			Data json.RawMessage
+		}
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return err
+		}
+
+		s.Lock()
+		s.lastSeen = time.Now()
+		fresh := s.fresh
+		s.fresh = false
+		s.Unlock()
+
+		switch head.Type {
+		case cmdPong:
+			continue
+		case cmdResume:
+			if fresh && s.resume(l, head.Data) {
+				// the connection belongs to the old session now and this one is done
+				return io.EOF
+			}
+			l.ws.Send(&ResumeMsg{Type: "resume", Resumed: false})
+			continue
+		case cmdHello:
+			if protocol, ok := negotiate(head.Data); ok && protocol >= pingProtocol {
+				s.Lock()
+				s.pinged = true
+				s.Unlock()
+			}
+		}
+		return json.Unmarshal(raw, v)
+	}
+}
+
+func (s *session) RecvRaw(v []byte) error {
+	l := s.current()
+	if l == nil {
+		return io.EOF
+	}
+	return l.ws.RecvRaw(v)
+}
+
+// current is the session's connection, waiting out the grace period for one. nil means the session is over.
+func (s *session) current() *link {
+	s.Lock()
+	l := s.link
+	s.Unlock()
+	if l != nil {
+		return l
+	}
+	timer := time.NewTimer(s.grace)
+	defer timer.Stop()
+	select {
+	case <-s.attached:
+		return s.current()
+	case <-timer.C:
+		log.Println("Player", s.playerId, "didn't come back")
+	case <-s.ended:
+	}
+	s.end()
+	return nil
+}
+
+// detach lets go of a connection that stopped working
+func (s *session) detach(l *link) {
+	s.Lock()
+	if s.link == l {
+		s.link = nil
+	}
+	s.Unlock()
+	l.ws.Close()
+	l.release()
+}
+
+// resume moves the connection to the session the token is for, sending it what it missed
+func (s *session) resume(l *link, data json.RawMessage) bool {
+	var req ResumeRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return false
+	}
+	resumable.Lock()
+	old := resumable.sessions[req.Token]
+	resumable.Unlock()
+	if old == nil || old == s || old.playerId != s.playerId || !old.attach(l, req.Received) {
+		return false
+	}
+	s.Lock()
+	s.link = nil
+	s.Unlock()
+	s.end()
+	return true
+}
+
+// attach takes over a connection for the session, unless the client missed more than is kept
+func (s *session) attach(l *link, received int) bool {
+	s.Lock()
+	defer s.Unlock()
+	select {
+	case <-s.ended:
+		return false
+	default:
+	}
+	missed := s.sent - received
+	if missed < 0 || missed > len(s.ring) {
+		return false
+	}
+	if s.link != nil {
+		// the old connection hadn't noticed it was dead yet
+		s.link.ws.Close()
+		s.link.release()
+	}
+	s.link = l
+	s.ip = l.ws.Ip()
+	s.lastSeen = time.Now()
+	l.ws.Send(&ResumeMsg{Type: "resume", Resumed: true})
+	for _, msg := range s.ring[len(s.ring)-missed:] {
+		write(l.ws, msg)
+	}
+	select {
+	case s.attached <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// ping drops connections of clients that stop answering, they can still resume
+func (s *session) ping() {
+	ticker := time.NewTicker(PingEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.ended:
+			return
+		}
+		s.Lock()
+		l := s.link
+		pinged, quiet := s.pinged, time.Since(s.lastSeen)
+		if l != nil && pinged && quiet < 2*PingEvery {
+			l.ws.Send(&PingMsg{Type: "ping"})
+		}
+		s.Unlock()
+		if l != nil && pinged && quiet >= 2*PingEvery {
+			log.Println("Player", s.playerId, "stopped answering pings")
+			l.ws.Close()
+		}
+	}
+}
+
+// end finishes the session and lets go of its connection
+func (s *session) end() {
+	s.endOnce.Do(func() {
+		resumable.Lock()
+		delete(resumable.sessions, s.token)
+		resumable.Unlock()
+		s.Lock()
+		l := s.link
+		s.link = nil
+		s.Unlock()
+		if l != nil {
+			l.ws.Close()
+			l.release()
+		}
+		close(s.ended)
+	})
+}
+
+// Close ends the session for good, there's no resuming it
+func (s *session) Close() error {
+	s.end()
+	return nil
+}
+
+func (s *session) Ip() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.ip
+}
+
+func (s *session) Cookie(name string) (*http.Cookie, error) {
+	if s.cookies == nil {
+		return nil, errors.New("no connection")
+	}
+	return s.cookies.Cookie(name)
+}
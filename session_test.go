@@ -0,0 +1,133 @@
+package wg
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeConn is a connection the test sends commands down, closing it makes Recv fail
+type pipeConn struct {
+	*FakeConn
+	in     chan string
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeConn() *pipeConn {
+	return &pipeConn{FakeConn: NewFakeConn("1.2.3.4"), in: make(chan string, 10), closed: make(chan struct{})}
+}
+
+func (c *pipeConn) Recv(v interface{}) error {
+	select {
+	case msg := <-c.in:
+		return json.Unmarshal([]byte(msg), v)
+	case <-c.closed:
+		return io.EOF
+	}
+}
+
+func (c *pipeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// next is the next message sent down the connection, decoded
+func (c *pipeConn) next(t *testing.T) map[string]interface{} {
+	select {
+	case msg := <-c.Msgs:
+		b, _ := json.Marshal(msg)
+		var m map[string]interface{}
+		json.Unmarshal(b, &m)
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("Expected a message")
+		return nil
+	}
+}
+
+func recvAll(s *session) chan *Command {
+	cmds := make(chan *Command, 10)
+	go func() {
+		for {
+			cmd := &Command{}
+			if err := s.Recv(cmd); err != nil {
+				close(cmds)
+				return
+			}
+			cmds <- cmd
+		}
+	}()
+	return cmds
+}
+
+func TestSessionResume(t *testing.T) {
+	defer func(grace time.Duration) { ResumeGrace = grace }(ResumeGrace)
+	ResumeGrace = 100 * time.Millisecond
+
+	first := newPipeConn()
+	s := newSession("a", newLink(first))
+	cmds := recvAll(s)
+	s.Send(&MsgMsg{Type: "msg", Msg: "one"})
+	first.next(t)
+
+	// the connection drops and messages carry on into the buffer
+	first.Close()
+	s.Send(&MsgMsg{Type: "msg", Msg: "two"})
+	s.Send(&MsgMsg{Type: "msg", Msg: "three"})
+
+	second := newPipeConn()
+	fresh := newSession("a", newLink(second))
+	freshCmds := recvAll(fresh)
+	second.in <- `{"Type":"resume","Data":{"Token":"` + s.token + `","Received":1}}`
+	if _, ok := <-freshCmds; ok {
+		t.Error("The new session should be over once the old one is resumed")
+	}
+	if msg := second.next(t); msg["Type"] != "resume" || msg["Resumed"] != true {
+		t.Fatal("Expected the resume to work", msg)
+	}
+	for _, want := range []string{"two", "three"} {
+		if msg := second.next(t); msg["Msg"] != want {
+			t.Error("Expected missed message", want, "got", msg)
+		}
+	}
+
+	second.in <- `{"Type":"pong"}`
+	second.in <- `{"Type":"chat","Data":"hi"}`
+	if cmd := <-cmds; cmd.Type != "chat" {
+		t.Error("Expected commands on the new connection to reach the old session", cmd)
+	}
+
+	// after the grace period without a resume the session is over
+	second.Close()
+	select {
+	case _, ok := <-cmds:
+		if ok {
+			t.Error("Expected the session to end")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the session to end after the grace period")
+	}
+}
+
+func TestSessionResumeFails(t *testing.T) {
+	s := newSession("a", newLink(newPipeConn()))
+	defer s.Close()
+
+	conn := newPipeConn()
+	other := newSession("b", newLink(conn))
+	defer other.Close()
+	cmds := recvAll(other)
+
+	// someone else's token doesn't work
+	conn.in <- `{"Type":"resume","Data":{"Token":"` + s.token + `"}}`
+	if msg := conn.next(t); msg["Type"] != "resume" || msg["Resumed"] != false {
+		t.Error("Expected the resume to fail", msg)
+	}
+	conn.in <- `{"Type":"list"}`
+	if cmd := <-cmds; cmd.Type != "list" {
+		t.Error("Expected the new session to carry on", cmd)
+	}
+}
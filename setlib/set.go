@@ -4,13 +4,35 @@ import (
 	"encoding/json"
 	"github.com/jakecoffman/wg"
 	"log"
-	"math/rand"
 	"sort"
-	"time"
 )
 
 const DEV = false
 
+// Name is the game type, used to restore saved games
+const Name = "set"
+
+func init() {
+	wg.RegisterRestorer(Name, Restore)
+	wg.RegisterBuilder(Name, create)
+	wg.RegisterResults(Name)
+	wg.RegisterCatalog(Name, wg.Catalog{
+		Commands: map[string]interface{}{
+			cmdReady:  false,
+			cmdRename: "",
+			cmdPlay:   []int{},
+			cmdNoSets: nil,
+		},
+		Messages: map[string]interface{}{
+			"all":    UpdateMsg{},
+			"update": UpdateMsg{},
+			"meta":   MetaMsg{},
+			"play":   PlayMsg{},
+			"cheat":  map[string]interface{}{},
+		},
+	})
+}
+
 type Set struct {
 	*wg.Game
 
@@ -18,32 +40,81 @@ type Set struct {
 	rands  []int
 	cursor int
 
-	players      map[string]*Player
-	playerCursor int
+	players map[string]*Player
 }
 
 type Player struct {
-	ws        wg.Connector
-	Id        int
-	Score     int
-	Connected bool `json:",omitempty"`
-	ip        string
-	Ready     bool `json:",omitempty"`
-	Name      string `json:",omitempty"`
+	*wg.Player
+	Score int
+	Ready bool `json:",omitempty"`
+
+	// this game's, Score carries on from game to game
+	points     int
+	sets       int
+	falseCalls int
 }
 
-func NewGame(id string) *wg.Game {
+func NewGame(id string, opts ...wg.Option) *wg.Game {
+	game := create(id, opts...)
+	game.Start()
+	return game
+}
+
+func create(id string, opts ...wg.Option) *wg.Game {
 	g := &Set{
-		players:      map[string]*Player{},
-		playerCursor: 1,
-		board:        []Card{},
+		players: map[string]*Player{},
+		board:   []Card{},
 	}
-	g.Game = wg.NewGame(g, id)
-	go g.run()
+	g.Game = wg.NewGame(g, id, opts...)
+	g.Type = Name
 	g.reset()
 	return g.Game
 }
 
+// state is everything in Set that needs saving, including the private parts
+type state struct {
+	Board   []Card
+	Rands   []int
+	Cursor  int
+	Players map[string]*Player
+}
+
+func (g *Set) MarshalState() (json.RawMessage, error) {
+	return json.Marshal(&state{
+		Board:   g.board,
+		Rands:   g.rands,
+		Cursor:  g.cursor,
+		Players: g.players,
+	})
+}
+
+// Restore brings a saved game back, everyone starts out disconnected
+func Restore(snap *wg.Snapshot) (*wg.Game, error) {
+	var s state
+	if err := json.Unmarshal(snap.State, &s); err != nil {
+		return nil, err
+	}
+	g := &Set{
+		board:   s.Board,
+		rands:   s.Rands,
+		cursor:  s.Cursor,
+		players: s.Players,
+	}
+	if g.players == nil {
+		g.players = map[string]*Player{}
+	}
+	for uuid, p := range g.players {
+		if p.Player == nil {
+			p.Player = &wg.Player{}
+		}
+		p.Uuid = uuid
+		p.Connected = false
+	}
+	g.Game = wg.RestoreGame(g, snap)
+	g.Start()
+	return g.Game, nil
+}
+
 const (
 	cmdJoin       = "join"
 	cmdLeave      = "leave"
@@ -55,43 +126,32 @@ const (
 	cmdStop       = "stop"
 )
 
-func (g *Set) run() {
-	var cmd *wg.Command
-	for {
-		cmd = <-g.Cmd
-		switch cmd.Type {
-		case cmdJoin:
-			g.join(cmd)
-		case cmdLeave:
-			g.leave(cmd)
-		case cmdDisconnect:
-			g.disconnect(cmd)
-		case cmdReady:
-			g.ready(cmd)
-		case cmdRename:
-			g.rename(cmd)
-		case cmdNoSets:
-			g.noSets(cmd)
-		case cmdPlay:
-			g.play(cmd)
-		case cmdStop:
-			log.Println("Stopping set game", g.Id)
-			return
-		}
-		g.Updated = time.Now()
-		if DEV {
-			g.sendEveryoneCheats()
-		}
+func (g *Set) Roster() []*wg.Player {
+	var players []*wg.Player
+	for _, p := range g.players {
+		players = append(players, p.Player)
 	}
+	return players
 }
 
-func (g *Set) disconnect(cmd *wg.Command) {
-	p := g.players[cmd.PlayerId]
-	if p != nil {
-		p.ws = nil
-		p.Connected = false
-		g.sendMetaToEveryone()
+func (g *Set) HandleCommand(cmd *wg.Command) bool {
+	switch cmd.Type {
+	case cmdReady:
+		g.ready(cmd)
+	case cmdRename:
+		g.rename(cmd)
+	case cmdNoSets:
+		g.noSets(cmd)
+	case cmdPlay:
+		g.play(cmd)
+	default:
+		log.Println("Unknown message:", cmd.Type)
+	}
+	if DEV {
+		g.sendEveryoneCheats()
 	}
+	// set sends its own updates since most plays only change a few cards
+	return false
 }
 
 func (g *Set) ready(cmd *wg.Command) {
@@ -116,32 +176,21 @@ func (g *Set) rename(cmd *wg.Command) {
 		return
 	}
 
-	var name string
-	err := json.Unmarshal(cmd.Data, &name)
-	if err != nil {
+	if err := p.Rename(cmd.Data); err != nil {
 		log.Println(err)
 		return
 	}
-
-	if len(name) > 8 {
-		p.Name = name[0:8]
-	} else {
-		p.Name = name
-	}
 	g.sendMetaToEveryone()
 }
 
-func (g *Set) leave(cmd *wg.Command) {
-	delete(g.players, cmd.PlayerId)
-	g.sendMetaToEveryone()
+func (g *Set) OnLeave(p *wg.Player) {
+	delete(g.players, p.Uuid)
 }
 
-func (g *Set) join(cmd *wg.Command) {
-	var player *Player
-	var ok bool
-	if player, ok = g.players[cmd.PlayerId]; !ok {
+func (g *Set) OnJoin(p *wg.Player) error {
+	if _, ok := g.players[p.Uuid]; !ok {
 		// player was not here before, create
-		player = &Player{Id: g.playerCursor}
+		player := &Player{Player: p}
 		// mark player as ready if game already started
 		if len(g.players) > 0 {
 			player.Ready = true
@@ -152,21 +201,13 @@ func (g *Set) join(cmd *wg.Command) {
 				}
 			}
 		}
-		g.players[cmd.PlayerId] = player
-		g.playerCursor += 1
+		g.players[p.Uuid] = player
 	}
-	player.ws = cmd.Ws
-	player.Connected = true
-	player.ip = player.ws.Ip()
-	g.sendEverythingTo(cmd.Ws)
-	g.sendMetaToEveryone()
+	g.sendEverythingTo(p)
+	return nil
 }
 
-func (g *Set) sendEverythingTo(ws wg.Connector) {
-	if ws == nil {
-		return
-	}
-
+func (g *Set) everything() UpdateMsg {
 	update := UpdateMsg{
 		Type:    "all",
 		Updates: []Update{},
@@ -178,24 +219,29 @@ func (g *Set) sendEverythingTo(ws wg.Connector) {
 	for i := 0; i < len(g.board); i++ {
 		update.Updates = append(update.Updates, Update{Location: i, Card: g.board[i]})
 	}
+	return update
+}
 
-	ws.Send(update)
+func (g *Set) sendEverythingTo(p *wg.Player) {
+	p.Send(g.everything())
 }
 
 func (g *Set) sendEveryoneEverything() {
-	update := UpdateMsg{
-		Type:    "all",
-		Updates: []Update{},
-		Players: g.players,
-		Version: g.Version,
-	}
+	g.SendAll(g.everything())
+}
 
-	// order is important when sending all because javascript is rebuilding DOM
-	for i := 0; i < len(g.board); i++ {
-		update.Updates = append(update.Updates, Update{Location: i, Card: g.board[i]})
-	}
+// Capacity is unlimited, anyone can jump into a game of Set
+func (g *Set) Capacity() int {
+	return 0
+}
+
+func (g *Set) InLobby() bool {
+	return true
+}
 
-	g.sendAll(update)
+// SpectatorView is the whole board, there's nothing secret in Set
+func (g *Set) SpectatorView() interface{} {
+	return g.everything()
 }
 
 func (g *Set) sendEveryoneCheats() {
@@ -208,10 +254,16 @@ func (g *Set) sendEveryoneCheats() {
 		return
 	}
 
-	g.sendAll(msg)
+	g.SendAll(msg)
 }
 
+// sendMetaToEveryone is used when something happened that didn't change the board
 func (g *Set) sendMetaToEveryone() {
+	g.Broadcast()
+}
+
+// ViewFor is the meta message, the board is sent separately
+func (g *Set) ViewFor(p *wg.Player) interface{} {
 	playing := true
 	for _, p := range g.players {
 		if p.Ready != true {
@@ -219,31 +271,19 @@ func (g *Set) sendMetaToEveryone() {
 			break
 		}
 	}
-	msg := MetaMsg{
+	return MetaMsg{
 		Type:    "meta",
 		Players: g.players,
 		GameId:  g.Id,
 		Playing: playing,
 		Version: g.Version,
-	}
-	for _, player := range g.players {
-		if player.ws != nil {
-			msg.You = player.Id
-			player.ws.Send(msg)
-		}
-	}
-}
-
-func (g *Set) sendAll(msg interface{}) {
-	for _, player := range g.players {
-		if player.ws != nil {
-			player.ws.Send(msg)
-		}
+		You:     p.Id,
+		Host:    g.Host,
 	}
 }
 
 func (g *Set) reset() {
-	g.rands = rand.Perm(len(deck))
+	g.rands = g.Rand.Perm(len(deck))
 	g.board = []Card{}
 	for g.cursor = 0; g.cursor < 12; g.cursor++ {
 		g.board = append(g.board, deck[g.rands[g.cursor]])
@@ -261,7 +301,9 @@ func (g *Set) noSets(cmd *wg.Command) {
 	sets := g.FindSets()
 	if len(sets) > 0 {
 		g.players[playerId].Score -= len(sets)
-		g.sendAll(&PlayMsg{
+		g.players[playerId].points -= len(sets)
+		g.players[playerId].falseCalls++
+		g.SendAll(&PlayMsg{
 			Type:   "play",
 			Player: g.players[cmd.PlayerId].Id,
 			Words:  "missed some",
@@ -269,7 +311,8 @@ func (g *Set) noSets(cmd *wg.Command) {
 		})
 	} else {
 		g.players[playerId].Score += 1
-		g.sendAll(&PlayMsg{
+		g.players[playerId].points += 1
+		g.SendAll(&PlayMsg{
 			Type:   "play",
 			Player: g.players[cmd.PlayerId].Id,
 			Words: "no sets",
@@ -278,6 +321,16 @@ func (g *Set) noSets(cmd *wg.Command) {
 	}
 
 	if g.cursor == len(g.rands) {
+		var results []wg.Result
+		for uuid, p := range g.players {
+			results = append(results, wg.Result{
+				PlayerId: uuid,
+				Score:    p.points,
+				Counts:   map[string]int{"sets": p.sets, "false calls": p.falseCalls},
+			})
+			p.points, p.sets, p.falseCalls = 0, 0, 0
+		}
+		g.Finish(wg.MarkWinners(results))
 		log.Println("Restarting game")
 		g.reset()
 		g.sendEveryoneEverything()
@@ -299,7 +352,7 @@ func (g *Set) noSets(cmd *wg.Command) {
 			{Location: len(g.board) - 1, Card: g.board[len(g.board)-1]},
 		},
 	}
-	g.sendAll(update)
+	g.SendAll(update)
 }
 
 func (g *Set) play(cmd *wg.Command) {
@@ -318,8 +371,10 @@ func (g *Set) play(cmd *wg.Command) {
 	if !isSet(g.board[play[0]], g.board[play[1]], g.board[play[2]]) {
 		log.Println("Not a set...")
 		g.players[cmd.PlayerId].Score -= 1
+		g.players[cmd.PlayerId].points -= 1
+		g.players[cmd.PlayerId].falseCalls++
 		g.sendMetaToEveryone()
-		g.sendAll(&PlayMsg{
+		g.SendAll(&PlayMsg{
 			Type:   "play",
 			Player: g.players[cmd.PlayerId].Id,
 			Cards:  []Card{g.board[play[0]], g.board[play[1]], g.board[play[2]]},
@@ -330,9 +385,11 @@ func (g *Set) play(cmd *wg.Command) {
 	}
 	// it's a set
 	g.players[cmd.PlayerId].Score += 1
+	g.players[cmd.PlayerId].points += 1
+	g.players[cmd.PlayerId].sets++
 	g.Version += 1
 
-	g.sendAll(&PlayMsg{
+	g.SendAll(&PlayMsg{
 		Type:   "play",
 		Player: g.players[cmd.PlayerId].Id,
 		Cards:  []Card{g.board[play[0]], g.board[play[1]], g.board[play[2]]},
@@ -363,27 +420,32 @@ func (g *Set) play(cmd *wg.Command) {
 			{Location: play[1], Card: g.board[play[1]]},
 			{Location: play[2], Card: g.board[play[2]]},
 		}}
-	g.sendAll(update)
+	g.SendAll(update)
 }
 
 func (g Set) FindSets() [][]int {
+	return FindSets(g.board)
+}
+
+// FindSets returns the locations of every set on a board
+func FindSets(board []Card) [][]int {
 	var sets [][]int
-	size := len(g.board)
+	size := len(board)
 	var card1, card2, card3 Card
 
 	boardIndex := map[int]int{}
 	index := 0
-	for key := range g.board {
+	for key := range board {
 		boardIndex[index] = key
 		index++
 	}
 
 	for i := 0; i < size-2; i++ {
-		card1 = g.board[boardIndex[i]]
+		card1 = board[boardIndex[i]]
 		for j := i + 1; j < size-1; j++ {
-			card2 = g.board[boardIndex[j]]
+			card2 = board[boardIndex[j]]
 			for k := j + 1; k < size; k++ {
-				card3 = g.board[boardIndex[k]]
+				card3 = board[boardIndex[k]]
 				if isSet(card1, card2, card3) {
 					sets = append(sets, []int{boardIndex[i], boardIndex[j], boardIndex[k]})
 				}
@@ -412,6 +474,7 @@ type MetaMsg struct {
 	Players map[string]*Player
 	Version int
 	You     int
+	Host    int
 	Playing bool
 }
 
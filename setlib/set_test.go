@@ -2,38 +2,46 @@ package setlib
 
 import (
 	"testing"
-	"github.com/jakecoffman/wg"
-	"log"
-	"math/rand"
-	"time"
+
+	"github.com/jakecoffman/wg/wgtest"
 )
 
 func TestSet(t *testing.T) {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	rand.Seed(time.Now().UnixNano())
-
-	const gameId = "0"
-	const player1 = "1"
-	p1Conn := wg.NewFakeConn(player1)
+	// seeded by wgtest so a failure deals the same cards every run
+	game := wgtest.New(t, NewGame)
+	set := game.Rules.(*Set)
 
-	game := NewGame(gameId)
-	set := game.Class.(*Set)
-
-	game.Cmd <- &wg.Command{player1, p1Conn, cmdJoin, set.Version, nil}
+	p1 := game.Join("1")
 
+	var score int
 	for i := 0; i < 1000; i++ {
-	drain:
-		for {
-			select {
-			case <-p1Conn.Msgs:
-			default:
-				break drain
-			}
+		p1.Clear()
+		// the game is idle between steps so the board can be read directly
+		if sets := set.FindSets(); len(sets) > 0 {
+			p1.Do(cmdPlay, sets[0])
+		} else {
+			p1.Do(cmdNoSets, nil)
 		}
+		var play PlayMsg
+		p1.Expect(&play)
+		if play.Score != 1 {
+			t.Fatal("Expected a point for playing right", i, play)
+		}
+		score++
+	}
+	if set.players["1"].Score != score {
+		t.Error("Expected a point a play", set.players["1"].Score, score)
+	}
 
-		// let the resistance goroutine go, probably should improve this with locking
-		time.Sleep(1 * time.Millisecond)
-
-		// Try doing every board possibility, then press deal more?
+	sets := set.FindSets()
+	for len(sets) == 0 {
+		p1.Do(cmdNoSets, nil)
+		sets = set.FindSets()
+	}
+	p1.Clear()
+	p1.Do(cmdNoSets, nil)
+	var play PlayMsg
+	if p1.Expect(&play); play.Score != -len(sets) {
+		t.Error("Expected a point lost for every set missed", play, len(sets))
 	}
 }
@@ -0,0 +1,132 @@
+package wg
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Spectatable is implemented by games that can be watched
+type Spectatable interface {
+	// SpectatorView is the state sent to spectators, it must not contain any secrets
+	SpectatorView() interface{}
+}
+
+type delayed struct {
+	at  time.Time
+	msg json.RawMessage
+	to  Connector // nil means every spectator
+}
+
+func (g *Game) spectate(cmd *Command) bool {
+	view, ok := g.Rules.(Spectatable)
+	if !ok {
+		sendMsg(cmd.Ws, "This game can't be watched")
+		return false
+	}
+	if reason := g.allowed(cmd); reason != "" {
+		sendError(cmd.Ws, ErrRefused, reason)
+		return false
+	}
+	g.spectatorsLock.Lock()
+	if g.spectators == nil {
+		g.spectators = map[string][]Connector{}
+	}
+	if !hasConn(g.spectators[cmd.PlayerId], cmd.Ws) {
+		g.spectators[cmd.PlayerId] = append(g.spectators[cmd.PlayerId], cmd.Ws)
+	}
+	g.spectatorsLock.Unlock()
+	g.sendSpectator(cmd.Ws, view.SpectatorView())
+	return false
+}
+
+// unspectate stops the connection watching, or all of the player's when ws is nil. It's false if they weren't.
+func (g *Game) unspectate(uuid string, ws Connector) bool {
+	conns := g.spectators[uuid]
+	if len(conns) == 0 || (ws != nil && !hasConn(conns, ws)) {
+		return false
+	}
+	var rest []Connector
+	for _, c := range conns {
+		if ws != nil && c != ws {
+			rest = append(rest, c)
+		}
+	}
+	g.spectatorsLock.Lock()
+	if len(rest) > 0 {
+		g.spectators[uuid] = rest
+	} else {
+		delete(g.spectators, uuid)
+	}
+	g.spectatorsLock.Unlock()
+	return true
+}
+
+// sendSpectators sends a message to everyone watching, after the spectator delay
+func (g *Game) sendSpectators(msg interface{}) {
+	if len(g.spectators) == 0 {
+		return
+	}
+	g.sendSpectator(nil, msg)
+}
+
+// sendSpectator sends to one spectator, or all of them if ws is nil
+func (g *Game) sendSpectator(ws Connector, msg interface{}) {
+	if g.SpectatorDelay == 0 {
+		if ws != nil {
+			ws.Send(msg)
+			return
+		}
+		for _, conns := range g.spectators {
+			for _, ws := range conns {
+				ws.Send(msg)
+			}
+		}
+		return
+	}
+	// marshal now since the game will have changed by the time this is sent
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Println("Failed to marshal spectator message", err)
+		return
+	}
+	if g.delayed == nil {
+		g.delayed = make(chan delayed, 100)
+		go g.sendDelayed(g.delayed)
+	}
+	select {
+	case g.delayed <- delayed{at: g.clock.Now().Add(g.SpectatorDelay), msg: b, to: ws}:
+	default:
+		log.Println("Spectator queue full, dropping message for game", g.Id)
+	}
+}
+
+// sendDelayed sends messages in order once they are due, it runs until the game stops
+func (g *Game) sendDelayed(queue chan delayed) {
+	for d := range queue {
+		if wait := d.at.Sub(g.clock.Now()); wait > 0 {
+			due := make(chan struct{})
+			g.clock.AfterFunc(wait, func() { close(due) })
+			<-due
+		}
+		if d.to != nil {
+			d.to.Send(d.msg)
+			continue
+		}
+		g.spectatorsLock.RLock()
+		for _, conns := range g.spectators {
+			for _, ws := range conns {
+				ws.Send(d.msg)
+			}
+		}
+		g.spectatorsLock.RUnlock()
+	}
+}
+
+// SendAll sends a message to every connected player and spectator
+func (g *Game) SendAll(msg interface{}) {
+	for _, p := range g.Rules.Roster() {
+		p.Send(msg)
+	}
+	g.sendSpectators(msg)
+}
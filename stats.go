@@ -0,0 +1,470 @@
+package wg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every game two or more played to the end goes into the profiles of the players in it, by their cookie so an
+// account carries them from device to device. Each game type has its own Elo rating, players in a game are rated
+// against each other two at a time.
+
+const (
+	// ratingStart is everyone's rating before their first game
+	ratingStart = 1500.0
+	// ratingK is the most a rating can move in one game
+	ratingK = 32.0
+	// leaderboardSize is how many players a leaderboard shows unless asked for fewer
+	leaderboardSize = 20
+	leaderboardMax  = 100
+)
+
+// StatsStorage keeps profiles across restarts, nil means they only live in memory
+var StatsStorage StatsStore
+
+// StatsStore persists profiles, FileStore is one
+type StatsStore interface {
+	SaveProfile(p *Profile) error
+	LoadProfiles() ([]*Profile, error)
+}
+
+// Result is how one player did in a game that was played to the end
+type Result struct {
+	PlayerId string
+	Name     string `json:",omitempty"` // filled in by Finish when the game doesn't
+	Score    int
+	Won      bool
+	Role     string         `json:",omitempty"` // the side or character they played, for wins by role
+	Counts   map[string]int `json:",omitempty"` // game specific tallies, like sets found
+}
+
+// MarkWinners sets Won on the results with the top score, for games where the most points wins
+func MarkWinners(results []Result) []Result {
+	for i, r := range results {
+		won := true
+		for _, other := range results {
+			if other.Score > r.Score {
+				won = false
+			}
+		}
+		results[i].Won = won
+	}
+	return results
+}
+
+var reporting = map[string]bool{}
+
+// RegisterResults says games of a type call Finish when they're played to the end, only those can be played in
+// tournaments. Games do this in init.
+func RegisterResults(gameType string) {
+	reporting[gameType] = true
+}
+
+// Finish is called by a game from its goroutine when a game is played to the end. The results go into the players'
+// profiles, and to the tournament if the room is a tournament table.
+func (g *Game) Finish(results []Result) {
+	if g.replaying {
+		return
+	}
+	for i, r := range results {
+		if p := g.Player(r.PlayerId); p != nil && r.Name == "" {
+			results[i].Name = p.Name
+		}
+	}
+	profiles.record(g.Type, results)
+	if msg := tournaments.finished(g.Id, results); msg != "" {
+		g.SendMsgAll(msg)
+	}
+}
+
+// Profile is everything kept about a player
+type Profile struct {
+	PlayerId string
+	Name     string // the last one they played under
+	Games    map[string]*GameStats
+	Updated  time.Time
+}
+
+// GameStats is how a player has done at one type of game
+type GameStats struct {
+	Played  int
+	Wins    int
+	Score   int     // over every game
+	Average float64 // score a game
+	Rating  float64
+	Roles   map[string]*RoleStats `json:",omitempty"`
+	Counts  map[string]int        `json:",omitempty"`
+}
+
+// RoleStats is how a player has done playing one side or character
+type RoleStats struct {
+	Played int
+	Wins   int
+}
+
+// ProfileView is a profile as the API shows it, Id is public and isn't the player's cookie
+type ProfileView struct {
+	Id    string
+	Name  string
+	Games map[string]*GameStats
+}
+
+// LeaderboardEntry is one line of a leaderboard
+type LeaderboardEntry struct {
+	Id     string
+	Name   string
+	Rating int
+	Played int
+	Wins   int
+}
+
+// profileList finds profiles by cookie and by public id
+type profileList struct {
+	sync.Mutex
+	all    map[string]*Profile
+	public map[string]*Profile
+}
+
+var profiles = &profileList{all: map[string]*Profile{}, public: map[string]*Profile{}}
+
+// publicId names a player in the API without giving away their cookie
+func publicId(playerId string) string {
+	sum := sha256.Sum256([]byte(playerId))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (l *profileList) add(p *Profile) {
+	l.all[p.PlayerId] = p
+	l.public[publicId(p.PlayerId)] = p
+}
+
+// record adds a finished game to the profiles of everyone in it. A game played alone isn't counted, winning it
+// isn't beating anyone.
+func (l *profileList) record(gameType string, results []Result) {
+	if len(results) < 2 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	var ratings []float64
+	var stats []*GameStats
+	for _, r := range results {
+		p := l.all[r.PlayerId]
+		if p == nil {
+			p = &Profile{PlayerId: r.PlayerId, Games: map[string]*GameStats{}}
+			l.add(p)
+		}
+		s := p.Games[gameType]
+		if s == nil {
+			s = &GameStats{Rating: ratingStart}
+			p.Games[gameType] = s
+		}
+		ratings = append(ratings, s.Rating)
+		stats = append(stats, s)
+	}
+	changes := rate(ratings, results)
+
+	now := time.Now()
+	for i, r := range results {
+		s := stats[i]
+		s.Played++
+		s.Score += r.Score
+		s.Average = float64(s.Score) / float64(s.Played)
+		s.Rating += changes[i]
+		if r.Won {
+			s.Wins++
+		}
+		if r.Role != "" {
+			if s.Roles == nil {
+				s.Roles = map[string]*RoleStats{}
+			}
+			role := s.Roles[r.Role]
+			if role == nil {
+				role = &RoleStats{}
+				s.Roles[r.Role] = role
+			}
+			role.Played++
+			if r.Won {
+				role.Wins++
+			}
+		}
+		for k, v := range r.Counts {
+			if s.Counts == nil {
+				s.Counts = map[string]int{}
+			}
+			s.Counts[k] += v
+		}
+
+		p := l.all[r.PlayerId]
+		if r.Name != "" {
+			p.Name = r.Name
+		}
+		p.Updated = now
+		if StatsStorage != nil {
+			if err := StatsStorage.SaveProfile(p); err != nil {
+				log.Println("Failed to save profile", err)
+			}
+		}
+	}
+}
+
+// rating is the player's rating at a game type, ratingStart if they haven't finished one
+func (l *profileList) rating(gameType, playerId string) float64 {
+	l.Lock()
+	defer l.Unlock()
+	if p := l.all[playerId]; p != nil && p.Games[gameType] != nil {
+		return p.Games[gameType].Rating
+	}
+	return ratingStart
+}
+
+// rate is how much each rating changes. Everyone is compared with everyone else, winning beats losing and then the
+// higher score wins, so teammates on the same side draw with each other.
+func rate(ratings []float64, results []Result) []float64 {
+	changes := make([]float64, len(results))
+	if len(results) < 2 {
+		return changes
+	}
+	for i, a := range results {
+		var sum float64
+		for j, b := range results {
+			if i == j {
+				continue
+			}
+			expected := 1 / (1 + math.Pow(10, (ratings[j]-ratings[i])/400))
+			sum += outcome(a, b) - expected
+		}
+		changes[i] = ratingK * sum / float64(len(results)-1)
+	}
+	return changes
+}
+
+// outcome is 1 if a did better than b, 0 if worse and a half for a draw
+func outcome(a, b Result) float64 {
+	switch {
+	case a.Won != b.Won:
+		if a.Won {
+			return 1
+		}
+		return 0
+	case a.Score > b.Score:
+		return 1
+	case a.Score < b.Score:
+		return 0
+	}
+	return 0.5
+}
+
+func (p *Profile) view() *ProfileView {
+	name := p.Name
+	if name == "" {
+		name = "Anonymous"
+	}
+	return &ProfileView{Id: publicId(p.PlayerId), Name: name, Games: p.Games}
+}
+
+// PlayerProfile finds a profile by public id, nil if there isn't one
+func PlayerProfile(id string) *ProfileView {
+	profiles.Lock()
+	defer profiles.Unlock()
+	p := profiles.public[id]
+	if p == nil {
+		return nil
+	}
+	return copyView(p)
+}
+
+// copyView is a view that won't change while it's being encoded, the profiles are locked while it's made
+func copyView(p *Profile) *ProfileView {
+	b, _ := json.Marshal(p.view())
+	view := &ProfileView{}
+	json.Unmarshal(b, view)
+	return view
+}
+
+// Leaderboard is the best rated players of a game type
+func Leaderboard(gameType string, limit int) []LeaderboardEntry {
+	profiles.Lock()
+	defer profiles.Unlock()
+	entries := []LeaderboardEntry{}
+	for _, p := range profiles.all {
+		s := p.Games[gameType]
+		if s == nil {
+			continue
+		}
+		view := p.view()
+		entries = append(entries, LeaderboardEntry{
+			Id:     view.Id,
+			Name:   view.Name,
+			Rating: int(math.Round(s.Rating)),
+			Played: s.Played,
+			Wins:   s.Wins,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Rating != entries[j].Rating {
+			return entries[i].Rating > entries[j].Rating
+		}
+		return entries[i].Id < entries[j].Id
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// RestoreStats loads the stored profiles
+func RestoreStats(store StatsStore) error {
+	list, err := store.LoadProfiles()
+	if err != nil {
+		return err
+	}
+	profiles.Lock()
+	defer profiles.Unlock()
+	for _, p := range list {
+		profiles.add(p)
+	}
+	log.Println("Restored", len(list), "profiles")
+	return nil
+}
+
+// StatsAPI serves profiles and leaderboards:
+//
+//	GET /stats/me                        the profile of the player asking
+//	GET /stats/players/{id}              a profile by the Id leaderboards show
+//	GET /stats/leaderboard/{type}?n=20   the best rated players of a game type
+func StatsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/stats"), "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "me":
+		playerId := requestPlayer(r)
+		profiles.Lock()
+		p := profiles.all[playerId]
+		var view *ProfileView
+		if p != nil {
+			view = copyView(p)
+		}
+		profiles.Unlock()
+		if view == nil {
+			http.Error(w, "Finish a game to get a profile", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, view)
+	case len(parts) == 2 && parts[0] == "players":
+		view := PlayerProfile(parts[1])
+		if view == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, view)
+	case len(parts) == 2 && parts[0] == "leaderboard":
+		limit := leaderboardSize
+		if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n > 0 {
+			limit = n
+		}
+		if limit > leaderboardMax {
+			limit = leaderboardMax
+		}
+		writeJSON(w, Leaderboard(parts[1], limit))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// MemoryStats keeps profiles until the server stops, for tests and trying things out
+type MemoryStats struct {
+	sync.Mutex
+	profiles map[string][]byte
+}
+
+func NewMemoryStats() *MemoryStats {
+	return &MemoryStats{profiles: map[string][]byte{}}
+}
+
+func (m *MemoryStats) SaveProfile(p *Profile) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.profiles[p.PlayerId] = b
+	return nil
+}
+
+func (m *MemoryStats) LoadProfiles() ([]*Profile, error) {
+	m.Lock()
+	defer m.Unlock()
+	var list []*Profile
+	for _, b := range m.profiles {
+		p := &Profile{}
+		if err := json.Unmarshal(b, p); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// profilePath names the file after the public id, so the cookie isn't in the file name
+func (s *FileStore) profilePath(playerId string) string {
+	return filepath.Join(s.dir, "profiles", publicId(playerId)+".json")
+}
+
+func (s *FileStore) SaveProfile(p *Profile) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	path := s.profilePath(p.PlayerId)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) LoadProfiles() ([]*Profile, error) {
+	s.Lock()
+	defer s.Unlock()
+	paths, err := filepath.Glob(filepath.Join(s.dir, "profiles", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var list []*Profile
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		p := &Profile{}
+		if err = json.Unmarshal(b, p); err != nil {
+			log.Println("Skipping corrupt profile", path, err)
+			continue
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
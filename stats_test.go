@@ -0,0 +1,107 @@
+package wg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func cleanProfiles() {
+	profiles = &profileList{all: map[string]*Profile{}, public: map[string]*Profile{}}
+}
+
+func TestRate(t *testing.T) {
+	results := []Result{{PlayerId: "a", Won: true}, {PlayerId: "b"}}
+	changes := rate([]float64{ratingStart, ratingStart}, results)
+	if changes[0] != ratingK/2 || changes[1] != -ratingK/2 {
+		t.Error("Expected an even game to move half of K", changes)
+	}
+	changes = rate([]float64{ratingStart + 400, ratingStart}, results)
+	if changes[0] <= 0 || changes[0] >= ratingK/2 {
+		t.Error("Expected the favourite to gain less for winning", changes)
+	}
+
+	// two teams of two, teammates draw with each other
+	teams := []Result{{Won: true}, {Won: true}, {}, {}}
+	changes = rate([]float64{1500, 1500, 1500, 1500}, teams)
+	if changes[0] != changes[1] || changes[2] != changes[3] || changes[0] != -changes[2] || changes[0] <= 0 {
+		t.Error("Expected each side to move together", changes)
+	}
+}
+
+func TestStats(t *testing.T) {
+	// other tests finish games too
+	cleanProfiles()
+	defer cleanProfiles()
+	defer func() { StatsStorage = nil }()
+	store := NewMemoryStats()
+	StatsStorage = store
+
+	game := newScored("stats")
+	game.Start()
+	defer game.Stop()
+	game.Cmd <- &Command{PlayerId: "a", Ws: NewFakeConn("a"), Type: cmdJoin}
+	game.Cmd <- &Command{PlayerId: "b", Ws: NewFakeConn("b"), Type: cmdJoin}
+	game.Cmd <- &Command{PlayerId: "a", Ws: NewFakeConn("a"), Type: "score", Data: []byte("3")}
+	game.Cmd <- &Command{PlayerId: "b", Ws: NewFakeConn("b"), Type: "score", Data: []byte("1")}
+	game.Idle()
+
+	profiles.record("scored", []Result{
+		{PlayerId: "a", Name: "Alice", Score: 5, Won: true, Role: "spy", Counts: map[string]int{"sets": 2}},
+		{PlayerId: "b", Name: "Bob", Score: 1, Role: "resistance", Counts: map[string]int{"sets": 1}},
+	})
+
+	profiles.record("scored", MarkWinners([]Result{{PlayerId: "solo", Score: 9}}))
+	if PlayerProfile(publicId("solo")) != nil {
+		t.Error("Expected a game played alone not to count")
+	}
+
+	board := Leaderboard("scored", 10)
+	if len(board) != 2 || board[0].Name != "Alice" || board[0].Wins != 2 || board[0].Rating <= board[1].Rating {
+		t.Fatal("Expected Alice to top the leaderboard", board)
+	}
+	alice := PlayerProfile(board[0].Id).Games["scored"]
+	if alice.Played != 2 || alice.Average != 4 || alice.Roles["spy"].Wins != 1 || alice.Counts["sets"] != 2 {
+		t.Error("Expected Alice's games to add up", alice)
+	}
+	if len(Leaderboard("scored", 1)) != 1 || len(Leaderboard("other", 10)) != 0 {
+		t.Error("Expected leaderboards to be cut and kept by game type")
+	}
+
+	// a restart loads them back
+	cleanProfiles()
+	if err := RestoreStats(store); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(StatsAPI))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/stats/me", nil)
+	req.AddCookie(PlayerCookie("b"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var me ProfileView
+	json.NewDecoder(resp.Body).Decode(&me)
+	if me.Name != "Bob" || me.Id != board[1].Id || me.Games["scored"].Played != 2 {
+		t.Error("Expected Bob's own profile", me)
+	}
+
+	resp, err = http.Get(server.URL + "/stats/leaderboard/scored?n=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var top []LeaderboardEntry
+	json.NewDecoder(resp.Body).Decode(&top)
+	if len(top) != 1 || top[0].Name != "Alice" {
+		t.Error("Expected the top of the leaderboard", top)
+	}
+
+	if resp, _ = http.Get(server.URL + "/stats/players/nobody"); resp.StatusCode != http.StatusNotFound {
+		t.Error("Expected unknown players to be not found", resp.StatusCode)
+	}
+}
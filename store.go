@@ -0,0 +1,226 @@
+package wg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is where games are snapshotted after every command, nil means games only live in memory
+var Storage Store
+
+// Store persists game snapshots so games survive a server restart
+type Store interface {
+	Save(snap *Snapshot) error
+	Load() ([]*Snapshot, error)
+	Delete(id string) error
+}
+
+// Snapshot is everything needed to bring a game back to life
+type Snapshot struct {
+	Id      string
+	Type    string
+	Version int
+	Created time.Time
+	Updated time.Time
+	Players []string // player cookies, used to rebuild the player->game index
+	State   json.RawMessage
+	Chat    []*ChatMsg
+	Public  bool
+
+	Host     string
+	Password string
+	Locked   bool
+	Banned   []string
+	Timers   map[string]int
+}
+
+// Stateful is implemented by games that can be snapshotted
+type Stateful interface {
+	// MarshalState returns the game specific state, including anything private
+	MarshalState() (json.RawMessage, error)
+}
+
+// Restorer rebuilds a running game from a snapshot
+type Restorer func(snap *Snapshot) (*Game, error)
+
+var restorers = map[string]Restorer{}
+
+// RegisterRestorer tells wg how to bring back games of a type, games do this in init
+func RegisterRestorer(gameType string, restore Restorer) {
+	restorers[gameType] = restore
+}
+
+// Save snapshots the game to Storage, it should be called from the game's goroutine
+func (g *Game) Save() {
+	if Storage == nil {
+		return
+	}
+	if _, ok := g.Rules.(Stateful); !ok {
+		return
+	}
+	snap, err := g.snapshot()
+	if err != nil {
+		log.Println("Failed to snapshot game", g.Id, err)
+		return
+	}
+	if err = Storage.Save(snap); err != nil {
+		log.Println("Failed to save game", g.Id, err)
+	}
+}
+
+// snapshot captures the game, State is left empty for games that aren't Stateful
+func (g *Game) snapshot() (*Snapshot, error) {
+	var state json.RawMessage
+	if stateful, ok := g.Rules.(Stateful); ok {
+		var err error
+		if state, err = stateful.MarshalState(); err != nil {
+			return nil, err
+		}
+	}
+	snap := &Snapshot{
+		Id:      g.Id,
+		Type:    g.Type,
+		Version: g.Version,
+		Created: g.Created,
+		Updated: g.Updated,
+		Players: g.playerIds(),
+		State:   state,
+		Chat:    g.scrollback,
+		Public:  g.Public,
+
+		Host:     g.host,
+		Password: g.password,
+		Locked:   g.Locked,
+		Timers:   g.Timers,
+	}
+	for banned := range g.banned {
+		snap.Banned = append(snap.Banned, banned)
+	}
+	return snap, nil
+}
+
+// RestoreGame is like NewGame but picks up the id, version and times of a snapshot
+func RestoreGame(rules Rules, snap *Snapshot) *Game {
+	g := NewGame(rules, snap.Id)
+	g.restored = true
+	g.Type = snap.Type
+	g.Version = snap.Version
+	g.Created = snap.Created
+	g.Updated = snap.Updated
+	g.scrollback = snap.Chat
+	g.Public = snap.Public
+	g.host = snap.Host
+	g.password = snap.Password
+	g.Locked = snap.Locked
+	g.Timers = snap.Timers
+	g.banned = map[string]bool{}
+	for _, banned := range snap.Banned {
+		g.banned[banned] = true
+	}
+	return g
+}
+
+func (g *Game) playerIds() []string {
+	var ids []string
+	for _, p := range g.Rules.Roster() {
+		ids = append(ids, p.Uuid)
+	}
+	return ids
+}
+
+// Restore loads every snapshot in the store and starts the games back up
+func (g *Games) Restore(store Store) error {
+	snaps, err := store.Load()
+	if err != nil {
+		return err
+	}
+	for _, snap := range snaps {
+		restore, ok := restorers[snap.Type]
+		if !ok {
+			log.Println("No restorer for game", snap.Id, "of type", snap.Type)
+			continue
+		}
+		game, err := restore(snap)
+		if err != nil {
+			log.Println("Failed to restore game", snap.Id, err)
+			continue
+		}
+		g.Set(game, snap.Players...)
+		log.Println("Restored game", snap.Id, "with", len(snap.Players), "players")
+	}
+	return nil
+}
+
+// FileStore keeps one JSON file per game in a directory
+type FileStore struct {
+	sync.Mutex
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *FileStore) Save(snap *Snapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	// write then rename so a crash mid-write never leaves a corrupt snapshot
+	tmp := s.path(snap.Id) + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(snap.Id))
+}
+
+func (s *FileStore) Load() ([]*Snapshot, error) {
+	s.Lock()
+	defer s.Unlock()
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var snaps []*Snapshot
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(s.dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		snap := &Snapshot{}
+		if err = json.Unmarshal(b, snap); err != nil {
+			log.Println("Skipping corrupt snapshot", f.Name(), err)
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
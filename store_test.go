@@ -0,0 +1,58 @@
+package wg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := &Snapshot{Id: "123456", Type: "test", Version: 3, Players: []string{"a", "b"}, State: json.RawMessage(`{"Hello":"world"}`)}
+	if err = store.Save(snap); err != nil {
+		t.Fatal(err)
+	}
+
+	snaps, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 {
+		t.Fatal("Expected 1 snapshot got", len(snaps))
+	}
+	if snaps[0].Id != "123456" || snaps[0].Version != 3 || string(snaps[0].State) != `{"Hello":"world"}` {
+		t.Error("Snapshot didn't survive the round trip", snaps[0])
+	}
+
+	RegisterRestorer("test", func(snap *Snapshot) (*Game, error) {
+		return RestoreGame(nil, snap), nil
+	})
+	games := NewGames()
+	if err = games.Restore(store); err != nil {
+		t.Fatal(err)
+	}
+	if games.Get("123456") == nil {
+		t.Fatal("Game wasn't restored")
+	}
+	if games.Find("b") == nil || games.Find("b").Version != 3 {
+		t.Error("Player index wasn't restored")
+	}
+
+	if err = store.Delete("123456"); err != nil {
+		t.Fatal(err)
+	}
+	if snaps, _ = store.Load(); len(snaps) != 0 {
+		t.Error("Expected snapshot to be deleted")
+	}
+}
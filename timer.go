@@ -0,0 +1,174 @@
+package wg
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// timerMax is the longest the host can set a phase timer to, in seconds
+const timerMax = 60 * 60
+
+// Timed is implemented by games with phases that shouldn't be able to stall the room
+type Timed interface {
+	// Timers are the default seconds for each phase, 0 means the phase isn't timed
+	Timers() map[string]int
+	// Timeout makes the default move for a phase that ran out of time, return true to send everyone the new state
+	Timeout(phase string) bool
+}
+
+// Clock is where a game gets the time and its timers from
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a call waiting on a Clock
+type Timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// timeout is the data of the command injected when a timer goes off
+type timeout struct {
+	Phase string
+	Seq   int
+}
+
+// Arm starts the timer for a phase, arming the phase that is already running does nothing.
+// When time runs out the game's Timeout is called from the game loop like any other command.
+func (g *Game) Arm(phase string) {
+	if phase != "" && g.phase == phase {
+		return
+	}
+	g.Disarm()
+	length := g.timerLength(phase)
+	if length <= 0 {
+		return
+	}
+	g.phase = phase
+	g.timerSeq++
+	g.deadline = g.clock.Now().Add(length)
+	data, _ := json.Marshal(&timeout{Phase: phase, Seq: g.timerSeq})
+	cmd := &Command{Type: cmdTimeout, Data: data}
+	if g.replaying {
+		// the timeout is in the event log
+		return
+	}
+	g.timer = g.clock.AfterFunc(length, func() {
+		g.Inject(cmd)
+	})
+}
+
+// Disarm stops the phase timer, games call this when the phase is over
+func (g *Game) Disarm() {
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+	g.phase = ""
+	g.deadline = time.Time{}
+}
+
+// Inject queues a command that comes from the server rather than a player, like a timer going off
+func (g *Game) Inject(cmd *Command) {
+	if g.replaying {
+		return
+	}
+	cmd.injected = true
+	atomic.AddInt32(&g.injected, 1)
+	go func() {
+		select {
+		case g.Cmd <- cmd:
+		case <-g.done:
+			atomic.AddInt32(&g.injected, -1)
+		}
+	}()
+}
+
+// timerLength is the room's setting for the phase if the host changed it, otherwise the game's default
+func (g *Game) timerLength(phase string) time.Duration {
+	seconds, ok := g.Timers[phase]
+	if !ok {
+		if timed, isTimed := g.Rules.(Timed); isTimed {
+			seconds = timed.Timers()[phase]
+		}
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// timeLeft is how long the current phase has in milliseconds, 0 when nothing is timed
+func (g *Game) timeLeft() int {
+	if g.phase == "" {
+		return 0
+	}
+	left := g.deadline.Sub(g.clock.Now())
+	if left < 0 {
+		return 0
+	}
+	return int(left / time.Millisecond)
+}
+
+func (g *Game) timeout(cmd *Command) bool {
+	var t timeout
+	if err := json.Unmarshal(cmd.Data, &t); err != nil {
+		log.Println("Bad timeout", err)
+		return false
+	}
+	if t.Seq != g.timerSeq || t.Phase != g.phase {
+		// the phase ended before the timer did
+		return false
+	}
+	g.timer = nil
+	g.phase = ""
+	g.deadline = time.Time{}
+	timed, ok := g.Rules.(Timed)
+	if !ok {
+		return false
+	}
+	log.Println("Time ran out for", t.Phase, "in game", g.Id)
+	return timed.Timeout(t.Phase)
+}
+
+// setTimers is the host changing how long phases last, in seconds
+func (g *Game) setTimers(cmd *Command) bool {
+	timed, ok := g.Rules.(Timed)
+	if !ok {
+		cmd.SendMsg("This game has no timers")
+		return false
+	}
+	var timers map[string]int
+	if err := json.Unmarshal(cmd.Data, &timers); err != nil {
+		cmd.SendError(ErrBadData, "Got invalid data for timers")
+		return false
+	}
+	defaults := timed.Timers()
+	for phase, seconds := range timers {
+		if _, ok := defaults[phase]; !ok {
+			cmd.SendError(ErrNotFound, "There is no timer for "+phase)
+			return false
+		}
+		if seconds < 0 || seconds > timerMax {
+			cmd.SendError(ErrInvalid, "Timers have to be between 0 and 3600 seconds")
+			return false
+		}
+	}
+	if g.Timers == nil {
+		g.Timers = map[string]int{}
+	}
+	for phase, seconds := range timers {
+		g.Timers[phase] = seconds
+	}
+	return true
+}
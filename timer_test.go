@@ -0,0 +1,75 @@
+package wg
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type timedRules struct {
+	testRules
+	timedOut []string
+}
+
+func (r *timedRules) Timers() map[string]int {
+	return map[string]int{"slow": 60}
+}
+
+func (r *timedRules) Timeout(phase string) bool {
+	r.timedOut = append(r.timedOut, phase)
+	return true
+}
+
+func TestTimer(t *testing.T) {
+	rules := &timedRules{}
+	game := NewGame(rules, "1")
+	game.handle(&Command{PlayerId: "a", Ws: NewFakeConn("a"), Type: cmdJoin})
+
+	game.Arm("slow")
+	seq := game.timerSeq
+	if left := game.timeLeft(); left <= 59000 || left > 60000 {
+		t.Error("Expected about a minute left", left)
+	}
+	game.Arm("slow")
+	if game.timerSeq != seq {
+		t.Error("Arming the running phase shouldn't restart it")
+	}
+
+	data, _ := json.Marshal(&timeout{Phase: "slow", Seq: seq})
+	if game.handle(&Command{PlayerId: "a", Type: cmdTimeout, Data: data}) {
+		t.Error("Players can't send timeouts")
+	}
+	stale, _ := json.Marshal(&timeout{Phase: "slow", Seq: seq - 1})
+	if game.handle(&Command{Type: cmdTimeout, Data: stale}) {
+		t.Error("Old timers should be ignored")
+	}
+	if !game.handle(&Command{Type: cmdTimeout, Data: data}) || len(rules.timedOut) != 1 || game.timeLeft() != 0 {
+		t.Error("Expected the game to time out", rules.timedOut)
+	}
+	if game.handle(&Command{Type: cmdTimeout, Data: data}) {
+		t.Error("A timer only goes off once")
+	}
+
+	game.Arm("slow")
+	game.Disarm()
+	data, _ = json.Marshal(&timeout{Phase: "slow", Seq: game.timerSeq})
+	if game.handle(&Command{Type: cmdTimeout, Data: data}) {
+		t.Error("Disarmed timers shouldn't go off")
+	}
+
+	if game.handle(&Command{PlayerId: "a", Type: cmdTimers, Data: []byte(`{"fast":10}`)}) {
+		t.Error("Only phases the game has can be set")
+	}
+	if !game.handle(&Command{PlayerId: "a", Type: cmdTimers, Data: []byte(`{"slow":0}`)}) {
+		t.Fatal("Host should be able to change timers")
+	}
+	game.Arm("slow")
+	if game.phase != "" {
+		t.Error("A phase set to 0 isn't timed")
+	}
+
+	cmd := &Command{Type: cmdTimeout}
+	game.Inject(cmd)
+	if <-game.Cmd != cmd {
+		t.Error("Expected injected command to be queued")
+	}
+}
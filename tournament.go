@@ -0,0 +1,890 @@
+package wg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A tournament seats its players at tables, which are ordinary rooms, and moves them on to the next round once every
+// table has finished, which the games at them say by calling Finish.
+
+const (
+	// FormatBracket knocks out everyone but the best at each table until one table is left
+	FormatBracket = "bracket"
+	// FormatRoundRobin plays a number of rounds, seating everyone with different players each time
+	FormatRoundRobin = "roundrobin"
+)
+
+const (
+	TournamentOpen     = "open" // taking registrations
+	TournamentPlaying  = "playing"
+	TournamentFinished = "finished"
+)
+
+const (
+	// tournamentMaxBody is the biggest request body the tournament API reads
+	tournamentMaxBody = 4 * 1024
+	// tournamentMaxTable is the most players one table can seat
+	tournamentMaxTable = 16
+	// tournamentMaxName is the longest tournament or player name
+	tournamentMaxName = 32
+)
+
+var (
+	ErrTournamentHost    = errors.New("Only the host can do that")
+	ErrTournamentStarted = errors.New("The tournament has already started")
+	ErrTournamentPlaying = errors.New("The tournament isn't being played")
+	ErrTournamentPlayers = errors.New("A tournament needs at least 2 players")
+)
+
+// TournamentStorage keeps tournaments across restarts, nil means they only live in memory
+var TournamentStorage TournamentStore
+
+// TournamentStore persists tournaments, FileStore is one
+type TournamentStore interface {
+	SaveTournament(t *Tournament) error
+	LoadTournaments() ([]*Tournament, error)
+}
+
+// Tournament is a series of games of one type, see Format for how players move between tables
+type Tournament struct {
+	mu sync.Mutex
+
+	Id        string
+	Name      string
+	Type      string // the game every table plays
+	Format    string
+	TableSize int    // the most players at a table, fewer fuller tables are used when the game needs more
+	Rounds    int    // how many rounds a round robin lasts, 0 is enough for everyone to have played everyone
+	Advance   int    // how many from each bracket table go through to the next round
+	Host      string // cookie of the player who made it
+	State     string
+	Created   time.Time
+
+	Entrants []*Entrant
+	Tables   [][]*Table // the tables of every round so far, the last is the round being played
+}
+
+// Entrant is a registered player and how they're doing
+type Entrant struct {
+	PlayerId string
+	Name     string
+	Played   int
+	Wins     int
+	Score    int
+	Out      int // the round a bracket knocked them out in, 0 while they're still in
+}
+
+// Table is one room in a round
+type Table struct {
+	Room    string // empty for a bye
+	Players []string
+	Results []Result
+	Done    bool
+}
+
+// TournamentRequest is the body of POST /tournaments
+type TournamentRequest struct {
+	Name      string
+	Type      string
+	Format    string
+	TableSize int
+	Rounds    int
+	Advance   int
+}
+
+// EntryRequest is the body of POST /tournaments/{id}/register
+type EntryRequest struct {
+	Name string
+}
+
+// TournamentInfo is what the tournament list shows
+type TournamentInfo struct {
+	Id       string
+	Name     string
+	Type     string
+	Format   string
+	State    string
+	Round    int
+	Entrants int
+}
+
+// TournamentView is a tournament as the API shows it, players are only known by name
+type TournamentView struct {
+	TournamentInfo
+	Host      bool   // the player asking is the host
+	Room      string `json:",omitempty"` // where the player asking is seated this round
+	Standings []Standing
+	Tables    []TableView // this round's
+}
+
+// Standing is one line of the standings, best first
+type Standing struct {
+	Name   string
+	Played int
+	Wins   int
+	Score  int
+	Out    int `json:",omitempty"`
+}
+
+// TableView is a table as the API shows it
+type TableView struct {
+	Room    string `json:",omitempty"`
+	Players []string
+	Done    bool
+}
+
+// tournamentList finds tournaments by id, and by the rooms of the tables still being played
+type tournamentList struct {
+	sync.Mutex
+	all   map[string]*Tournament
+	rooms map[string]*Tournament
+}
+
+var tournaments = &tournamentList{all: map[string]*Tournament{}, rooms: map[string]*Tournament{}}
+
+func (l *tournamentList) add(t *Tournament) {
+	l.Lock()
+	defer l.Unlock()
+	l.all[t.Id] = t
+}
+
+func (l *tournamentList) get(id string) *Tournament {
+	l.Lock()
+	defer l.Unlock()
+	return l.all[id]
+}
+
+func (l *tournamentList) list() []*Tournament {
+	l.Lock()
+	defer l.Unlock()
+	var list []*Tournament
+	for _, t := range l.all {
+		list = append(list, t)
+	}
+	return list
+}
+
+func (l *tournamentList) seat(room string, t *Tournament) {
+	l.Lock()
+	defer l.Unlock()
+	l.rooms[room] = t
+}
+
+func (l *tournamentList) unseat(room string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.rooms, room)
+}
+
+func (l *tournamentList) table(room string) *Tournament {
+	l.Lock()
+	defer l.Unlock()
+	return l.rooms[room]
+}
+
+// finished records the results of a table, returning what to tell the players at it
+func (l *tournamentList) finished(room string, results []Result) string {
+	t := l.table(room)
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	table := t.table(room)
+	if table == nil || table.Done {
+		return ""
+	}
+	t.record(table, results)
+	if !t.roundDone() {
+		t.save()
+		return "Waiting for the other tables to finish"
+	}
+	t.endRound()
+	t.save()
+	if t.State == TournamentFinished {
+		return fmt.Sprintf("The tournament is over, %v won", t.standings()[0].Name)
+	}
+	return ""
+}
+
+// seated reports whether a player may join the room, anyone can join rooms that aren't tournament tables
+func (l *tournamentList) seated(room, playerId string) bool {
+	t := l.table(room)
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	table := t.table(room)
+	if table == nil {
+		return true
+	}
+	for _, pid := range table.Players {
+		if pid == playerId {
+			return true
+		}
+	}
+	return false
+}
+
+// NewTournament checks the settings and opens a tournament for registration, host is the cookie of whoever made it
+func NewTournament(req *TournamentRequest, host string) (*Tournament, error) {
+	t := &Tournament{
+		Id:        GenId(),
+		Name:      trimName(req.Name, "Tournament"),
+		Type:      req.Type,
+		Format:    req.Format,
+		TableSize: req.TableSize,
+		Rounds:    req.Rounds,
+		Advance:   req.Advance,
+		Host:      host,
+		State:     TournamentOpen,
+		Created:   time.Now(),
+	}
+	if builders[t.Type] == nil {
+		return nil, fmt.Errorf("Unknown game type %q", t.Type)
+	}
+	if !reporting[t.Type] {
+		return nil, fmt.Errorf("%v games don't report who won, so they can't be played in a tournament", t.Type)
+	}
+	if t.Format == "" {
+		t.Format = FormatRoundRobin
+	}
+	if t.Format != FormatRoundRobin && t.Format != FormatBracket {
+		return nil, fmt.Errorf("Unknown format %q", t.Format)
+	}
+	min, max := tableSeats(t.Type)
+	if t.TableSize == 0 {
+		t.TableSize = min
+	}
+	if t.TableSize < min || t.TableSize > max {
+		return nil, fmt.Errorf("Tables seat %v to %v players", min, max)
+	}
+	if t.Advance == 0 {
+		t.Advance = 1
+	}
+	if t.Advance < 1 || t.Advance >= t.TableSize {
+		return nil, errors.New("Fewer players than sit at a table have to go through")
+	}
+	if t.Rounds < 0 || t.Rounds > 100 {
+		return nil, errors.New("A round robin lasts 100 rounds at most")
+	}
+	tournaments.add(t)
+	t.mu.Lock()
+	t.save()
+	t.mu.Unlock()
+	return t, nil
+}
+
+// GetTournament finds a tournament, nil if there isn't one with the id
+func GetTournament(id string) *Tournament {
+	return tournaments.get(id)
+}
+
+// RestoreTournaments loads the stored tournaments, after the games so the tables being played are still there
+func RestoreTournaments(store TournamentStore) error {
+	list, err := store.LoadTournaments()
+	if err != nil {
+		return err
+	}
+	for _, t := range list {
+		tournaments.add(t)
+		for _, table := range t.round() {
+			if table.Room != "" && !table.Done {
+				tournaments.seat(table.Room, t)
+			}
+		}
+	}
+	log.Println("Restored", len(list), "tournaments")
+	return nil
+}
+
+// Register signs a player up, registering again changes their name
+func (t *Tournament) Register(playerId, name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	name = trimName(name, fmt.Sprint("Player ", len(t.Entrants)+1))
+	if e := t.entrant(playerId); e != nil {
+		e.Name = name
+		t.save()
+		return nil
+	}
+	if t.State != TournamentOpen {
+		return ErrTournamentStarted
+	}
+	t.Entrants = append(t.Entrants, &Entrant{PlayerId: playerId, Name: name})
+	t.save()
+	return nil
+}
+
+// Start seats the players for the first round, only the host can start it
+func (t *Tournament) Start(playerId string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if playerId != t.Host {
+		return ErrTournamentHost
+	}
+	if t.State != TournamentOpen {
+		return ErrTournamentStarted
+	}
+	if min, _ := tableSeats(t.Type); len(t.Entrants) < min {
+		if min == 2 {
+			return ErrTournamentPlayers
+		}
+		return fmt.Errorf("A tournament needs at least %v players", min)
+	}
+	if t.Rounds == 0 {
+		t.Rounds = t.everyone()
+	}
+	t.State = TournamentPlaying
+	t.nextRound()
+	t.save()
+	return nil
+}
+
+// EndRound stops waiting for the tables that haven't finished, their players get nothing for the round
+func (t *Tournament) EndRound(playerId string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if playerId != t.Host {
+		return ErrTournamentHost
+	}
+	if t.State != TournamentPlaying {
+		return ErrTournamentPlaying
+	}
+	for _, table := range t.round() {
+		if !table.Done {
+			t.record(table, nil)
+		}
+	}
+	t.endRound()
+	t.save()
+	return nil
+}
+
+// View is the tournament as the player with the cookie sees it
+func (t *Tournament) View(playerId string) *TournamentView {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	view := &TournamentView{TournamentInfo: t.info(), Host: playerId != "" && playerId == t.Host}
+	for _, e := range t.standings() {
+		view.Standings = append(view.Standings, Standing{Name: e.Name, Played: e.Played, Wins: e.Wins, Score: e.Score, Out: e.Out})
+	}
+	for _, table := range t.round() {
+		tv := TableView{Room: table.Room, Done: table.Done}
+		for _, pid := range table.Players {
+			tv.Players = append(tv.Players, t.entrant(pid).Name)
+			if pid == playerId && !table.Done {
+				view.Room = table.Room
+			}
+		}
+		view.Tables = append(view.Tables, tv)
+	}
+	return view
+}
+
+func (t *Tournament) info() TournamentInfo {
+	return TournamentInfo{
+		Id:       t.Id,
+		Name:     t.Name,
+		Type:     t.Type,
+		Format:   t.Format,
+		State:    t.State,
+		Round:    len(t.Tables),
+		Entrants: len(t.Entrants),
+	}
+}
+
+func (t *Tournament) entrant(playerId string) *Entrant {
+	for _, e := range t.Entrants {
+		if e.PlayerId == playerId {
+			return e
+		}
+	}
+	return nil
+}
+
+// round is the tables of the round being played
+func (t *Tournament) round() []*Table {
+	if len(t.Tables) == 0 {
+		return nil
+	}
+	return t.Tables[len(t.Tables)-1]
+}
+
+func (t *Tournament) table(room string) *Table {
+	for _, table := range t.round() {
+		if table.Room == room {
+			return table
+		}
+	}
+	return nil
+}
+
+func (t *Tournament) roundDone() bool {
+	for _, table := range t.round() {
+		if !table.Done {
+			return false
+		}
+	}
+	return true
+}
+
+// record adds a table's results to the standings
+func (t *Tournament) record(table *Table, results []Result) {
+	table.Results = results
+	table.Done = true
+	tournaments.unseat(table.Room)
+	for _, pid := range table.Players {
+		e := t.entrant(pid)
+		e.Played++
+		for _, r := range results {
+			if r.PlayerId != pid {
+				continue
+			}
+			e.Score += r.Score
+			if r.Won {
+				e.Wins++
+			}
+		}
+	}
+}
+
+// endRound knocks players out of a bracket and seats the next round, or finishes the tournament
+func (t *Tournament) endRound() {
+	round := len(t.Tables)
+	tables := 0
+	for _, table := range t.round() {
+		if table.Room == "" {
+			continue
+		}
+		tables++
+		if t.Format == FormatBracket {
+			// someone always goes out so the bracket gets smaller, even at tables short of players
+			keep := t.Advance
+			if keep >= len(table.Players) {
+				keep = len(table.Players) - 1
+			}
+			for _, pid := range rank(table.Players, table.Results)[keep:] {
+				t.entrant(pid).Out = round
+			}
+		}
+	}
+	over := round >= t.Rounds
+	if t.Format == FormatBracket {
+		over = tables <= 1 || len(t.active()) < 2
+	}
+	if over {
+		log.Println("Tournament", t.Id, "finished")
+		t.State = TournamentFinished
+		return
+	}
+	t.nextRound()
+}
+
+// nextRound seats the players at new tables
+func (t *Tournament) nextRound() {
+	var groups [][]string
+	if t.Format == FormatBracket {
+		groups = t.bracketTables()
+	} else {
+		groups = t.robinTables(len(t.Tables))
+	}
+	min, _ := tableSeats(t.Type)
+	var tables []*Table
+	for _, players := range groups {
+		if len(players) < min {
+			// too few for a game, they sit this round out
+			tables = append(tables, &Table{Players: players, Done: true})
+			continue
+		}
+		tables = append(tables, t.open(players))
+	}
+	t.Tables = append(t.Tables, tables)
+	log.Println("Tournament", t.Id, "round", len(t.Tables), "has", len(tables), "tables")
+	if t.roundDone() {
+		// every table was a bye
+		t.endRound()
+	}
+}
+
+// open makes the room for a table, the players find it by rejoining
+func (t *Tournament) open(players []string) *Table {
+	game := builders[t.Type](GenId())
+	game.Start()
+
+	// tell everyone where to go from wherever they are now
+	notified := map[*Game]bool{}
+	data, _ := json.Marshal(fmt.Sprintf("Round %v of %v is ready, rejoin to take your seat", len(t.Tables)+1, t.Name))
+	for _, pid := range players {
+		if old := AllGames.Find(pid); old != nil && !notified[old] {
+			notified[old] = true
+			go old.send(&Command{Type: cmdAnnounce, Data: data})
+		}
+	}
+
+	AllGames.Set(game, players...)
+	tournaments.seat(game.Id, t)
+	return &Table{Room: game.Id, Players: players}
+}
+
+// active is who's still in, best first
+func (t *Tournament) active() []string {
+	var ids []string
+	for _, e := range t.standings() {
+		if e.Out == 0 {
+			ids = append(ids, e.PlayerId)
+		}
+	}
+	return ids
+}
+
+// bracketTables spreads the players still in over as few tables as fit them, snaking so the best are kept apart
+func (t *Tournament) bracketTables() [][]string {
+	players := t.active()
+	groups := make([][]string, t.tableCount(len(players)))
+	for i, pid := range players {
+		row, col := i/len(groups), i%len(groups)
+		if row%2 == 1 {
+			col = len(groups) - 1 - col
+		}
+		groups[col] = append(groups[col], pid)
+	}
+	return groups
+}
+
+// robinTables rotates everyone but the first player around a circle a place each round. With two to a table that
+// pairs everyone with everyone else once, larger tables are cut from the circle.
+func (t *Tournament) robinTables(round int) [][]string {
+	var players []string
+	for _, e := range t.Entrants {
+		players = append(players, e.PlayerId)
+	}
+	if t.TableSize == 2 && len(players)%2 == 1 {
+		// whoever meets the empty seat has a bye
+		players = append(players, "")
+	}
+	rest := players[1:]
+	circle := []string{players[0]}
+	for i := range rest {
+		circle = append(circle, rest[(i+len(rest)-round%len(rest))%len(rest)])
+	}
+
+	var groups [][]string
+	if t.TableSize == 2 {
+		for i := 0; i < len(circle)/2; i++ {
+			groups = append(groups, seated(circle[i], circle[len(circle)-1-i]))
+		}
+		return groups
+	}
+	count := t.tableCount(len(circle))
+	for i := 0; i < count; i++ {
+		groups = append(groups, seated(circle[i*len(circle)/count:(i+1)*len(circle)/count]...))
+	}
+	return groups
+}
+
+// tableSeats is how many players a table of the game type can seat, what matchmaking says when the type has it
+func tableSeats(gameType string) (min, max int) {
+	min, max = 2, tournamentMaxTable
+	if mm, ok := matchmaking[gameType]; ok {
+		if mm.Min > min {
+			min = mm.Min
+		}
+		if mm.Max > 0 && mm.Max < max {
+			max = mm.Max
+		}
+	}
+	return min, max
+}
+
+// tableCount is how many tables n players are spread over. Tables are kept to TableSize, unless that leaves some
+// short of the game's minimum, then fewer fuller tables are used as long as the game takes that many.
+func (t *Tournament) tableCount(n int) int {
+	min, max := tableSeats(t.Type)
+	count := (n + t.TableSize - 1) / t.TableSize
+	if fit := n / min; fit < count {
+		count = fit
+	}
+	if most := (n + max - 1) / max; most > count {
+		count = most
+	}
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
+
+// everyone is how many rounds it takes a round robin to seat everyone with everyone else
+func (t *Tournament) everyone() int {
+	n := len(t.Entrants)
+	if t.TableSize == 2 && n%2 == 1 {
+		n++
+	}
+	return (n - 1 + t.TableSize - 2) / (t.TableSize - 1)
+}
+
+// standings are the entrants best first: still in, then most wins, then most points
+func (t *Tournament) standings() []*Entrant {
+	list := append([]*Entrant(nil), t.Entrants...)
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if (a.Out == 0) != (b.Out == 0) {
+			return a.Out == 0
+		}
+		if a.Out != b.Out {
+			return a.Out > b.Out
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		return a.Score > b.Score
+	})
+	return list
+}
+
+func (t *Tournament) save() {
+	if TournamentStorage == nil {
+		return
+	}
+	if err := TournamentStorage.SaveTournament(t); err != nil {
+		log.Println("Failed to save tournament", t.Id, err)
+	}
+}
+
+// rank orders the players at a table by how they did, anyone without a result comes last
+func rank(players []string, results []Result) []string {
+	byPlayer := map[string]Result{}
+	for _, r := range results {
+		byPlayer[r.PlayerId] = r
+	}
+	ranked := append([]string(nil), players...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, aok := byPlayer[ranked[i]]
+		b, bok := byPlayer[ranked[j]]
+		if aok != bok {
+			return aok
+		}
+		if a.Won != b.Won {
+			return a.Won
+		}
+		return a.Score > b.Score
+	})
+	return ranked
+}
+
+// seated drops the empty seat from a table
+func seated(players ...string) []string {
+	var ids []string
+	for _, pid := range players {
+		if pid != "" {
+			ids = append(ids, pid)
+		}
+	}
+	return ids
+}
+
+func trimName(name, fallback string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fallback
+	}
+	return truncate(name, tournamentMaxName)
+}
+
+// TournamentAPI serves tournaments, players are who their cookie says:
+//
+//	GET  /tournaments                    lists the tournaments
+//	POST /tournaments                    makes one, the player making it is its host
+//	GET  /tournaments/{id}               the standings and this round's tables
+//	POST /tournaments/{id}/register      signs the player up
+//	POST /tournaments/{id}/start         seats the first round, host only
+//	POST /tournaments/{id}/endround      stops waiting on tables that haven't finished, host only
+func TournamentAPI(w http.ResponseWriter, r *http.Request) {
+	playerId := requestPlayer(r)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tournaments"), "/")
+	parts := strings.Split(path, "/")
+
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			infos := []TournamentInfo{}
+			for _, t := range tournaments.list() {
+				t.mu.Lock()
+				infos = append(infos, t.info())
+				t.mu.Unlock()
+			}
+			sort.Slice(infos, func(i, j int) bool {
+				return infos[i].Id < infos[j].Id
+			})
+			writeJSON(w, infos)
+		case http.MethodPost:
+			if playerId == "" {
+				http.Error(w, "Play a game first so we know who you are", http.StatusUnauthorized)
+				return
+			}
+			var req TournamentRequest
+			if !readTournamentBody(w, r, &req) {
+				return
+			}
+			t, err := NewTournament(&req, playerId)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, t.View(playerId))
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
+
+	t := tournaments.get(parts[0])
+	if t == nil || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, t.View(playerId))
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if playerId == "" {
+		http.Error(w, "Play a game first so we know who you are", http.StatusUnauthorized)
+		return
+	}
+	var err error
+	switch parts[1] {
+	case "register":
+		var req EntryRequest
+		if !readTournamentBody(w, r, &req) {
+			return
+		}
+		err = t.Register(playerId, req.Name)
+	case "start":
+		err = t.Start(playerId)
+	case "endround":
+		err = t.EndRound(playerId)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	switch err {
+	case nil:
+		writeJSON(w, t.View(playerId))
+	case ErrTournamentHost:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
+}
+
+func readTournamentBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, tournamentMaxBody))
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// MemoryTournaments keeps tournaments until the server stops, for tests and trying things out
+type MemoryTournaments struct {
+	sync.Mutex
+	tournaments map[string][]byte
+}
+
+func NewMemoryTournaments() *MemoryTournaments {
+	return &MemoryTournaments{tournaments: map[string][]byte{}}
+}
+
+func (m *MemoryTournaments) SaveTournament(t *Tournament) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.tournaments[t.Id] = b
+	return nil
+}
+
+func (m *MemoryTournaments) LoadTournaments() ([]*Tournament, error) {
+	m.Lock()
+	defer m.Unlock()
+	var list []*Tournament
+	for _, b := range m.tournaments {
+		t := &Tournament{}
+		if err := json.Unmarshal(b, t); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
+
+func (s *FileStore) tournamentPath(id string) string {
+	return filepath.Join(s.dir, "tournaments", id+".json")
+}
+
+func (s *FileStore) SaveTournament(t *Tournament) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	path := s.tournamentPath(t.Id)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) LoadTournaments() ([]*Tournament, error) {
+	s.Lock()
+	defer s.Unlock()
+	paths, err := filepath.Glob(filepath.Join(s.dir, "tournaments", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var list []*Tournament
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		t := &Tournament{}
+		if err = json.Unmarshal(b, t); err != nil {
+			log.Println("Skipping corrupt tournament", path, err)
+			continue
+		}
+		list = append(list, t)
+	}
+	return list, nil
+}
@@ -0,0 +1,362 @@
+package wg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scoreRules finishes a game once everyone at the table has sent a score
+type scoreRules struct {
+	testRules
+	game   *Game
+	scores map[string]int
+}
+
+func (r *scoreRules) HandleCommand(cmd *Command) bool {
+	var score int
+	if err := json.Unmarshal(cmd.Data, &score); err != nil {
+		return false
+	}
+	r.scores[cmd.PlayerId] = score
+	if len(r.scores) == len(r.players) {
+		var results []Result
+		for pid, score := range r.scores {
+			results = append(results, Result{PlayerId: pid, Score: score})
+		}
+		r.game.Finish(MarkWinners(results))
+	}
+	return true
+}
+
+func newScored(id string, opts ...Option) *Game {
+	r := &scoreRules{scores: map[string]int{}}
+	r.game = NewGame(r, id, opts...)
+	r.game.Type = "scored"
+	return r.game
+}
+
+// playTables plays every unfinished table of the round, the players score what points says
+func playTables(t *testing.T, tourney *Tournament, points map[string]int) {
+	tourney.mu.Lock()
+	tables := append([]*Table(nil), tourney.round()...)
+	tourney.mu.Unlock()
+	for _, table := range tables {
+		if table.Room == "" {
+			continue
+		}
+		game := AllGames.Get(table.Room)
+		if game == nil {
+			t.Fatal("Expected a room for the table", table.Room)
+		}
+		for _, pid := range table.Players {
+			game.Cmd <- &Command{PlayerId: pid, Ws: NewFakeConn(pid), Type: cmdJoin}
+		}
+		for _, pid := range table.Players {
+			data, _ := json.Marshal(points[pid])
+			game.Cmd <- &Command{PlayerId: pid, Ws: NewFakeConn(pid), Type: "score", Data: data}
+		}
+		game.Idle()
+	}
+}
+
+func cleanTournaments() {
+	// swapped out first so removing a table doesn't forfeit it and seat another round
+	list := tournaments
+	tournaments = &tournamentList{all: map[string]*Tournament{}, rooms: map[string]*Tournament{}}
+	for _, t := range list.list() {
+		for _, round := range t.Tables {
+			for _, table := range round {
+				if game := AllGames.Get(table.Room); game != nil {
+					AllGames.remove(game)
+				}
+			}
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	RegisterBuilder("scored", newScored)
+	RegisterResults("scored")
+	defer cleanTournaments()
+
+	tourney, err := NewTournament(&TournamentRequest{Name: "Weekly", Type: "scored"}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []string{"a", "b", "c", "d", "e"} {
+		if err = tourney.Register(pid, strings.ToUpper(pid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tourney.Start("b"); err != ErrTournamentHost {
+		t.Error("Expected only the host to start it", err)
+	}
+	if err = tourney.Start("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tourney.Register("f", "F"); err != ErrTournamentStarted {
+		t.Error("Expected registration to be closed", err)
+	}
+
+	points := map[string]int{"a": 5, "b": 4, "c": 3, "d": 2, "e": 1}
+	met := map[string]int{}
+	for round := 1; round <= 5; round++ {
+		tourney.mu.Lock()
+		if len(tourney.Tables) != round {
+			t.Fatal("Expected round", round, "to be seated", len(tourney.Tables))
+		}
+		for _, table := range tourney.round() {
+			if len(table.Players) == 2 {
+				pair := append([]string(nil), table.Players...)
+				sort.Strings(pair)
+				met[strings.Join(pair, "")]++
+			}
+		}
+		tourney.mu.Unlock()
+		playTables(t, tourney, points)
+	}
+
+	view := tourney.View("a")
+	if view.State != TournamentFinished || !view.Host {
+		t.Fatal("Expected the tournament to be over", view.State)
+	}
+	if len(met) != 10 {
+		t.Error("Expected everyone to play everyone once", met)
+	}
+	for pair, n := range met {
+		if n != 1 {
+			t.Error("Expected pairs to meet once", pair, n)
+		}
+	}
+	if s := view.Standings[0]; s.Name != "A" || s.Wins != 4 || s.Played != 4 || s.Score != 20 {
+		t.Error("Expected A to win every game", view.Standings)
+	}
+	if s := view.Standings[4]; s.Name != "E" || s.Wins != 0 {
+		t.Error("Expected E to lose every game", view.Standings)
+	}
+}
+
+func TestBracket(t *testing.T) {
+	RegisterBuilder("scored", newScored)
+	RegisterResults("scored")
+	defer cleanTournaments()
+
+	tourney, err := NewTournament(&TournamentRequest{Type: "scored", Format: FormatBracket}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []string{"a", "b", "c", "d"} {
+		tourney.Register(pid, strings.ToUpper(pid))
+	}
+	if err = tourney.Start("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	room := tourney.View("a").Room
+	game := AllGames.Get(room)
+	if room == "" || AllGames.Find("a") != game {
+		t.Fatal("Expected a to be seated and sent there on rejoin", room)
+	}
+	stranger := NewFakeConn("z")
+	game.Cmd <- &Command{PlayerId: "z", Ws: stranger, Type: cmdJoin}
+	game.Idle()
+	if msg, ok := (<-stranger.Msgs).(*MsgMsg); !ok || msg.Code != ErrRefused {
+		t.Error("Expected someone not at the table to be turned away", msg)
+	}
+
+	points := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4}
+	playTables(t, tourney, points)
+	view := tourney.View("d")
+	if view.Round != 2 || len(view.Tables) != 1 || view.Room == "" {
+		t.Fatal("Expected the winners to meet in a final", view)
+	}
+	playTables(t, tourney, points)
+
+	view = tourney.View("")
+	if view.State != TournamentFinished || view.Standings[0].Name != "D" || view.Standings[1].Name != "C" {
+		t.Error("Expected D to win the final against C", view.Standings)
+	}
+	if view.Standings[3].Out != 1 {
+		t.Error("Expected the first round losers to go out in round 1", view.Standings)
+	}
+}
+
+func TestTournamentAPI(t *testing.T) {
+	RegisterBuilder("scored", newScored)
+	RegisterResults("scored")
+	defer cleanTournaments()
+	defer func() { TournamentStorage = nil }()
+	store := NewMemoryTournaments()
+	TournamentStorage = store
+
+	server := httptest.NewServer(http.HandlerFunc(TournamentAPI))
+	defer server.Close()
+	post := func(path, player, body string) (*http.Response, *TournamentView) {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+		if player != "" {
+			req.AddCookie(PlayerCookie(player))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		view := &TournamentView{}
+		json.NewDecoder(resp.Body).Decode(view)
+		return resp, view
+	}
+
+	if resp, _ := post("/tournaments", "", `{"Type":"scored"}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Error("Expected a cookie to be needed", resp.StatusCode)
+	}
+	if resp, _ := post("/tournaments", "a", `{"Type":"chess"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Error("Expected unknown games to be refused", resp.StatusCode)
+	}
+	resp, view := post("/tournaments", "a", `{"Name":"Set night","Type":"scored"}`)
+	if resp.StatusCode != http.StatusOK || !view.Host || view.State != TournamentOpen {
+		t.Fatal("Expected to make a tournament", resp.StatusCode, view)
+	}
+	id := view.Id
+	post("/tournaments/"+id+"/register", "a", `{"Name":"Alice"}`)
+	post("/tournaments/"+id+"/register", "b", `{"Name":"Bob"}`)
+	if resp, _ = post("/tournaments/"+id+"/start", "b", ""); resp.StatusCode != http.StatusForbidden {
+		t.Error("Expected only the host to start", resp.StatusCode)
+	}
+	resp, view = post("/tournaments/"+id+"/start", "a", "")
+	if resp.StatusCode != http.StatusOK || view.State != TournamentPlaying || view.Room == "" {
+		t.Fatal("Expected the host to start it", resp.StatusCode, view)
+	}
+
+	// a restart picks the tournament back up, still waiting on the table
+	cleanTournaments()
+	if err := RestoreTournaments(store); err != nil {
+		t.Fatal(err)
+	}
+	if tournaments.table(view.Room) == nil {
+		t.Fatal("Expected the table to still be waiting")
+	}
+	resp, view = post("/tournaments/"+id+"/endround", "a", "")
+	if resp.StatusCode != http.StatusOK || view.State != TournamentFinished {
+		t.Error("Expected the host to end the round", resp.StatusCode, view)
+	}
+
+	res, err := http.Get(server.URL + "/tournaments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var infos []TournamentInfo
+	json.NewDecoder(res.Body).Decode(&infos)
+	if len(infos) != 1 || infos[0].Name != "Set night" || infos[0].Entrants != 2 {
+		t.Error("Expected the tournament in the list", infos)
+	}
+}
+
+func TestTableSeats(t *testing.T) {
+	RegisterBuilder("seated", newScored)
+	RegisterResults("seated")
+	RegisterMatchmaking("seated", Matchmaking{Min: 3, Max: 4})
+	defer delete(matchmaking, "seated")
+	defer cleanTournaments()
+
+	for _, size := range []int{2, 5} {
+		if _, err := NewTournament(&TournamentRequest{Type: "seated", TableSize: size}, "a"); err == nil {
+			t.Error("Expected tables the game can't be played at to be refused", size)
+		}
+	}
+	tourney, err := NewTournament(&TournamentRequest{Type: "seated", Format: FormatBracket}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tourney.TableSize != 3 {
+		t.Error("Expected tables to default to the fewest the game takes", tourney.TableSize)
+	}
+	tourney.Register("a", "A")
+	tourney.Register("b", "B")
+	if err = tourney.Start("a"); err == nil {
+		t.Fatal("Expected too few players for a table to be refused")
+	}
+	for _, pid := range []string{"c", "d", "e", "f", "g"} {
+		tourney.Register(pid, strings.ToUpper(pid))
+	}
+	if err = tourney.Start("a"); err != nil {
+		t.Fatal(err)
+	}
+	tourney.mu.Lock()
+	tables := tourney.round()
+	tourney.mu.Unlock()
+	if len(tables) != 2 {
+		t.Fatal("Expected seven players at two tables", len(tables))
+	}
+	for _, table := range tables {
+		if table.Room == "" || len(table.Players) < 3 || len(table.Players) > 4 {
+			t.Error("Expected every table to have a game", table)
+		}
+	}
+}
+
+func TestTableEnded(t *testing.T) {
+	RegisterBuilder("scored", newScored)
+	RegisterResults("scored")
+	RegisterLifecycle("scored", Lifecycle{Empty: time.Nanosecond})
+	defer delete(lifecycles, "scored")
+	defer cleanTournaments()
+
+	RegisterBuilder("unscored", newScored)
+	defer delete(builders, "unscored")
+	if _, err := NewTournament(&TournamentRequest{Type: "unscored"}, "a"); err == nil {
+		t.Error("Expected games that don't report results to be refused")
+	}
+
+	// start seats two players at one table and returns its room
+	start := func() (*Tournament, *Game) {
+		tourney, err := NewTournament(&TournamentRequest{Type: "scored"}, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tourney.Register("a", "A")
+		tourney.Register("b", "B")
+		if err = tourney.Start("a"); err != nil {
+			t.Fatal(err)
+		}
+		tourney.mu.Lock()
+		defer tourney.mu.Unlock()
+		return tourney, AllGames.Get(tourney.round()[0].Room)
+	}
+	finished := func(tourney *Tournament) bool {
+		tourney.mu.Lock()
+		defer tourney.mu.Unlock()
+		return tourney.State == TournamentFinished
+	}
+
+	reaped, game := start()
+	time.Sleep(time.Millisecond)
+	AllGames.reap()
+	<-game.done
+	if AllGames.Get(game.Id) != nil {
+		t.Fatal("Expected the empty table to be reaped")
+	}
+	if !finished(reaped) {
+		t.Error("Expected the reaped table to be forfeited so the tournament goes on")
+	}
+
+	stopped, game := start()
+	AllGames.remove(game)
+	if !finished(stopped) {
+		t.Error("Expected a stopped table to be forfeited too")
+	}
+}
+
+func TestTrimName(t *testing.T) {
+	if name := trimName("  ", "Player 1"); name != "Player 1" {
+		t.Error("Expected the fallback for a blank name", name)
+	}
+	if name := trimName(strings.Repeat("ü", 40), ""); name != strings.Repeat("ü", tournamentMaxName) {
+		t.Error("Expected long names to be cut between characters", name)
+	}
+}
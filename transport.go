@@ -0,0 +1,257 @@
+package wg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// For players whose proxies won't let websockets through: messages come down over Server-Sent Events or long
+// polling, and commands go up as POSTs. Both open a session and then run connHandler just like /ws, so the cookie
+// and everything after it works the same.
+
+const (
+	httpQueue    = 100              // messages waiting to go down before the client is considered gone
+	sseKeepAlive = 30 * time.Second // comment lines so proxies don't time out an idle stream
+	pollWait     = 25 * time.Second // how long a poll waits for something to happen
+	pollExpiry   = time.Minute      // a long poll session without any polls is closed
+	sessionParam = "session"
+)
+
+// SessionMsg is the first thing sent down, commands are posted with ?session= set to its id
+type SessionMsg struct {
+	Type    string
+	Session string
+}
+
+var sessions = struct {
+	sync.RWMutex
+	conns map[string]*httpConn
+}{conns: map[string]*httpConn{}}
+
+// httpConn is a Connector made of a queue of messages going down and one of commands coming up
+type httpConn struct {
+	id     string
+	header http.Header // from the request that opened the session
+	ip     string
+
+	out       chan []byte
+	in        chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	expire    *time.Timer
+}
+
+// newHttpConn starts a session, if expiry isn't 0 the session closes when it isn't polled for that long
+func newHttpConn(r *http.Request, expiry time.Duration) *httpConn {
+	c := &httpConn{
+		id:     uuid.New().String(),
+		header: r.Header.Clone(),
+		ip:     clientIp(r),
+		out:    make(chan []byte, httpQueue),
+		in:     make(chan []byte),
+		closed: make(chan struct{}),
+	}
+	if expiry > 0 {
+		c.expire = time.AfterFunc(expiry, func() { c.Close() })
+	}
+	sessions.Lock()
+	sessions.conns[c.id] = c
+	sessions.Unlock()
+	return c
+}
+
+func findSession(r *http.Request) *httpConn {
+	sessions.RLock()
+	defer sessions.RUnlock()
+	return sessions.conns[r.URL.Query().Get(sessionParam)]
+}
+
+func (c *httpConn) Send(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c.SendRaw(b)
+}
+
+func (c *httpConn) SendRaw(v []byte) {
+	select {
+	case c.out <- v:
+	case <-c.closed:
+	default:
+		log.Println("Session", c.id, "isn't keeping up, closing it")
+		c.Close()
+	}
+}
+
+func (c *httpConn) Recv(v interface{}) error {
+	select {
+	case b := <-c.in:
+		return json.Unmarshal(b, v)
+	case <-c.closed:
+		return io.EOF
+	}
+}
+
+func (c *httpConn) RecvRaw(v []byte) error {
+	select {
+	case b := <-c.in:
+		copy(v, b)
+		return nil
+	case <-c.closed:
+		return io.EOF
+	}
+}
+
+func (c *httpConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if c.expire != nil {
+			c.expire.Stop()
+		}
+		sessions.Lock()
+		delete(sessions.conns, c.id)
+		sessions.Unlock()
+	})
+	return nil
+}
+
+func (c *httpConn) Ip() string {
+	return c.ip
+}
+
+func (c *httpConn) Cookie(name string) (*http.Cookie, error) {
+	r := &http.Request{Header: c.header}
+	return r.Cookie(name)
+}
+
+// post hands a command to the session's game loop
+func post(w http.ResponseWriter, r *http.Request) {
+	conn := findSession(r)
+	if conn == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(MaxPayloadBytes)))
+	if err != nil {
+		http.Error(w, "Command too big", http.StatusRequestEntityTooLarge)
+		return
+	}
+	select {
+	case conn.in <- b:
+		w.WriteHeader(http.StatusNoContent)
+	case <-conn.closed:
+		http.Error(w, "Session not found", http.StatusNotFound)
+	case <-r.Context().Done():
+	}
+}
+
+// SSEHandler streams messages down with GET and takes commands with POST ?session=
+func SSEHandler(cmdHandler PlayerCommandHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			post(w, r)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming isn't supported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+
+		conn := newHttpConn(r, 0)
+		defer conn.Close()
+		conn.Send(&SessionMsg{Type: "session", Session: conn.id})
+		go connHandler(cmdHandler, conn)
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case msg := <-conn.out:
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", msg); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-keepAlive.C:
+				if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-conn.closed:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// PollHandler returns queued messages as a JSON list with GET, waiting for some if there are none, and takes
+// commands with POST. The first GET without ?session= starts a session.
+func PollHandler(cmdHandler PlayerCommandHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			post(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		if r.URL.Query().Get(sessionParam) == "" {
+			conn := newHttpConn(r, pollExpiry)
+			conn.Send(&SessionMsg{Type: "session", Session: conn.id})
+			go connHandler(cmdHandler, conn)
+			writePoll(w, r, conn)
+			return
+		}
+		conn := findSession(r)
+		if conn == nil || conn.expire == nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		conn.expire.Reset(pollExpiry)
+		writePoll(w, r, conn)
+		conn.expire.Reset(pollExpiry)
+	})
+}
+
+// writePoll waits for at least one message then sends everything that is queued
+func writePoll(w http.ResponseWriter, r *http.Request, conn *httpConn) {
+	var msgs []json.RawMessage
+	select {
+	case msg := <-conn.out:
+		msgs = append(msgs, msg)
+	case <-time.After(pollWait):
+	case <-conn.closed:
+	case <-r.Context().Done():
+		return
+	}
+drain:
+	for len(msgs) < httpQueue {
+		select {
+		case msg := <-conn.out:
+			msgs = append(msgs, msg)
+		default:
+			break drain
+		}
+	}
+	if msgs == nil {
+		msgs = []json.RawMessage{}
+	}
+	if err := json.NewEncoder(w).Encode(msgs); err != nil {
+		log.Println(err)
+	}
+}
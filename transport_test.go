@@ -0,0 +1,124 @@
+package wg
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echo sends every command back to the player it came from
+func echo(ws Connector, playerId string) {
+	for {
+		cmd := &Command{}
+		if err := ws.Recv(cmd); err != nil {
+			return
+		}
+		ws.Send(&MsgMsg{Type: cmd.Type, Msg: playerId})
+	}
+}
+
+func TestSSE(t *testing.T) {
+	server := httptest.NewServer(SSEHandler(echo))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.AddCookie(&http.Cookie{Name: COOKIE_NAME, Value: signId("BOOP")})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	next := func(v interface{}) {
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(line, "data: ") {
+				if err = json.Unmarshal([]byte(line[len("data: "):]), v); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+		}
+	}
+
+	var session SessionMsg
+	next(&session)
+	if session.Type != "session" || session.Session == "" {
+		t.Fatal("Expected a session", session)
+	}
+	var cookie cookieMsg
+	next(&cookie)
+	if cookie.Type != "cookie" || !strings.Contains(cookie.Cookie, "BOOP") {
+		t.Error("Expected the cookie to be kept", cookie)
+	}
+
+	post, err := http.Post(server.URL+"?session="+session.Session, "application/json", strings.NewReader(`{"Type":"hello"}`))
+	if err != nil || post.StatusCode != http.StatusNoContent {
+		t.Fatal("Expected command to be accepted", err, post.StatusCode)
+	}
+	var msg MsgMsg
+	next(&msg)
+	if msg.Type != "hello" || msg.Msg != "BOOP" {
+		t.Error("Expected the command to reach the player's handler", msg)
+	}
+
+	post, err = http.Post(server.URL+"?session=nope", "application/json", strings.NewReader(`{}`))
+	if err != nil || post.StatusCode != http.StatusNotFound {
+		t.Error("Expected unknown session to 404")
+	}
+}
+
+func TestPoll(t *testing.T) {
+	server := httptest.NewServer(PollHandler(echo))
+	defer server.Close()
+
+	poll := func(url string) []json.RawMessage {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var msgs []json.RawMessage
+		if err = json.NewDecoder(resp.Body).Decode(&msgs); err != nil {
+			t.Fatal(err)
+		}
+		return msgs
+	}
+
+	msgs := poll(server.URL)
+	var session SessionMsg
+	if err := json.Unmarshal(msgs[0], &session); err != nil || session.Session == "" {
+		t.Fatal("Expected a session", string(msgs[0]))
+	}
+	url := server.URL + "?session=" + session.Session
+	if len(msgs) == 1 {
+		msgs = poll(url)
+	} else {
+		msgs = msgs[1:]
+	}
+	var cookie cookieMsg
+	if err := json.Unmarshal(msgs[0], &cookie); err != nil || cookie.Type != "cookie" {
+		t.Error("Expected a new cookie", string(msgs[0]))
+	}
+
+	post, err := http.Post(url, "application/json", strings.NewReader(`{"Type":"hello"}`))
+	if err != nil || post.StatusCode != http.StatusNoContent {
+		t.Fatal("Expected command to be accepted", err)
+	}
+	var msg MsgMsg
+	if err = json.Unmarshal(poll(url)[0], &msg); err != nil || msg.Type != "hello" {
+		t.Error("Expected the command to be echoed", msg)
+	}
+
+	findSession(httptest.NewRequest("GET", "/?session="+session.Session, nil)).Close()
+	resp, err := http.Get(url)
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Error("Closed sessions should be gone")
+	}
+}
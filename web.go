@@ -5,7 +5,9 @@ import (
 	"golang.org/x/net/websocket"
 	"log"
 	"net/http"
-	"time"
+	"net/url"
+	"strings"
+	"sync/atomic"
 )
 
 type PlayerCommandHandler func(Connector, string)
@@ -19,25 +21,79 @@ func WsHandler(cmdHandler PlayerCommandHandler) websocket.Handler {
 	}
 }
 
+// CheckOrigin turns away browsers on pages from other sites, unless the site is one of origins.
+// Requests without an Origin header aren't from another site's page so they're let through.
+func CheckOrigin(origins []string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+			h.ServeHTTP(w, r)
+			return
+		}
+		for _, allowed := range origins {
+			if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+	})
+}
+
+// cookieMsg is the first thing sent on connect, with the protocols the server speaks and the token to resume
+// the session with if the connection drops
 type cookieMsg struct {
-	Type, Cookie string
+	Type, Cookie          string
+	Protocol, MinProtocol int
+	Resume                string
 }
 
 // testable!
 func connHandler(cmdHandler PlayerCommandHandler, ws Connector) {
 	defer ws.Close()
+	ip := ws.Ip()
+	if isBanned(ip) {
+		sendError(ws, ErrBanned, "You've been sending too much, come back later")
+		return
+	}
+	if !openConn(ip) {
+		sendError(ws, ErrTooManyConnections, "Too many connections")
+		return
+	}
+	defer closeConn(ip)
+	atomic.AddInt64(&connections, 1)
+	defer atomic.AddInt64(&connections, -1)
 
 	var playerId string
-	cookie, err := ws.Cookie(COOKIE_NAME)
-	if err == http.ErrNoCookie {
+	if cookie, err := ws.Cookie(COOKIE_NAME); err == nil && cookie.Value != "" {
+		var ok bool
+		if playerId, ok = verifyId(cookie.Value); !ok {
+			if playerId, ok = legacyId(cookie.Value); ok {
+				// the cookie sent back below is signed
+				log.Println("Signing the old cookie of", playerId, ws.Ip())
+			} else {
+				log.Println("Ignoring cookie with a bad signature from", ws.Ip())
+			}
+		}
+	}
+	if playerId == "" {
 		playerId = uuid.New().String()
 		log.Println("New player connected", playerId, ws.Ip())
 	} else {
-		playerId = cookie.Value
 		log.Println("Player returned", playerId, ws.Ip())
 	}
-	c := http.Cookie{Name: COOKIE_NAME, Value: playerId, Expires: time.Now().Add(24 * 365 * time.Hour)}
-	ws.Send(&cookieMsg{Type: "cookie", Cookie: c.String()})
+	c := PlayerCookie(playerId)
+	l := newLink(ws)
+	s := newSession(playerId, l)
+	// clients that know about protocols say hello back with the newest they speak
+	ws.Send(&cookieMsg{Type: "cookie", Cookie: c.String(), Protocol: ProtocolVersion, MinProtocol: minProtocol, Resume: s.token})
 
-	cmdHandler(ws, playerId)
+	cmdHandler(s, playerId)
+	s.end()
+	// a resumed session may still be using the connection
+	<-l.released
 }
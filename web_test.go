@@ -3,7 +3,10 @@ package wg
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/google/uuid"
 )
 
 type testHandler struct{}
@@ -38,7 +41,7 @@ func (c *fakeConn) Cookie(name string) (*http.Cookie, error) {
 
 func TestWsHandler(t *testing.T) {
 	r := httptest.NewRequest("GET", "/", nil)
-	r.AddCookie(&http.Cookie{Name: COOKIE_NAME, Value: "BOOP"})
+	r.AddCookie(&http.Cookie{Name: COOKIE_NAME, Value: signId("BOOP")})
 
 	var calledId string
 	conn := &fakeConn{req: r}
@@ -74,3 +77,68 @@ func TestWsHandler_NoCookie(t *testing.T) {
 		t.Error("Cookie not sent", c.Type, c.Cookie)
 	}
 }
+
+func TestWsHandler_ForgedCookie(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: COOKIE_NAME, Value: "BOOP.forged"})
+
+	var calledId string
+	connHandler(func(c Connector, id string) {
+		calledId = id
+	}, &fakeConn{req: r})
+
+	if calledId == "BOOP" || calledId == "" {
+		t.Error("Expected a forged cookie to get a new player", calledId)
+	}
+	if id, ok := verifyId(signId(calledId)); !ok || id != calledId {
+		t.Error("Expected signed ids to verify", id, ok)
+	}
+}
+
+func TestWsHandler_UnsignedCookie(t *testing.T) {
+	old := uuid.New().String()
+	connect := func() (string, *fakeConn) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: COOKIE_NAME, Value: old})
+		var calledId string
+		conn := &fakeConn{req: r}
+		connHandler(func(c Connector, id string) {
+			calledId = id
+		}, conn)
+		return calledId, conn
+	}
+
+	id, conn := connect()
+	if id != old {
+		t.Fatal("Expected a cookie from before signing to keep the player", id)
+	}
+	if c := conn.sentMsg.(*cookieMsg); !strings.Contains(c.Cookie, signId(old)) {
+		t.Error("Expected the cookie to be sent back signed", c.Cookie)
+	}
+	if id, _ = connect(); id == old {
+		t.Error("Expected an unsigned cookie to only be taken once")
+	}
+	if _, ok := legacyId("BOOP"); ok {
+		t.Error("Expected only ids to be taken unsigned", id)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	h := CheckOrigin([]string{"https://games.example.com/"}, &testHandler{})
+	for origin, code := range map[string]int{
+		"":                          http.StatusOK,
+		"http://example.com":        http.StatusOK, // same host as the request
+		"https://games.example.com": http.StatusOK,
+		"https://evil.example.com":  http.StatusForbidden,
+	} {
+		r := httptest.NewRequest("GET", "http://example.com/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != code {
+			t.Error("Origin", origin, "got", w.Code, "expected", code)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package wgtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jakecoffman/wg"
+)
+
+// Clock is a wg.Clock that only moves when the test advances it
+type Clock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+// NewClock starts at a fixed time so every run sees the same dates
+func NewClock() *Clock {
+	return &Clock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *Clock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *Clock) AfterFunc(d time.Duration, f func()) wg.Timer {
+	c.Lock()
+	defer c.Unlock()
+	t := &timer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward, calling the timers that come due in the order they were due
+func (c *Clock) Advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	var due, waiting []*timer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			waiting = append(waiting, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	c.timers = waiting
+	c.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].at.Before(due[j].at)
+	})
+	for _, t := range due {
+		t.f()
+	}
+}
+
+// Pending is how many timers haven't gone off or been stopped
+func (c *Clock) Pending() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.timers)
+}
+
+type timer struct {
+	clock *Clock
+	at    time.Time
+	f     func()
+}
+
+func (t *timer) Stop() bool {
+	c := t.clock
+	c.Lock()
+	defer c.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package wgtest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/jakecoffman/wg"
+)
+
+// Conn is a wg.Connector for a scripted player. It keeps everything it's sent, and Recv returns the commands the
+// test scripts for it.
+type Conn struct {
+	PlayerId string
+	ip       string
+
+	sync.Mutex
+	msgs   []interface{}
+	closed bool
+
+	cmds    chan *wg.Command
+	waiting chan struct{} // a Recv started, so the command before it was handed on
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewConn makes a connection for the player, its cookie is signed so it passes for a real browser's
+func NewConn(playerId string) *Conn {
+	return &Conn{
+		PlayerId: playerId,
+		ip:       playerId,
+		cmds:     make(chan *wg.Command),
+		waiting:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *Conn) Send(v interface{}) {
+	c.Lock()
+	defer c.Unlock()
+	c.msgs = append(c.msgs, v)
+}
+
+func (c *Conn) SendRaw(b []byte) {
+	c.Send(json.RawMessage(append([]byte(nil), b...)))
+}
+
+// Recv waits for the next scripted command, io.EOF means the connection was closed
+func (c *Conn) Recv(v interface{}) error {
+	select {
+	case c.waiting <- struct{}{}:
+	default:
+	}
+	select {
+	case cmd := <-c.cmds:
+		b, err := json.Marshal(struct {
+			Type    string
+			Version int
+			Data    json.RawMessage
+		}{cmd.Type, cmd.Version, cmd.Data})
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, v)
+	case <-c.done:
+		return io.EOF
+	}
+}
+
+func (c *Conn) RecvRaw(v []byte) error {
+	return io.EOF
+}
+
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		c.Lock()
+		c.closed = true
+		c.Unlock()
+		close(c.done)
+	})
+	return nil
+}
+
+// Closed reports whether the server hung up, or the test did
+func (c *Conn) Closed() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.closed
+}
+
+func (c *Conn) Ip() string {
+	return c.ip
+}
+
+func (c *Conn) Cookie(name string) (*http.Cookie, error) {
+	if name != wg.COOKIE_NAME {
+		return nil, http.ErrNoCookie
+	}
+	return wg.PlayerCookie(c.PlayerId), nil
+}
+
+// Msgs is everything the connection was sent, oldest first
+func (c *Conn) Msgs() []interface{} {
+	c.Lock()
+	defer c.Unlock()
+	return append([]interface{}(nil), c.msgs...)
+}
+
+// Clear forgets the messages sent so far, so later checks only see what comes after
+func (c *Conn) Clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.msgs = nil
+}
+
+// Last stores the newest message of ptr's type in ptr and reports if there was one. ptr points at the message
+// type, like *UpdateMsg, and messages match whether they were sent as values or pointers.
+func (c *Conn) Last(ptr interface{}) bool {
+	return c.Count(ptr) > 0
+}
+
+// Count is how many messages of ptr's type were sent, the newest is stored in ptr like Last
+func (c *Conn) Count(ptr interface{}) int {
+	target := reflect.ValueOf(ptr)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		panic("wgtest: messages are stored in a pointer")
+	}
+	target = target.Elem()
+	var count int
+	msgs := c.Msgs()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if v, ok := match(msgs[i], target.Type()); ok {
+			if count == 0 {
+				target.Set(v)
+			}
+			count++
+		}
+	}
+	return count
+}
+
+// match converts a message to typ if it's that type or a pointer to it, either way round
+func match(msg interface{}, typ reflect.Type) (reflect.Value, bool) {
+	if msg == nil {
+		return reflect.Value{}, false
+	}
+	v := reflect.ValueOf(msg)
+	switch {
+	case v.Type() == typ:
+		return v, true
+	case v.Kind() == reflect.Ptr && v.Type().Elem() == typ:
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		return v.Elem(), true
+	case typ.Kind() == reflect.Ptr && typ.Elem() == v.Type():
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p, true
+	}
+	return reflect.Value{}, false
+}
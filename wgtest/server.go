@@ -0,0 +1,157 @@
+package wgtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jakecoffman/wg"
+)
+
+// Server runs scripted clients through wg.ProcessPlayerCommands, the same way real connections are handled, so a
+// test can play whole sessions: joining by code, rejoining, leaving and reconnecting.
+type Server struct {
+	Clock *Clock
+
+	t       testing.TB
+	handle  func(wg.Connector, string)
+	clients []*Client
+}
+
+// NewServer serves games made by newGame, seeded and on a fake clock. Rate limits are lifted until the test ends,
+// and the games it made are stopped and forgotten.
+func NewServer(t testing.TB, newGame func(string, ...wg.Option) *wg.Game) *Server {
+	clock := NewClock()
+	s := &Server{Clock: clock, t: t}
+	s.handle = wg.ProcessPlayerCommands(func(id string, opts ...wg.Option) *wg.Game {
+		return newGame(id, append([]wg.Option{wg.WithClock(clock), wg.WithSeed(1)}, opts...)...)
+	})
+
+	rate, burst, ipRate, ipBurst := wg.CommandRate, wg.CommandBurst, wg.IpCommandRate, wg.IpCommandBurst
+	wg.CommandRate, wg.CommandBurst, wg.IpCommandRate, wg.IpCommandBurst = 1e9, 1e9, 1e9, 1e9
+	t.Cleanup(func() {
+		s.close()
+		wg.CommandRate, wg.CommandBurst, wg.IpCommandRate, wg.IpCommandBurst = rate, burst, ipRate, ipBurst
+	})
+	return s
+}
+
+// Connect opens a connection for the player, the way a browser with their cookie would
+func (s *Server) Connect(playerId string) *Client {
+	s.t.Helper()
+	c := &Client{Conn: NewConn(playerId), server: s, done: make(chan struct{})}
+	s.clients = append(s.clients, c)
+	go func() {
+		defer close(c.done)
+		s.handle(c.Conn, playerId)
+	}()
+	c.ready()
+	return c
+}
+
+// Advance moves the clock forward and waits until every client's game has handled the timers that went off
+func (s *Server) Advance(d time.Duration) {
+	s.t.Helper()
+	s.Clock.Advance(d)
+	for _, game := range s.games() {
+		game.Idle()
+	}
+}
+
+// games are the games the server's clients are in
+func (s *Server) games() []*wg.Game {
+	var games []*wg.Game
+	seen := map[*wg.Game]bool{}
+	for _, c := range s.clients {
+		if game := c.Game(); game != nil && !seen[game] {
+			seen[game] = true
+			games = append(games, game)
+		}
+	}
+	return games
+}
+
+func (s *Server) close() {
+	games := s.games()
+	for _, c := range s.clients {
+		c.Close()
+	}
+	for _, game := range games {
+		game.Stop()
+		wg.AllGames.Delete(game.Id)
+	}
+}
+
+// Client is one connection to a Server
+type Client struct {
+	*Conn
+	server *Server
+	done   chan struct{}
+}
+
+// Game is the game the server has the player in
+func (c *Client) Game() *wg.Game {
+	return wg.AllGames.Find(c.PlayerId)
+}
+
+// Do sends a command at the version of the player's game and waits until the game has handled it
+func (c *Client) Do(cmdType string, data interface{}) {
+	c.server.t.Helper()
+	version := 0
+	if game := c.Game(); game != nil {
+		version = game.Version
+	}
+	c.DoVersion(cmdType, version, data)
+}
+
+// DoVersion is Do for a command made against another version of the game
+func (c *Client) DoVersion(cmdType string, version int, data interface{}) {
+	t := c.server.t
+	t.Helper()
+	raw, err := encode(data)
+	if err != nil {
+		t.Fatal("Couldn't encode data for", cmdType, err)
+	}
+	select {
+	case c.cmds <- &wg.Command{Type: cmdType, Version: version, Data: raw}:
+	case <-c.done:
+		t.Fatal("Connection was closed before", cmdType)
+	case <-time.After(sendWait):
+		t.Fatal("Server didn't take", cmdType)
+	}
+	c.ready()
+	if game := c.Game(); game != nil {
+		game.Idle()
+	}
+}
+
+// ready waits until the server is reading the next command, so the last one has been handed to the game
+func (c *Client) ready() {
+	select {
+	case <-c.waiting:
+	case <-c.done:
+	case <-time.After(sendWait):
+		c.server.t.Fatal("Server stopped reading commands from", c.PlayerId)
+	}
+}
+
+// Close hangs up and waits until the server and the player's game have dealt with it
+func (c *Client) Close() {
+	game := c.Game()
+	c.Conn.Close()
+	<-c.done
+	if game != nil {
+		game.Idle()
+	}
+}
+
+// Expect is Last, failing the test when the client wasn't sent that kind of message
+func (c *Client) Expect(ptr interface{}) {
+	c.server.t.Helper()
+	expect(c.server.t, c.Conn, ptr)
+}
+
+// ExpectError fails the test unless the last message the client was shown is the error with this code
+func (c *Client) ExpectError(code string) *wg.MsgMsg {
+	c.server.t.Helper()
+	return expectError(c.server.t, c.Conn, code)
+}
@@ -0,0 +1,158 @@
+// Package wgtest runs games in tests one command at a time. Every step waits until the game has handled it, and
+// anything the game injected into itself because of it, so tests never sleep. Timers run off a fake clock the test
+// advances.
+package wgtest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jakecoffman/wg"
+)
+
+// sendWait is how long a command waits for a game that isn't taking them before the test fails
+const sendWait = 5 * time.Second
+
+// Game is a game being stepped by a test
+type Game struct {
+	*wg.Game
+	Clock *Clock
+
+	t       testing.TB
+	players map[string]*Player
+}
+
+// New makes a game with newGame, usually the game package's NewGame, seeded and on a fake clock. opts come after
+// those so they can be overridden. The game is stopped when the test ends.
+func New(t testing.TB, newGame func(string, ...wg.Option) *wg.Game, opts ...wg.Option) *Game {
+	t.Helper()
+	clock := NewClock()
+	opts = append([]wg.Option{wg.WithClock(clock), wg.WithSeed(1)}, opts...)
+	g := &Game{
+		Game:    newGame("test", opts...),
+		Clock:   clock,
+		t:       t,
+		players: map[string]*Player{},
+	}
+	t.Cleanup(g.Stop)
+	g.Wait()
+	return g
+}
+
+// Wait waits until the game is idle, failing the test if it stopped
+func (g *Game) Wait() {
+	g.t.Helper()
+	if !g.Idle() {
+		g.t.Fatal("Game stopped")
+	}
+}
+
+// Advance moves the clock forward and waits for the timers that went off to be handled
+func (g *Game) Advance(d time.Duration) {
+	g.t.Helper()
+	g.Clock.Advance(d)
+	g.Wait()
+}
+
+// Join adds a player to the game, or another connection for one that's already in
+func (g *Game) Join(playerId string) *Player {
+	g.t.Helper()
+	p := g.As(playerId)
+	p.Do("join", nil)
+	return p
+}
+
+// As is the scripted player with this id, whether or not they joined
+func (g *Game) As(playerId string) *Player {
+	p := g.players[playerId]
+	if p == nil {
+		p = &Player{Conn: NewConn(playerId), game: g}
+		g.players[playerId] = p
+	}
+	return p
+}
+
+// Step hands the game a command as is and waits until it's handled
+func (g *Game) Step(cmd *wg.Command) {
+	g.t.Helper()
+	select {
+	case g.Cmd <- cmd:
+	case <-time.After(sendWait):
+		g.t.Fatal("Game didn't take", cmd.Type)
+	}
+	g.Wait()
+}
+
+// Player is someone in a Game, with their own connection
+type Player struct {
+	*Conn
+	game *Game
+}
+
+// Do sends a command at the game's current version and waits until it's handled. data is encoded to JSON unless
+// it already is.
+func (p *Player) Do(cmdType string, data interface{}) {
+	p.game.t.Helper()
+	p.DoVersion(cmdType, p.game.Version, data)
+}
+
+// DoVersion is Do for a command made against another version of the game
+func (p *Player) DoVersion(cmdType string, version int, data interface{}) {
+	t := p.game.t
+	t.Helper()
+	raw, err := encode(data)
+	if err != nil {
+		t.Fatal("Couldn't encode data for", cmdType, err)
+	}
+	p.game.Step(&wg.Command{PlayerId: p.PlayerId, Ws: p.Conn, Type: cmdType, Version: version, Data: raw})
+}
+
+// Disconnect is the player's connection dropping
+func (p *Player) Disconnect() {
+	p.game.t.Helper()
+	p.game.Step(&wg.Command{PlayerId: p.PlayerId, Ws: p.Conn, Type: "disconnect"})
+}
+
+// Expect is Last, failing the test when the player wasn't sent that kind of message
+func (p *Player) Expect(ptr interface{}) {
+	p.game.t.Helper()
+	expect(p.game.t, p.Conn, ptr)
+}
+
+// ExpectError fails the test unless the last message the player was shown is the error with this code
+func (p *Player) ExpectError(code string) *wg.MsgMsg {
+	p.game.t.Helper()
+	return expectError(p.game.t, p.Conn, code)
+}
+
+func expect(t testing.TB, c *Conn, ptr interface{}) {
+	t.Helper()
+	if !c.Last(ptr) {
+		t.Fatalf("Player %v wasn't sent a %T", c.PlayerId, ptr)
+	}
+}
+
+func expectError(t testing.TB, c *Conn, code string) *wg.MsgMsg {
+	t.Helper()
+	var msg wg.MsgMsg
+	if !c.Last(&msg) {
+		t.Fatalf("Player %v wasn't sent an error, expected %v", c.PlayerId, code)
+	}
+	if msg.Code != code {
+		t.Fatalf("Player %v was sent %q (%v), expected %v", c.PlayerId, msg.Msg, msg.Code, code)
+	}
+	return &msg
+}
+
+func encode(data interface{}) (json.RawMessage, error) {
+	switch d := data.(type) {
+	case nil:
+		return nil, nil
+	case json.RawMessage:
+		return d, nil
+	case []byte:
+		return d, nil
+	}
+	return json.Marshal(data)
+}
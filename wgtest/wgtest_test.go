@@ -0,0 +1,160 @@
+package wgtest
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jakecoffman/wg"
+	"github.com/jakecoffman/wg/citadels"
+	"github.com/jakecoffman/wg/justone"
+	"github.com/jakecoffman/wg/resistance"
+	"github.com/jakecoffman/wg/setlib"
+)
+
+func TestClock(t *testing.T) {
+	clock := NewClock()
+	start := clock.Now()
+	var fired []int
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, 1) })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, 0) })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Expected only the first Stop to stop the timer")
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatal("Timers went off early", fired)
+	}
+	clock.Advance(2 * time.Second)
+	if len(fired) != 2 || fired[0] != 1 || fired[1] != 2 || clock.Pending() != 0 {
+		t.Error("Expected the timers to go off in order", fired, clock.Pending())
+	}
+	if clock.Now().Sub(start) != 2500*time.Millisecond {
+		t.Error("Clock didn't move", clock.Now())
+	}
+}
+
+func TestConnLast(t *testing.T) {
+	c := NewConn("a")
+	c.Send(&wg.MsgMsg{Type: "msg", Msg: "one"})
+	c.Send(wg.KickedMsg{Type: "kicked"})
+	c.Send(&wg.MsgMsg{Type: "msg", Msg: "two"})
+
+	var msg wg.MsgMsg
+	if !c.Last(&msg) || msg.Msg != "two" || c.Count(&msg) != 2 {
+		t.Error("Expected the newest message", msg)
+	}
+	var kicked *wg.KickedMsg
+	if !c.Last(&kicked) || kicked.Type != "kicked" {
+		t.Error("Expected values to match pointers", kicked)
+	}
+	var hello wg.HelloMsg
+	if c.Last(&hello) {
+		t.Error("Nothing like that was sent")
+	}
+	if cookie, err := c.Cookie(wg.COOKIE_NAME); err != nil || cookie.Value == "a" {
+		t.Error("Expected a signed cookie", cookie, err)
+	}
+}
+
+func TestGameTimers(t *testing.T) {
+	game := New(t, resistance.NewGame)
+	p1 := game.Join("1")
+	for i := 0; i < 4; i++ {
+		p1.Do("addbot", nil)
+	}
+	p1.Do("start", nil)
+	state := game.Rules.(*resistance.Resist)
+	// bots lead straight away, so play on until it's the player's turn to pick a team
+	for i := 0; state.State != "building"; i++ {
+		if i > 20 {
+			t.Fatal("Player never got to lead", state.State)
+		}
+		switch state.State {
+		case "voting":
+			p1.Do("voteteam", false)
+		case "mission":
+			p1.Do("votemission", true)
+		default:
+			t.Fatal("Game ended before the player led", state.State)
+		}
+	}
+
+	game.Advance(119 * time.Second)
+	if state.State != "building" {
+		t.Fatal("Timer went off early", state.State)
+	}
+	game.Advance(time.Second)
+	if state.State == "building" {
+		t.Error("Expected the timer to pick a team")
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(t, setlib.NewGame)
+
+	a := s.Connect("a")
+	a.Do("join", wg.JoinRequest{})
+	game := a.Game()
+	if game == nil {
+		t.Fatal("Expected join to make a game")
+	}
+
+	b := s.Connect("b")
+	b.Do("join", wg.JoinRequest{Id: game.Id})
+	if b.Game() != game {
+		t.Fatal("Expected b to join a's game")
+	}
+	var meta setlib.MetaMsg
+	if a.Expect(&meta); len(meta.Players) != 2 {
+		t.Error("Expected a to see b join", meta.Players)
+	}
+
+	a.Clear()
+	b.Close()
+	if a.Expect(&meta); meta.Players["b"].Connected {
+		t.Error("Expected a to see b leave", meta.Players["b"])
+	}
+
+	b = s.Connect("b")
+	b.Do("rejoin", nil)
+	if b.Game() != game {
+		t.Error("Expected b to rejoin the game they left")
+	}
+	b.Do("spectate", "nope")
+	b.ExpectError(wg.ErrNotFound)
+}
+
+// TestCatalogs makes sure every command a game publishes in its catalog is one its HandleCommand knows
+func TestCatalogs(t *testing.T) {
+	games := map[string]func(string, ...wg.Option) *wg.Game{
+		citadels.Name:   citadels.NewGame,
+		justone.Name:    justone.NewGame,
+		resistance.Name: resistance.NewGame,
+		setlib.Name:     setlib.NewGame,
+	}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	for name, newGame := range games {
+		game := New(t, newGame)
+		p := game.Join("1")
+		commands := wg.ProtocolSchema(name)["games"].(wg.Schema)[name].(wg.Schema)["commands"].(wg.Schema)
+		if len(commands) == 0 {
+			t.Error("Expected a catalog for", name)
+		}
+		for cmd := range commands {
+			logged.Reset()
+			p.Do(cmd, nil)
+			if strings.Contains(logged.String(), "Unknown message") {
+				t.Error(name, "doesn't handle", cmd)
+			}
+		}
+	}
+}